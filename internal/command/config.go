@@ -0,0 +1,251 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/wendisx/puzzle/pkg/cli"
+	"github.com/wendisx/puzzle/pkg/config"
+	"go.yaml.in/yaml/v3"
+)
+
+/*
+	puzzle config -- 检查和导出配置
+	1. validate: 按分层规则(默认值, 文件, profile, 环境变量)加载配置并校验, 一次列出全部问题及其所在文件行号.
+	2. schema: 导出配置的JSON Schema, 用于编辑器的补全和校验.
+	3. encrypt/decrypt: 使用环境变量PUZZLE_CONFIG_KEY中的密钥加密或解密配置值, 加密值形如 enc:...
+	4. show/get: 输出生效的配置或其中一项(如 server.port), 敏感值已脱敏, show支持yaml和json.
+	5. set: 修改配置文件中的一项并写回, 保留注释和键的顺序, 仅支持yaml文件.
+	6. diff: 比较两个配置文件中生效的值, 如 config diff a.yaml b.yaml.
+	配置文件由 --file 指定, --profile 指定profile覆盖文件.
+*/
+
+const (
+	_verb_config  = "config"
+	_short_config = "inspect, validate, export or encrypt the configuration"
+	_long_config  = "The configuration is loaded by layers: defaults, the file, the profile overlay and env."
+
+	_verb_config_validate      = ":config:validate"
+	_verb_config_schema        = ":config:schema"
+	_verb_config_encrypt       = ":config:encrypt"
+	_verb_config_decrypt       = ":config:decrypt"
+	_verb_config_show          = ":config:show"
+	_verb_config_get           = ":config:get"
+	_verb_config_set           = ":config:set"
+	_verb_config_diff          = ":config:diff"
+	_default_config_file       = "./demo/dev.yaml"
+	_flag_config_file          = "file"
+	_flag_config_profile       = "profile"
+	_flag_config_schema_output = "output"
+	_flag_config_show_output   = "output"
+)
+
+func MountBuiltinConfig(rootCmd *cobra.Command) {
+	_configCmd := &cli.Command{
+		Verb:      _verb_config,
+		ShortDesc: _short_config,
+		LongDesc:  _long_config,
+		PersistentFlags: []cli.Flag{
+			{FullName: _flag_config_file, ShortName: "f", Type: cli.FLAG_TYPE_STRING, Desc: "Specify the config file.", Default: _default_config_file},
+			{FullName: _flag_config_profile, ShortName: "p", Type: cli.FLAG_TYPE_STRING, Desc: "Specify the profile overlay, like dev.", Default: ""},
+		},
+		SubCommand: []cli.Command{
+			{
+				Verb:      "validate",
+				ShortDesc: "validate the config and list all problems",
+			},
+			{
+				Verb:      "schema",
+				ShortDesc: "export the JSON Schema of config",
+				LocalFlags: []cli.Flag{
+					{FullName: _flag_config_schema_output, ShortName: "o", Type: cli.FLAG_TYPE_STRING, Desc: "Specify the output file, stdout if empty.", Default: ""},
+				},
+			},
+			{
+				Verb:      "encrypt",
+				ShortDesc: "encrypt the value by the key in env PUZZLE_CONFIG_KEY, like: config encrypt 's3cret'",
+			},
+			{
+				Verb:      "decrypt",
+				ShortDesc: "decrypt the enc: value by the key in env PUZZLE_CONFIG_KEY",
+			},
+			{
+				Verb:      "show",
+				ShortDesc: "show the effective config with secrets redacted",
+				LocalFlags: []cli.Flag{
+					{FullName: _flag_config_show_output, ShortName: "o", Type: cli.FLAG_TYPE_STRING, Desc: "Specify the output format, yaml or json.", Default: config.OUTPUT_YAML},
+				},
+			},
+			{
+				Verb:      "get",
+				ShortDesc: "get the effective value of path, like: config get server.port",
+			},
+			{
+				Verb:      "set",
+				ShortDesc: "set the value of path in the config file, like: config set server.port 8080",
+			},
+			{
+				Verb:      "diff",
+				ShortDesc: "diff the values of two config files, like: config diff a.yaml b.yaml",
+			},
+		},
+	}
+	configCmd := cli.MountCmd("", _configCmd, config.DICTKEY_COMMAND)
+	validateCmd := cli.GetCommand(_verb_config_validate, "")
+	validateCmd.Args = cobra.NoArgs
+	validateCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		f_file, err := cmd.Flags().GetString(_flag_config_file)
+		if err != nil {
+			return err
+		}
+		_, err = config.ValidateFile(f_file, config.WithFlags(cmd.Flags()))
+		var errs config.ConfigErrors
+		if errors.As(err, &errs) {
+			for _, ce := range errs {
+				fmt.Fprintln(os.Stderr, ce.Error())
+			}
+			return fmt.Errorf("config(%s) is invalid, %d problem(s) found", f_file, len(errs))
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "config(%s) is valid\n", f_file)
+		return nil
+	}
+	schemaCmd := cli.GetCommand(_verb_config_schema, "")
+	schemaCmd.Args = cobra.NoArgs
+	schemaCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		f_output, err := cmd.Flags().GetString(_flag_config_schema_output)
+		if err != nil {
+			return err
+		}
+		schema, err := config.JSONSchema()
+		if err != nil {
+			return err
+		}
+		if f_output == "" {
+			_, err = fmt.Fprintln(os.Stdout, string(schema))
+			return err
+		}
+		return os.WriteFile(f_output, append(schema, '\n'), 0644)
+	}
+	encryptCmd := cli.GetCommand(_verb_config_encrypt, "")
+	encryptCmd.Args = cobra.ExactArgs(1)
+	encryptCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		key, err := config.SecretKey()
+		if err != nil {
+			return err
+		}
+		value, err := config.EncryptValue(key, args[0])
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(os.Stdout, value)
+		return err
+	}
+	decryptCmd := cli.GetCommand(_verb_config_decrypt, "")
+	decryptCmd.Args = cobra.ExactArgs(1)
+	decryptCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		key, err := config.SecretKey()
+		if err != nil {
+			return err
+		}
+		plain, err := config.DecryptValue(key, args[0])
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(os.Stdout, plain)
+		return err
+	}
+	showCmd := cli.GetCommand(_verb_config_show, "")
+	showCmd.Args = cobra.NoArgs
+	showCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		f_output, err := cmd.Flags().GetString(_flag_config_show_output)
+		if err != nil {
+			return err
+		}
+		c, err := loadEffectiveConfig(cmd)
+		if err != nil {
+			return err
+		}
+		out, err := config.MarshalConfig(c.Redact(), f_output)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(os.Stdout, strings.TrimRight(string(out), "\n"))
+		return err
+	}
+	getCmd := cli.GetCommand(_verb_config_get, "")
+	getCmd.Args = cobra.ExactArgs(1)
+	getCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		c, err := loadEffectiveConfig(cmd)
+		if err != nil {
+			return err
+		}
+		value, err := config.ConfigValue(c.Redact(), args[0])
+		if err != nil {
+			return err
+		}
+		switch reflect.ValueOf(value).Kind() {
+		case reflect.Struct, reflect.Pointer, reflect.Slice, reflect.Map:
+			out, err := yaml.Marshal(value)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprint(os.Stdout, string(out))
+			return err
+		default:
+			_, err = fmt.Fprintln(os.Stdout, value)
+			return err
+		}
+	}
+	setCmd := cli.GetCommand(_verb_config_set, "")
+	setCmd.Args = cobra.ExactArgs(2)
+	setCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		f_file, err := cmd.Flags().GetString(_flag_config_file)
+		if err != nil {
+			return err
+		}
+		if err = config.SetFileValue(f_file, args[0], args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "set %s in config(%s)\n", args[0], f_file)
+		return nil
+	}
+	diffCmd := cli.GetCommand(_verb_config_diff, "")
+	diffCmd.Args = cobra.ExactArgs(2)
+	diffCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		a, err := config.LoadFile(args[0])
+		if err != nil {
+			return err
+		}
+		b, err := config.LoadFile(args[1])
+		if err != nil {
+			return err
+		}
+		changes := config.DiffConfig(a, b)
+		if len(changes) == 0 {
+			fmt.Fprintf(os.Stderr, "no difference between %s and %s\n", args[0], args[1])
+			return nil
+		}
+		for _, ch := range changes {
+			fmt.Fprintf(os.Stdout, "%s: %s -> %s\n", ch.Path, ch.Old, ch.New)
+		}
+		return nil
+	}
+	rootCmd.AddCommand(configCmd)
+}
+
+// loadEffectiveConfig load the config of --file by layers into the config dict.
+func loadEffectiveConfig(cmd *cobra.Command) (*config.Config, error) {
+	f_file, err := cmd.Flags().GetString(_flag_config_file)
+	if err != nil {
+		return nil, err
+	}
+	c, _, err := config.LoadLayered(f_file, config.WithFlags(cmd.Flags()))
+	return c, err
+}
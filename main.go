@@ -15,5 +15,7 @@ func main() {
 		command.MountBuiltinVersion,
 		command.MountBuiltinInit,
 		command.MountBuiltinNew,
+		command.MountBuiltinConfig,
+		cli.MountEnv,
 	)
 }
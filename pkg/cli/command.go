@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"io/fs"
 	"strconv"
 	"strings"
 
@@ -74,19 +75,32 @@ func LoadCmd(path string) *Cli {
 		path = _default_command_path
 	}
 	var cli Cli
-	// todo: Perhaps different configuration file suffixes could be used to parse this,
-	// but JSON is already sufficient for the current functionality.
-	if err := util.ParseJsonFile(path, &cli); err != nil {
+	// the format is detected by suffix or content, like command.json or command.yaml.
+	if err := util.ParseFile(path, &cli); err != nil {
 		clog.Panic(err.Error())
 		return nil
 	}
+	return loadCmd(&cli)
+}
+
+// LoadCmdFS is LoadCmd with the file in fsys, like the command file in embed.FS.
+func LoadCmdFS(fsys fs.FS, name string) *Cli {
+	var cli Cli
+	if err := util.ParseFS(fsys, name, &cli); err != nil {
+		clog.Panic(err.Error())
+		return nil
+	}
+	return loadCmd(&cli)
+}
+
+func loadCmd(cli *Cli) *Cli {
 	// put cli into config dict
 	configDict := config.GetDict(config.DICTKEY_CONFIG)
-	configDict.Record(config.DATAKEY_CLI, &cli)
+	configDict.Record(config.DATAKEY_CLI, cli)
 	// load all command to dict_key(_dict_command) data dict
 	var cmdDict config.DataDict[any]
 	if !config.HasDict(config.DICTKEY_COMMAND) {
-		cmdDict = config.NewDataDict[any](config.DICTKEY_COMMAND)
+		cmdDict = config.NewDataDict[any](config.DICTKEY_COMMAND, config.WithNoExpiry())
 		config.PutDict(cmdDict.Name(), cmdDict)
 	} else {
 		cmdDict = config.GetDict(config.DICTKEY_COMMAND)
@@ -95,7 +109,7 @@ func LoadCmd(path string) *Cli {
 		// mount command and flags
 		_ = mountCmd("", &cli.Commands[i], cmdDict)
 	}
-	return &cli
+	return cli
 }
 
 // GetCmd return the pointer to Cli from config dict and will panic if not exists Cli.
@@ -245,10 +259,11 @@ func quickMountFLag(verb string, originCmd *Command, treeCmd *cobra.Command) {
 		expandFlag(flags, f)
 	}
 	clog.Info(fmt.Sprintf("parse verb(%s) local +[%s] Flags", palette.SkyBlue(verb), palette.Green(len(originCmd.LocalFlags))))
+	// persistent flags are inherited by subcommands
 	for _, f := range originCmd.PersistentFlags {
-		expandFlag(flags, f)
+		expandFlag(treeCmd.PersistentFlags(), f)
 	}
-	clog.Info(fmt.Sprintf("parse verb(%s) persistent +[%s] Flags", palette.SkyBlue(verb), palette.Green(len(originCmd.LocalFlags))))
+	clog.Info(fmt.Sprintf("parse verb(%s) persistent +[%s] Flags", palette.SkyBlue(verb), palette.Green(len(originCmd.PersistentFlags))))
 	clog.Info(fmt.Sprintf("for verb(%s) mount local and persistent flags", palette.SkyBlue(verb)))
 }
 
@@ -1,13 +1,19 @@
 package cli
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/wendisx/puzzle/pkg/clog"
 	"github.com/wendisx/puzzle/pkg/config"
+	"github.com/wendisx/puzzle/pkg/event"
 )
 
 // test basic load cmd [passed]
@@ -114,3 +120,75 @@ func Test_get_flagvalue(t *testing.T) {
 	clog.Info(fmt.Sprintf("%v", pFlags.Lookup("port")))
 	clog.Info(fmt.Sprintf("%v", lFlags.Lookup("config")))
 }
+
+// test load yaml command file from fs [passed]
+func Test_load_cmd_fs(t *testing.T) {
+	config.LoadConfigFS(fstest.MapFS{"config.yaml": {Data: []byte("server:\n  port: 80\n")}}, "config.yaml")
+	fsys := fstest.MapFS{"command.yaml": {Data: []byte(`app: demo
+commands:
+  - verb: greet
+    shortDesc: say hello
+    subCommands:
+      - verb: loud
+        localFlags:
+          - fullName: times
+            shortName: t
+            type: int
+            desc: repeat times
+            default: "1"
+`)}}
+	cli := LoadCmdFS(fsys, "command.yaml")
+	if cli.App != "demo" || cli.Commands[0].ShortDesc != "say hello" {
+		t.Fatalf("unexpected cli %+v", cli)
+	}
+	loud := GetCommand(":greet:loud", "")
+	if loud.Flags().Lookup("times") == nil {
+		t.Fatal("expected flag times mounted")
+	}
+}
+
+// test jobs of the scheduler mounted by application [passed]
+func Test_mount_jobs(t *testing.T) {
+	config.LoadDict(config.DICTKEY_COMMAND)
+	s := event.NewScheduler(event.WithScheduleDispatcher(event.NewDispatcher()))
+	runs := 0
+	if err := s.AddJob("cleanup", "@daily", func(ctx context.Context, at time.Time) event.Event {
+		runs++
+		return event.NewTypedEvent("app.cleanup", at)
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	rootCmd := &cobra.Command{Use: "app"}
+	MountJobs(s)(rootCmd)
+	rootCmd.SetArgs([]string{"jobs", "run", "cleanup"})
+	if err := rootCmd.Execute(); err != nil || runs != 1 {
+		t.Fatalf("expected cleanup run once but got %d, %v", runs, err)
+	}
+	rootCmd.SetArgs([]string{"jobs", "run", "unknown"})
+	if err := rootCmd.Execute(); err == nil {
+		t.Fatal("expected unknown job fail")
+	}
+}
+
+// test env check of the structs registered by application [passed]
+func Test_mount_env(t *testing.T) {
+	config.LoadDict(config.DICTKEY_COMMAND)
+	config.LoadDict(config.DICTKEY_CONFIG)
+	config.RegisterEnv("TESTAPP", &struct {
+		Token string `envconfig:"TOKEN" required:"true" desc:"api token"`
+	}{})
+	output := filepath.Join(t.TempDir(), ".env.example")
+	rootCmd := &cobra.Command{Use: "app", SilenceUsage: true}
+	MountEnv(rootCmd)
+	rootCmd.SetArgs([]string{"env", "check", "-d", "", "-o", output})
+	if err := rootCmd.Execute(); err == nil || !strings.Contains(err.Error(), "TESTAPP_TOKEN") {
+		t.Fatalf("expected TESTAPP_TOKEN missing but got %v", err)
+	}
+	if raw, err := os.ReadFile(output); err != nil || !strings.Contains(string(raw), "# TESTAPP") {
+		t.Fatalf("unexpected example %s %v", raw, err)
+	}
+	t.Setenv("TESTAPP_TOKEN", "t")
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("expected all env set but got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/wendisx/puzzle/pkg/config"
+)
+
+/*
+	env -- check the environment variables
+	1. check: load .env and .env.local (or the files of --dotenv separated by comma), then
+	check the registered env structs by the tags of envconfig, list the missing required
+	variables and print an example .env built from the tags (default, required, desc).
+The command only sees the structs registered in the process running it, the env of
+puzzle itself with prefix PUZZLE is always checked. So an application registers its
+structs by config.RegisterEnv(prefix, &env) or config.LoadEnv and mounts the command
+on its own root, then `<app> env check` checks them:
+
+	config.RegisterEnv("APP", &AppEnv{})
+	cli.Execute(cli.MountServer, cli.MountEnv)
+*/
+
+const (
+	_verb_env  = "env"
+	_short_env = "check the environment variables"
+	_long_env  = "The env structs registered by prefix are checked by the tags of envconfig."
+
+	_verb_env_check       = ":env:check"
+	_env_prefix_puzzle    = "PUZZLE"
+	_default_dotenv_files = config.DOTENV_FILE + "," + config.DOTENV_LOCAL_FILE
+	_flag_env_dotenv      = "dotenv"
+	_flag_env_output      = "output"
+)
+
+// MountEnv mount the verb-env to the instruction tree, for the env structs registered before.
+func MountEnv(rootCmd *cobra.Command) {
+	_envCmd := &Command{
+		Verb:      _verb_env,
+		ShortDesc: _short_env,
+		LongDesc:  _long_env,
+		SubCommand: []Command{
+			{
+				Verb:      "check",
+				ShortDesc: "list the missing required variables and print an example .env",
+				LocalFlags: []Flag{
+					{FullName: _flag_env_dotenv, ShortName: "d", Type: FLAG_TYPE_STRING, Desc: "Specify the dotenv files separated by comma.", Default: _default_dotenv_files},
+					{FullName: _flag_env_output, ShortName: "o", Type: FLAG_TYPE_STRING, Desc: "Specify the output file of example, stdout if empty.", Default: ""},
+				},
+			},
+		},
+	}
+	envCmd := MountCmd("", _envCmd, config.DICTKEY_COMMAND)
+	checkCmd := GetCommand(_verb_env_check, "")
+	checkCmd.Args = cobra.NoArgs
+	checkCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		f_dotenv, err := cmd.Flags().GetString(_flag_env_dotenv)
+		if err != nil {
+			return err
+		}
+		f_output, err := cmd.Flags().GetString(_flag_env_output)
+		if err != nil {
+			return err
+		}
+		var files []string
+		for f := range strings.SplitSeq(f_dotenv, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				files = append(files, f)
+			}
+		}
+		if _, err = config.LoadDotenv(files...); err != nil {
+			return err
+		}
+		if _, found := config.EnvOf(_env_prefix_puzzle); !found {
+			config.RegisterEnv(_env_prefix_puzzle, &config.PuzzleEnv{})
+		}
+		var sb strings.Builder
+		for _, prefix := range config.EnvPrefixes() {
+			dest, _ := config.EnvOf(prefix)
+			for _, ev := range config.MissingEnv(prefix, dest) {
+				fmt.Fprintf(os.Stderr, "missing %s (%s) %s\n", ev.Key, ev.Type, ev.Desc)
+			}
+			fmt.Fprintf(&sb, "# %s\n%s\n", prefix, config.ExampleDotenv(prefix, dest))
+		}
+		example := strings.TrimRight(sb.String(), "\n") + "\n"
+		if f_output == "" {
+			_, err = fmt.Fprint(os.Stdout, example)
+		} else {
+			err = os.WriteFile(f_output, []byte(example), 0644)
+		}
+		if err != nil {
+			return err
+		}
+		if err = config.CheckEnv(); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "all required env are set")
+		return nil
+	}
+	rootCmd.AddCommand(envCmd)
+}
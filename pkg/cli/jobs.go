@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wendisx/puzzle/pkg/config"
+	"github.com/wendisx/puzzle/pkg/event"
+)
+
+/*
+	jobs -- list or run the scheduled jobs of an application
+	1. list: all jobs registered to the scheduler, with their last and next run.
+	2. run: run a job immediately once, its schedule is not changed.
+Jobs are registered in code by AddJob, so the command is mounted by the application
+binary owning the scheduler, with the same store, never by the puzzle tool itself:
+
+	s := event.NewScheduler(event.WithScheduleStore(store))
+	s.AddJob("cleanup", "@daily", cleanup)
+	cli.Execute(cli.MountServer, cli.MountJobs(s))
+*/
+
+const (
+	_verb_jobs  = "jobs"
+	_short_jobs = "list or run scheduled jobs"
+	_long_jobs  = "Jobs are the ones registered to the scheduler by the application."
+
+	_verb_jobs_list      = ":jobs:list"
+	_verb_jobs_run       = ":jobs:run"
+	_jobs_time_layout    = "2006-01-02 15:04:05"
+	_jobs_never_time_str = "-"
+)
+
+// MountJobs return the mount of verb-jobs for the jobs of s, nil s is event.DefaultScheduler().
+func MountJobs(s *event.Scheduler) func(rootCmd *cobra.Command) {
+	return func(rootCmd *cobra.Command) {
+		if s == nil {
+			s = event.DefaultScheduler()
+		}
+		mountJobs(rootCmd, s)
+	}
+}
+
+func mountJobs(rootCmd *cobra.Command, s *event.Scheduler) {
+	_jobsCmd := &Command{
+		Verb:      _verb_jobs,
+		ShortDesc: _short_jobs,
+		LongDesc:  _long_jobs,
+		SubCommand: []Command{
+			{
+				Verb:      "list",
+				ShortDesc: "list all scheduled jobs",
+			},
+			{
+				Verb:      "run",
+				ShortDesc: "run the job immediately, like: jobs run cleanup",
+			},
+		},
+	}
+	jobsCmd := MountCmd("", _jobsCmd, config.DICTKEY_COMMAND)
+	listCmd := GetCommand(_verb_jobs_list, "")
+	listCmd.Args = cobra.NoArgs
+	listCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		jobs, err := s.Jobs(cmd.Context())
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSPEC\tLAST\tNEXT")
+		for _, j := range jobs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", j.Name, j.Spec, jobTime(j.Last), jobTime(j.Next))
+		}
+		return w.Flush()
+	}
+	runCmd := GetCommand(_verb_jobs_run, "")
+	runCmd.Args = cobra.ExactArgs(1)
+	runCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		err := s.RunJob(cmd.Context(), args[0])
+		if errors.Is(err, event.ErrJobNotFound) {
+			return fmt.Errorf("job(%s) not found, see `jobs list`", args[0])
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "job(%s) done\n", args[0])
+		return nil
+	}
+	rootCmd.AddCommand(jobsCmd)
+}
+
+func jobTime(t time.Time) string {
+	if t.IsZero() {
+		return _jobs_never_time_str
+	}
+	return t.Format(_jobs_time_layout)
+}
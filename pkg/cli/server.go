@@ -1,10 +1,12 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/spf13/cobra"
 	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/config"
 	"github.com/wendisx/puzzle/pkg/router"
 	"github.com/wendisx/puzzle/pkg/server"
 )
@@ -13,11 +15,16 @@ const (
 	_flag_handler = "handler"
 	_flag_check   = "check"
 	_flag_swag    = "swag"
+	_flag_host    = "host"
+	_flag_port    = "port"
+	_flag_config  = "config"
 )
 
 var (
 	_verb_server       = ":server"
 	_verb_server_start = ":server:start"
+
+	ErrJwtSecretMissing = errors.New("server.jwtSecret is not configured, set it in the config file or by PUZZLE_SERVER_JWT_SECRET")
 )
 
 // MountServer mount the verb-server to the instruction tree.
@@ -29,10 +36,30 @@ func MountServer(rootCmd *cobra.Command) {
 		hf, err := cmd.Flags().GetString(_flag_handler)
 		checkf, err := cmd.Flags().GetBool(_flag_check)
 		swagf, err := cmd.Flags().GetBool(_flag_swag)
+		configf, err := cmd.Flags().GetString(_flag_config)
+		if err != nil {
+			clog.Error(err.Error())
+			return err
+		}
+		// flag > env > file, the config is recorded into the config dict for the server.
+		loadOpts := []config.LoadOption{config.WithFlags(cmd.Flags()),
+			config.WithFlagAlias(_flag_host, "server.host"), config.WithFlagAlias(_flag_port, "server.port")}
+		c, _, err := config.LoadLayered(configf, loadOpts...)
 		if err != nil {
 			clog.Error(err.Error())
 			return err
 		}
+		if c.ServerConfig.JwtSecret == "" {
+			// the built-in secret is known to everyone, tokens signed by it prove nothing
+			clog.Error(ErrJwtSecretMissing.Error())
+			return ErrJwtSecretMissing
+		}
+		flagAddr := cmd.Flags().Changed(_flag_host) || cmd.Flags().Changed(_flag_port)
+		if flagAddr {
+			// addr of file or env would shadow the host and port of flags
+			c.ServerConfig.Addr = ""
+		}
+		applyLogConfig(c.LogConfig)
 		server := server.InitWebServer(hf)
 		if checkf {
 			server.WithPeer(router.NewEchoCheckPeer())
@@ -40,6 +67,12 @@ func MountServer(rootCmd *cobra.Command) {
 		if swagf {
 			server.WithPeer(router.NewEchoSwagPeer())
 		}
+		r, err := watchConfig(server, configf, flagAddr, loadOpts...)
+		if err != nil {
+			clog.Error(err.Error())
+			return err
+		}
+		defer r.Stop()
 		server.Start()
 		return nil
 	}
@@ -51,3 +84,31 @@ func MountServer(rootCmd *cobra.Command) {
 	}
 	rootCmd.AddCommand(serverCmd)
 }
+
+// watchConfig start the reloader of config path, on file changes and SIGHUP the server section
+// is applied to ws and the log section to the default logger.
+func watchConfig(ws server.WebServer, path string, flagAddr bool, opts ...config.LoadOption) (*config.Reloader, error) {
+	r := config.NewReloader(path, config.WithReloadLoad(opts...))
+	if rs, ok := ws.(server.Reconfigurable); ok {
+		config.OnSection(r, func(d config.ConfigDiff[config.ServerConfig]) error {
+			sc := d.New
+			if flagAddr {
+				sc.Addr = ""
+			}
+			return rs.Reconfigure(sc)
+		})
+	}
+	config.OnSection(r, func(d config.ConfigDiff[config.LogConfig]) error {
+		applyLogConfig(d.New)
+		return nil
+	})
+	if err := r.Start(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func applyLogConfig(lc config.LogConfig) {
+	lv, _ := lc.LogLevel()
+	clog.DefaultLevel(lv)
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/config"
+	"github.com/wendisx/puzzle/pkg/router"
+	"github.com/wendisx/puzzle/pkg/server"
+)
+
+// absolute before tests change the working directory
+var _test_command_path, _ = filepath.Abs(_default_command_path)
+
+func test_server_cmd(t *testing.T, args ...string) error {
+	t.Helper()
+	config.LoadDict(config.DICTKEY_CONFIG)
+	_ = LoadCmd(_test_command_path)
+	rootCmd := &cobra.Command{Use: "app", SilenceUsage: true}
+	MountServer(rootCmd)
+	rootCmd.SetArgs(append([]string{"server", "start"}, args...))
+	return rootCmd.Execute()
+}
+
+// test server start refuses to sign tokens by the built-in secret [passed]
+func Test_server_start_secret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("server:\n  port: 3333\n"), 0o600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := test_server_cmd(t, "--config", path); !errors.Is(err, ErrJwtSecretMissing) {
+		t.Fatalf("expected ErrJwtSecretMissing but got %v", err)
+	}
+}
+
+// test server start without config file loads the defaults, env and flags [passed]
+func Test_server_start_default(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("PUZZLE_SERVER_JWT_SECRET", "env-secret")
+	// the port is taken, so the server returns once it fails to listen
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer l.Close()
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	if err = test_server_cmd(t, "--host", "127.0.0.1", "--port", port); err != nil {
+		t.Fatalf("expected start without config file but got %v", err)
+	}
+	c, found := config.Get[*config.Config](config.DICTKEY_CONFIG, config.DATAKEY_CONFIG)
+	if !found || c.ServerConfig.JwtSecret != "env-secret" || strconv.Itoa(c.ServerConfig.Port) != port {
+		t.Fatalf("unexpected config %+v", c)
+	}
+	if err = test_server_cmd(t, "--config", "missing.yaml"); err == nil {
+		t.Fatal("expected the given config file required")
+	}
+}
+
+func test_free_port(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// wait until the port is listened, or not if up is false
+func test_wait_port(t *testing.T, port int, up bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+		}
+		if (err == nil) == up {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected port %d listened %t", port, up)
+		}
+	}
+}
+
+// test the server and log sections are applied by reload without restart [passed]
+func Test_server_reload(t *testing.T) {
+	defer clog.DefaultLevel(clog.INFO)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(port int, level string) {
+		raw := fmt.Sprintf("server:\n  host: 127.0.0.1\n  port: %d\n  jwtSecret: reload-secret\nlog:\n  level: %s\n", port, level)
+		if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	before, after := test_free_port(t), test_free_port(t)
+	write(before, "info")
+	if _, _, err := config.LoadLayered(path); err != nil {
+		t.Fatal(err.Error())
+	}
+	ws := server.InitWebServer("Echo")
+	ws.WithPeer(router.NewEchoCheckPeer())
+	r, err := watchConfig(ws, path, false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer r.Stop()
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ws.Start()
+	}()
+	test_wait_port(t, before, true)
+	write(after, "debug")
+	if err = r.Reload(); err != nil {
+		t.Fatal(err.Error())
+	}
+	test_wait_port(t, after, true)
+	test_wait_port(t, before, false)
+	if !clog.Enabled(clog.DEBUG) {
+		t.Fatal("expected log level debug after reload")
+	}
+	// a bad level is rejected and the config in use is kept
+	write(after, "loud")
+	if err = r.Reload(); err == nil || r.Current().LogConfig.Level != "debug" {
+		t.Fatalf("expected bad level rejected but got %v", err)
+	}
+	ws.Stop()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected server exit")
+	}
+}
@@ -111,6 +111,11 @@ func _format_prefix() string {
 	return "-"
 }
 
+// Enabled report whether the global logger records level.
+func Enabled(level LogLevel) bool {
+	return _default_logger.Enabled(context.Background(), level)
+}
+
 // SetDefault update global logger with l.
 func SetDefault(l *Logger) {
 	_default_logger = l
@@ -3,6 +3,8 @@ package config
 
 import (
 	"fmt"
+	"io/fs"
+	"os"
 
 	"github.com/wendisx/puzzle/pkg/clog"
 	"github.com/wendisx/puzzle/pkg/palette"
@@ -45,11 +47,13 @@ var (
 
 // Config record record all possible configuration items.
 type Config struct {
-	EnvConfig    []string     `yaml:"environment" json:"environment"` // special environment config
-	GithubConfig GithubConfig `yaml:"github" json:"github"`           // github config
-	DBConfig     DBConfig     `yaml:"database" json:"database"`       // database config
-	ServerConfig ServerConfig `yaml:"server" json:"server"`           // server config
-	SwagConfig   SwagConfig   `yaml:"swagger" json:"swagger"`         // swagger config
+	EnvConfig    []string               `yaml:"environment" json:"environment"` // special environment config
+	GithubConfig GithubConfig           `yaml:"github" json:"github"`           // github config
+	DBConfig     DBConfig               `yaml:"database" json:"database"`       // database config
+	ServerConfig ServerConfig           `yaml:"server" json:"server"`           // server config
+	SwagConfig   SwagConfig             `yaml:"swagger" json:"swagger"`         // swagger config
+	LogConfig    LogConfig              `yaml:"log" json:"log"`                 // log config
+	FlagsConfig  map[string]*FlagConfig `yaml:"flags" json:"flags"`             // feature flags by name
 }
 
 // DefaultConfigFile set default config file.
@@ -57,30 +61,60 @@ func DefaultConfigPath(path string) {
 	_default_config_path = path
 }
 
-// LoadConfig return a pointer to all config and will panic if not exists the file path.
+// LoadConfig return a pointer to all config and will panic if not exists the file path or the config is invalid.
+// The file can be YAML, JSON, TOML or dotenv, see util.DetectFormat.
 func LoadConfig(path string) *Config {
-	// init config dict here.
-	var configDict DataDict[any]
-	if HasDict(DICTKEY_CONFIG) {
-		configDict = GetDict(DICTKEY_CONFIG)
-	} else {
-		configDict = NewDataDict[any](DICTKEY_CONFIG)
-		PutDict(configDict.Name(), configDict)
+	if path == "" {
+		path = _default_config_path
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		clog.Panic(fmt.Sprintf("%s", err.Error()))
+		return nil
 	}
-	c := &Config{
+	return loadConfig(path, raw)
+}
+
+// LoadConfigFS is LoadConfig with the file in fsys, like the default config in embed.FS.
+func LoadConfigFS(fsys fs.FS, name string) *Config {
+	raw, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		clog.Panic(fmt.Sprintf("%s", err.Error()))
+		return nil
+	}
+	return loadConfig(name, raw)
+}
+
+// defaultConfig return the config with the built-in defaults.
+func defaultConfig() *Config {
+	return &Config{
 		DBConfig:     initDBConfig(),
 		ServerConfig: initServerConfig(),
 		GithubConfig: initGithubConfig(),
+		LogConfig:    initLogConfig(),
 	}
-	if path == "" {
-		path = _default_config_path
+}
+
+func loadConfig(name string, raw []byte) *Config {
+	// init config dict here.
+	configDict := loadConfigDict()
+	c := defaultConfig()
+	if err := util.ParseBytes(raw, util.DetectFormat(name, raw), c); err != nil {
+		clog.Panic(fmt.Sprintf("parse config(%s) fail for %s", name, err.Error()))
+		return c
 	}
-	if err := util.ParseYamlFile(path, c); err != nil {
-		clog.Panic(fmt.Sprintf("%s", err.Error()))
+	clog.Info(fmt.Sprintf("parse %s successfully", palette.SkyBlue(name)))
+	if _, err := resolveSecrets(configFields(c), _default_env_prefix); err != nil {
+		clog.Panic(err.Error())
+		return c
+	}
+	if err := ValidateConfig(c, fileProvenance(name, raw)); err != nil {
+		clog.Panic(fmt.Sprintf("invalid config(%s):\n%s", name, err.Error()))
 		return c
 	}
 	// put Config into data dict
 	configDict.Record(DATAKEY_CONFIG, c)
+	applyConfig(c)
 	return c
 }
 
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -19,6 +21,18 @@ var (
 	_dict_timeout = 10 * time.Minute
 	// some default key from internal, exactly I'd like to got them from some .json files. :)
 	_dict_capacity uint64 = 1 << 10
+
+	ErrDictNotFound = errors.New("dict not found")
+	ErrDataNotFound = errors.New("data not found in dict")
+	ErrDataType     = errors.New("data type mismatch")
+)
+
+const (
+	DICT_EVENT_INSERT DictEventKind = iota + 1
+	DICT_EVENT_UPDATE
+	DICT_EVENT_DELETE
+	DICT_EVENT_EXPIRE
+	DICT_EVENT_EVICT // removed for the capacity
 )
 
 type (
@@ -29,8 +43,30 @@ type (
 	}
 	// DataDict store data with string key.
 	DataDict[V any] struct {
-		name string
-		dict *ttlcache.Cache[string, V]
+		name  string
+		dict  *ttlcache.Cache[string, V]
+		watch *dictWatch[V]
+		mu    *sync.Mutex // serialize the changes, so their events tell insert from update
+	}
+	// Functional dict configuration.
+	DictOption  func(o *dictOptions)
+	dictOptions struct {
+		ttl      time.Duration
+		capacity uint64
+	}
+	DictEventKind uint8
+	// DictEvent describe a change of data in dict, Old is only set for update.
+	DictEvent[V any] struct {
+		Kind  DictEventKind
+		Key   string
+		Value V
+		Old   V
+	}
+	dictWatch[V any] struct {
+		mu      sync.RWMutex
+		seq     int
+		fns     map[int]func(DictEvent[V])
+		started sync.Once
 	}
 )
 
@@ -77,16 +113,71 @@ func NextDictCap(cap uint64) {
 	_dict_capacity = cap
 }
 
-// NewDataDict return a data dict with string key.
-func NewDataDict[V any](name string) DataDict[V] {
+// WithDictTTL set the default ttl of data in dict.
+func WithDictTTL(ttl time.Duration) DictOption {
+	return func(o *dictOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithDictCap set the capacity of dict, the least recently used data is removed when full, 0 for no limit.
+func WithDictCap(cap uint64) DictOption {
+	return func(o *dictOptions) {
+		o.capacity = cap
+	}
+}
+
+// WithNoExpiry keep data in dict until removed, neither ttl nor capacity applies.
+func WithNoExpiry() DictOption {
+	return func(o *dictOptions) {
+		o.ttl = ttlcache.NoTTL
+		o.capacity = 0
+	}
+}
+
+// NewDataDict return a data dict with string key, default ttl and cap are set by NextDictTTL and NextDictCap.
+func NewDataDict[V any](name string, opts ...DictOption) DataDict[V] {
+	o := &dictOptions{
+		ttl:      _dict_timeout,
+		capacity: _dict_capacity,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
 	dd := ttlcache.New(
-		ttlcache.WithCapacity[string, V](_dict_capacity),
-		ttlcache.WithTTL[string, V](_dict_timeout),
+		ttlcache.WithCapacity[string, V](o.capacity),
+		ttlcache.WithTTL[string, V](o.ttl),
 	)
 	return DataDict[V]{
-		name: string(name),
-		dict: dd,
+		name:  string(name),
+		dict:  dd,
+		watch: &dictWatch[V]{fns: make(map[int]func(DictEvent[V]))},
+		mu:    &sync.Mutex{},
+	}
+}
+
+// Get return the data with type T from dict in directory, false if the dict or data not exists or type mismatches.
+func Get[T any](k DictKey, key string) (T, bool) {
+	v, err := Lookup[T](k, key)
+	return v, err == nil
+}
+
+// Lookup is Get but report why the data can't be returned.
+func Lookup[T any](k DictKey, key string) (T, error) {
+	var zero T
+	dd, found := _dict_directory.find(string(k))
+	if !found {
+		return zero, fmt.Errorf("%w: %s", ErrDictNotFound, k)
+	}
+	v, found := dd.Get(key)
+	if !found {
+		return zero, fmt.Errorf("%w: %s from %s", ErrDataNotFound, key, k)
+	}
+	t, ok := v.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: %s from %s is %T but want %T", ErrDataType, key, k, v, zero)
 	}
+	return t, nil
 }
 
 // Name return the dict's dict key from dict directory.
@@ -94,11 +185,98 @@ func (dd *DataDict[V]) Name() DictKey {
 	return DictKey(dd.name)
 }
 
+// Record put data with the default ttl of dict.
 func (dd *DataDict[V]) Record(k string, v V) {
-	dd.dict.Set(k, v, ttlcache.DefaultTTL)
+	dd.set(k, v, ttlcache.DefaultTTL)
 	clog.Info(fmt.Sprintf("put data(%s) into dict(%s)", palette.SkyBlue(k), palette.SkyBlue(dd.name)))
 }
 
+// RecordNoExpiry put data never expiring, it may still be removed for the capacity.
+func (dd *DataDict[V]) RecordNoExpiry(k string, v V) {
+	dd.set(k, v, ttlcache.NoTTL)
+	clog.Info(fmt.Sprintf("put data(%s) into dict(%s) without expiry", palette.SkyBlue(k), palette.SkyBlue(dd.name)))
+}
+
+// RecordWithTTL put data expiring after ttl.
+func (dd *DataDict[V]) RecordWithTTL(k string, v V, ttl time.Duration) {
+	dd.set(k, v, ttl)
+	clog.Info(fmt.Sprintf("put data(%s) into dict(%s) for %s", palette.SkyBlue(k), palette.SkyBlue(dd.name), palette.SkyBlue(ttl)))
+}
+
+// Get return the data, false if not exists.
+func (dd *DataDict[V]) Get(k string) (V, bool) {
+	var zero V
+	if dd.dict == nil {
+		return zero, false
+	}
+	item := dd.dict.Get(k)
+	if item == nil {
+		return zero, false
+	}
+	return item.Value(), true
+}
+
+// Watch call fn synchronously on insert, update and delete, and asynchronously on
+// expiry and removal for the capacity, return the function to stop watching.
+func (dd *DataDict[V]) Watch(fn func(ev DictEvent[V])) (cancel func()) {
+	w := dd.watch
+	w.started.Do(func() {
+		dd.dict.OnEviction(func(ctx context.Context, r ttlcache.EvictionReason, item *ttlcache.Item[string, V]) {
+			switch r {
+			case ttlcache.EvictionReasonExpired:
+				dd.notify(DictEvent[V]{Kind: DICT_EVENT_EXPIRE, Key: item.Key(), Value: item.Value()})
+			case ttlcache.EvictionReasonCapacityReached:
+				dd.notify(DictEvent[V]{Kind: DICT_EVENT_EVICT, Key: item.Key(), Value: item.Value()})
+			}
+		})
+		// expired data is only removed, and so notified, by the cleanup goroutine
+		go dd.dict.Start()
+	})
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.seq++
+	id := w.seq
+	w.fns[id] = fn
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.fns, id)
+	}
+}
+
+func (dd *DataDict[V]) set(k string, v V, ttl time.Duration) {
+	dd.mu.Lock()
+	// the item is updated in place by Set, keep the old value first
+	item := dd.dict.Get(k, ttlcache.WithDisableTouchOnHit[string, V]())
+	var old V
+	if item != nil {
+		old = item.Value()
+	}
+	dd.dict.Set(k, v, ttl)
+	dd.mu.Unlock()
+	// notified without the lock, the watchers may change the dict
+	if item == nil {
+		dd.notify(DictEvent[V]{Kind: DICT_EVENT_INSERT, Key: k, Value: v})
+	} else {
+		dd.notify(DictEvent[V]{Kind: DICT_EVENT_UPDATE, Key: k, Value: v, Old: old})
+	}
+}
+
+func (dd *DataDict[V]) notify(ev DictEvent[V]) {
+	if dd.watch == nil {
+		return
+	}
+	dd.watch.mu.RLock()
+	fns := make([]func(DictEvent[V]), 0, len(dd.watch.fns))
+	for _, fn := range dd.watch.fns {
+		fns = append(fns, fn)
+	}
+	dd.watch.mu.RUnlock()
+	for _, fn := range fns {
+		fn(ev)
+	}
+}
+
 // Find return Item with specific key and will panic if not exists the data.
 func (dd *DataDict[V]) Find(k string) *ttlcache.Item[string, V] {
 	if !dd.dict.Has(k) {
@@ -112,11 +290,22 @@ func (dd *DataDict[V]) Has(k string) bool {
 }
 
 func (dd *DataDict[V]) Remove(k string) {
-	dd.dict.Delete(k)
+	dd.mu.Lock()
+	item, found := dd.dict.GetAndDelete(k, ttlcache.WithDisableTouchOnHit[string, V]())
+	dd.mu.Unlock()
+	if found {
+		dd.notify(DictEvent[V]{Kind: DICT_EVENT_DELETE, Key: k, Value: item.Value()})
+	}
 }
 
 func (dd *DataDict[V]) RemoveAll() {
+	dd.mu.Lock()
+	items := dd.dict.Items()
 	dd.dict.DeleteAll()
+	dd.mu.Unlock()
+	for k, item := range items {
+		dd.notify(DictEvent[V]{Kind: DICT_EVENT_DELETE, Key: k, Value: item.Value()})
+	}
 }
 
 func (dd *DataDict[V]) Len() int {
@@ -140,7 +329,7 @@ func LoadDict(dictkey string) {
 	if HasDict(DictKey(dictkey)) {
 		return
 	}
-	dict := NewDataDict[any](dictkey)
+	dict := NewDataDict[any](dictkey, WithNoExpiry())
 	PutDict(dict.Name(), dict)
 	clog.Info(fmt.Sprintf("load dict(%s) manually", palette.SkyBlue(dictkey)))
 }
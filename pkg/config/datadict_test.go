@@ -1,10 +1,13 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/jellydator/ttlcache/v3"
 	"github.com/wendisx/puzzle/pkg/clog"
 )
 
@@ -45,3 +48,118 @@ func Test_datadict(t *testing.T) {
 func Test_bad_key(t *testing.T) {
 	show("bad_key")
 }
+
+// test dict and data without expiry [passed]
+func Test_dict_no_expiry(t *testing.T) {
+	dd := NewDataDict[any]("_no_expiry_", WithNoExpiry())
+	ttl := NewDataDict[any]("_ttl_", WithDictTTL(20*time.Millisecond), WithDictCap(2))
+	dd.Record("cli", "kept")
+	ttl.Record("short", 1)
+	ttl.RecordNoExpiry("long", 2)
+	ttl.RecordWithTTL("longer", 3, time.Hour)
+	time.Sleep(50 * time.Millisecond)
+	if !dd.Has("cli") || ttl.Has("short") || !ttl.Has("long") || !ttl.Has("longer") {
+		t.Fatalf("expected only short expired but got %v %v", dd.Keys(nil), ttl.Keys(nil))
+	}
+	for i := range 3 {
+		dd.Record(fmt.Sprint(i), i)
+	}
+	if dd.Len() != 4 {
+		t.Fatalf("expected no capacity limit but got %d", dd.Len())
+	}
+}
+
+// test typed getters without panic [passed]
+func Test_dict_typed_get(t *testing.T) {
+	dd := NewDataDict[any]("_typed_", WithNoExpiry())
+	PutDict(dd.Name(), dd)
+	dd.Record("addr", "127.0.0.1:3333")
+	dd.Record("env", &A{Env: "dev"})
+	if addr, ok := Get[string]("_typed_", "addr"); !ok || addr != "127.0.0.1:3333" {
+		t.Fatalf("expected addr but got %v %v", addr, ok)
+	}
+	if env, err := Lookup[*A]("_typed_", "env"); err != nil || env.Env != "dev" {
+		t.Fatalf("expected env but got %v %v", env, err)
+	}
+	if _, err := Lookup[int]("_typed_", "addr"); !errors.Is(err, ErrDataType) {
+		t.Fatalf("expected ErrDataType but got %v", err)
+	}
+	if _, err := Lookup[int]("_typed_", "missing"); !errors.Is(err, ErrDataNotFound) {
+		t.Fatalf("expected ErrDataNotFound but got %v", err)
+	}
+	if _, err := Lookup[int]("_missing_", "addr"); !errors.Is(err, ErrDictNotFound) {
+		t.Fatalf("expected ErrDictNotFound but got %v", err)
+	}
+	if _, ok := Get[string]("_missing_", "addr"); ok {
+		t.Fatal("expected not found")
+	}
+}
+
+// test watch insert, update, delete and expiry [passed]
+func Test_dict_watch(t *testing.T) {
+	dd := NewDataDict[int]("_watch_", WithDictTTL(time.Hour))
+	events := make(chan DictEvent[int], 8)
+	cancel := dd.Watch(func(ev DictEvent[int]) {
+		events <- ev
+	})
+	dd.Record("a", 1)
+	dd.Record("a", 2)
+	dd.Remove("a")
+	dd.Remove("a")
+	dd.RecordWithTTL("b", 3, 10*time.Millisecond)
+	want := []DictEvent[int]{
+		{Kind: DICT_EVENT_INSERT, Key: "a", Value: 1},
+		{Kind: DICT_EVENT_UPDATE, Key: "a", Value: 2, Old: 1},
+		{Kind: DICT_EVENT_DELETE, Key: "a", Value: 2},
+		{Kind: DICT_EVENT_INSERT, Key: "b", Value: 3},
+		{Kind: DICT_EVENT_EXPIRE, Key: "b", Value: 3},
+	}
+	for _, w := range want {
+		select {
+		case ev := <-events:
+			if ev != w {
+				t.Fatalf("expected %+v but got %+v", w, ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %+v but got nothing", w)
+		}
+	}
+	cancel()
+	dd.Record("c", 4)
+	if len(events) != 0 {
+		t.Fatalf("expected no event after cancel but got %+v", <-events)
+	}
+}
+
+// test concurrent records of a new key report one insert [passed]
+func Test_dict_watch_concurrent(t *testing.T) {
+	dd := NewDataDict[int]("_watch_concurrent_", WithNoExpiry())
+	var mu sync.Mutex
+	kinds := make(map[DictEventKind]int)
+	olds := make(map[int]bool)
+	dd.Watch(func(ev DictEvent[int]) {
+		mu.Lock()
+		defer mu.Unlock()
+		kinds[ev.Kind]++
+		if ev.Kind == DICT_EVENT_UPDATE {
+			olds[ev.Old] = true
+		}
+	})
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			dd.set("a", i+1, ttlcache.NoTTL)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	last, _ := dd.Get("a")
+	// every value but the last one is replaced exactly once
+	if kinds[DICT_EVENT_INSERT] != 1 || kinds[DICT_EVENT_UPDATE] != 49 || len(olds) != 49 || olds[last] {
+		t.Fatalf("expected 1 insert and 49 updates but got %v with %d olds", kinds, len(olds))
+	}
+}
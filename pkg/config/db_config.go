@@ -2,23 +2,36 @@ package config
 
 import (
 	"fmt"
+	"maps"
+	"slices"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/wendisx/puzzle/pkg/clog"
 	"github.com/wendisx/puzzle/pkg/palette"
 )
 
+const (
+	// name of the unnamed sql or redis config
+	DB_DEFAULT = "default"
+)
+
 type (
+	// SqlDBConfig is the unnamed sql database, and the named ones like database.sql.main
+	// under it, the unset fields of a named one but dsn are taken from the unnamed one.
+	// The times are in seconds.
 	SqlDBConfig struct {
-		Driver          string `yaml:"driver"`
-		Dsn             string `yaml:"dsn"`
-		MaxIdleConn     int    `yaml:"maxIdleConn"`
-		MaxOpenConn     int    `yaml:"maxOpenConn"`
-		MaxConnIdleTime int    `yaml:"maxConnIdleTime"`
-		MaxConnLifeTime int    `yaml:"maxConnLifeTime"`
+		Driver          string                  `yaml:"driver"`
+		Dsn             string                  `yaml:"dsn" secret:"true"`
+		MaxIdleConn     int                     `yaml:"maxIdleConn" check:"min=0"`
+		MaxOpenConn     int                     `yaml:"maxOpenConn" check:"min=0"`
+		MaxConnIdleTime int                     `yaml:"maxConnIdleTime" check:"min=0"`
+		MaxConnLifeTime int                     `yaml:"maxConnLifeTime" check:"min=0"`
+		Named           map[string]*SqlDBConfig `yaml:",inline"`
 	}
+	// RedisConfig is the unnamed redis, and the named ones like database.redis.cache under it.
 	RedisConfig struct {
-		Dsn string `yaml:"dsn"`
+		Dsn   string                  `yaml:"dsn" secret:"true"`
+		Named map[string]*RedisConfig `yaml:",inline"`
 	}
 	DBConfig struct {
 		SqlDBConfig `yaml:"sql"`
@@ -45,3 +58,62 @@ func (db *DBConfig) RedisOptions() *redis.Options {
 	}
 	return ro
 }
+
+// SqlDB return the sql config of name, DB_DEFAULT or empty for the unnamed one if set.
+func (db *DBConfig) SqlDB(name string) (SqlDBConfig, bool) {
+	base := db.SqlDBConfig
+	base.Named = nil
+	if sc, found := db.SqlDBConfig.Named[name]; found && sc != nil {
+		named := *sc
+		named.Named = nil
+		if named.Driver == "" {
+			named.Driver = base.Driver
+		}
+		if named.MaxIdleConn == 0 {
+			named.MaxIdleConn = base.MaxIdleConn
+		}
+		if named.MaxOpenConn == 0 {
+			named.MaxOpenConn = base.MaxOpenConn
+		}
+		if named.MaxConnIdleTime == 0 {
+			named.MaxConnIdleTime = base.MaxConnIdleTime
+		}
+		if named.MaxConnLifeTime == 0 {
+			named.MaxConnLifeTime = base.MaxConnLifeTime
+		}
+		return named, true
+	}
+	if (name == "" || name == DB_DEFAULT) && base.Dsn != "" {
+		return base, true
+	}
+	return SqlDBConfig{}, false
+}
+
+// RedisDB return the redis config of name, DB_DEFAULT or empty for the unnamed one if set.
+func (db *DBConfig) RedisDB(name string) (RedisConfig, bool) {
+	if rc, found := db.RedisConfig.Named[name]; found && rc != nil {
+		return RedisConfig{Dsn: rc.Dsn}, true
+	}
+	if (name == "" || name == DB_DEFAULT) && db.RedisConfig.Dsn != "" {
+		return RedisConfig{Dsn: db.RedisConfig.Dsn}, true
+	}
+	return RedisConfig{}, false
+}
+
+// SqlNames return the names of sql configs in order, DB_DEFAULT for the unnamed one.
+func (db *DBConfig) SqlNames() []string {
+	return dbNames(db.SqlDBConfig.Dsn != "", db.SqlDBConfig.Named)
+}
+
+// RedisNames return the names of redis configs in order, DB_DEFAULT for the unnamed one.
+func (db *DBConfig) RedisNames() []string {
+	return dbNames(db.RedisConfig.Dsn != "", db.RedisConfig.Named)
+}
+
+func dbNames[V any](unnamed bool, named map[string]V) []string {
+	names := slices.Sorted(maps.Keys(named))
+	if _, found := named[DB_DEFAULT]; unnamed && !found {
+		names = append([]string{DB_DEFAULT}, names...)
+	}
+	return names
+}
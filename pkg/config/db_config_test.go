@@ -0,0 +1,62 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// test named databases with defaults, validation and redaction [passed]
+func Test_named_db_config(t *testing.T) {
+	t.Setenv("ANA_DSN", "root:pw@/analytics")
+	t.Setenv("PUZZLE_DATABASE_SQL_MAIN_MAX_OPEN_CONN", "8")
+	path := test_write_yaml(t, t.TempDir(), "config.yaml", `database:
+  sql:
+    driver: mysql
+    dsn: root:pw@/app
+    main:
+      dsn: root:pw@/main
+    analytics:
+      dsn: ${env:ANA_DSN}
+      maxIdleConn: 2
+  redis:
+    cache:
+      dsn: redis://localhost:6379/1
+`)
+	c, pv, err := LoadLayered(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	db := &c.DBConfig
+	if names := db.SqlNames(); !slices.Equal(names, []string{DB_DEFAULT, "analytics", "main"}) {
+		t.Fatalf("unexpected sql names %v", names)
+	}
+	main, found := db.SqlDB("main")
+	if !found || main.Driver != "mysql" || main.Dsn != "root:pw@/main" || main.MaxIdleConn != 20 || main.MaxOpenConn != 8 {
+		t.Fatalf("unexpected main %+v", main)
+	}
+	if ana, _ := db.SqlDB("analytics"); ana.Dsn != "root:pw@/analytics" || ana.MaxIdleConn != 2 {
+		t.Fatalf("unexpected analytics %+v", ana)
+	}
+	if def, found := db.SqlDB(""); !found || def.Dsn != "root:pw@/app" || def.Named != nil {
+		t.Fatalf("unexpected default %+v", def)
+	}
+	if rc, found := db.RedisDB("cache"); !found || rc.Dsn != "redis://localhost:6379/1" {
+		t.Fatalf("unexpected cache %+v", rc)
+	}
+	if _, found := db.RedisDB(DB_DEFAULT); found {
+		t.Fatal("expected no default redis")
+	}
+	test_source(t, pv, "database.sql.main.maxOpenConn", LAYER_ENV, "PUZZLE_DATABASE_SQL_MAIN_MAX_OPEN_CONN")
+	if e, _ := pv.Source("database.sql.main.dsn"); e.Line != 6 || e.Value != REDACTED {
+		t.Fatalf("unexpected provenance %+v", e)
+	}
+	if r := c.Redact(); r.DBConfig.SqlDBConfig.Named["main"].Dsn != REDACTED || c.DBConfig.SqlDBConfig.Named["main"].Dsn != "root:pw@/main" {
+		t.Fatal("expected named dsn redacted in copy only")
+	}
+	bad := test_write_yaml(t, t.TempDir(), "config.yaml", "database:\n  sql:\n    main:\n      driver: mysql\n      maxOpenConn: -1\n")
+	_, err = ValidateFile(bad, WithEnvPrefix("BAD"))
+	if err == nil || !strings.Contains(err.Error(), "config.yaml:5: database.sql.main.maxOpenConn") || !strings.Contains(err.Error(), "database.sql.main.dsn: dsn is required") {
+		t.Fatalf("unexpected errors %v", err)
+	}
+}
@@ -1,14 +1,116 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"os"
 	"reflect"
+	"slices"
+	"strings"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/wendisx/puzzle/pkg/clog"
 	"github.com/wendisx/puzzle/pkg/palette"
+	"github.com/wendisx/puzzle/pkg/util"
 )
 
+/*
+	config.env -- [submodule]
+Typed environment structs by envconfig, with `.env` files for local development:
+1. LoadDotenv load `.env` then `.env.local` into the process env, the later file wins
+but the variables already set in the process are kept. `${NAME}` and `$NAME` in values
+are expanded by the env and the variables before.
+2. Every env struct is recorded into the config dict by its prefix, so several structs
+can live together, LookupEnv[ES](prefix) return it without panic.
+3. RegisterEnv record a struct without processing, EnvVars list its variables by the
+tags of envconfig (envconfig, default, required, desc, split_words, ignored), and
+MissingEnv and ExampleDotenv are what `puzzle env check` prints.
+*/
+
+const (
+	DOTENV_FILE       = ".env"
+	DOTENV_LOCAL_FILE = ".env.local"
+
+	_env_key_sep = ":"
+)
+
+var (
+	ErrEnvMissing = errors.New("missing required env")
+)
+
+type (
+	// EnvVar is one variable of env struct.
+	EnvVar struct {
+		Key      string // like DEV_AGENT_NAME
+		Alt      string // key without prefix, used if Key not set, like AGENT_NAME
+		Type     string
+		Default  string
+		Required bool
+		Desc     string
+	}
+	// PuzzleEnv is the env used by puzzle itself with prefix PUZZLE.
+	PuzzleEnv struct {
+		Profile   string `envconfig:"PROFILE" desc:"profile overlay of config, like dev"`
+		ConfigKey string `envconfig:"CONFIG_KEY" desc:"base64 key to decrypt enc: values of config"`
+	}
+)
+
+// LoadDotenv load the dotenv files, .env and .env.local by default, missing files are skipped.
+// It return the variables set into the process env.
+func LoadDotenv(paths ...string) (map[string]string, error) {
+	if len(paths) == 0 {
+		paths = []string{DOTENV_FILE, DOTENV_LOCAL_FILE}
+	}
+	vars := make(map[string]string)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		fileVars, err := util.ParseDotenv(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parse dotenv(%s) fail for %w", path, err)
+		}
+		// the lines are unordered in map, so the references in file are expanded on demand
+		var expand func(k string, seen map[string]bool) string
+		expand = func(k string, seen map[string]bool) string {
+			seen[k] = true
+			defer delete(seen, k)
+			return os.Expand(fileVars[k], func(name string) string {
+				if ev, found := os.LookupEnv(name); found {
+					return ev
+				}
+				if _, found := fileVars[name]; found && !seen[name] {
+					return expand(name, seen)
+				}
+				return vars[name]
+			})
+		}
+		expanded := make(map[string]string, len(fileVars))
+		for k := range fileVars {
+			expanded[k] = expand(k, make(map[string]bool))
+		}
+		maps.Copy(vars, expanded)
+		clog.Info(fmt.Sprintf("load dotenv(%s)", palette.SkyBlue(path)))
+	}
+	set := make(map[string]string, len(vars))
+	for k, v := range vars {
+		if _, found := os.LookupEnv(k); found {
+			continue
+		}
+		if err := os.Setenv(k, v); err != nil {
+			return nil, err
+		}
+		set[k] = v
+	}
+	return set, nil
+}
+
 // LoadEnv load all environment variables matching the specified prefix into the
 // specified structure, which must be a pointer type. An error log will be displayed
 // if a structure configuration problem exists, but the program will not exit.
@@ -27,17 +129,167 @@ func LoadEnv(prefix string, dest any) {
 		clog.Error(err.Error())
 	}
 	// put all environments into data dict
-	configDict := GetDict(DICTKEY_CONFIG)
+	configDict := loadConfigDict()
 	configDict.Record(DATAKEY_ENV, dest)
+	configDict.Record(envDataKey(prefix), dest)
+}
+
+// RegisterEnv record the env struct by prefix without processing, like for `puzzle env check`.
+func RegisterEnv(prefix string, dest any) {
+	vofDest := reflect.ValueOf(dest)
+	if vofDest.Kind() != reflect.Ptr || vofDest.Elem().Kind() != reflect.Struct {
+		clog.Panic(fmt.Sprintf("invalid environmental container type %s", palette.Red(vofDest.Kind().String())))
+	}
+	configDict := loadConfigDict()
+	configDict.Record(envDataKey(prefix), dest)
 }
 
-// GetEnv try to get the pointer to environment structure and will panic
-// if not exists the structure.
+// GetEnv try to get the pointer to environment structure of type ES, the last loaded
+// one first, and will panic if not exists the structure.
 func GetEnv[ES any]() *ES {
+	if e, ok := Get[*ES](DICTKEY_CONFIG, DATAKEY_ENV); ok {
+		return e
+	}
+	for _, prefix := range EnvPrefixes() {
+		if e, err := LookupEnv[ES](prefix); err == nil {
+			return e
+		}
+	}
+	clog.Panic(fmt.Sprintf("from data_key(%s) assert to type(*%s) fail", palette.Red(DATAKEY_ENV), reflect.TypeFor[ES]().Name()))
+	return nil
+}
+
+// LookupEnv return the env struct of prefix, the error tell why it can't be returned.
+func LookupEnv[ES any](prefix string) (*ES, error) {
+	return Lookup[*ES](DICTKEY_CONFIG, envDataKey(prefix))
+}
+
+// EnvPrefixes return the prefixes of env structs recorded in order.
+func EnvPrefixes() []string {
+	if !HasDict(DICTKEY_CONFIG) {
+		return nil
+	}
 	configDict := GetDict(DICTKEY_CONFIG)
-	e, ok := configDict.Find(DATAKEY_ENV).Value().(*ES)
-	if !ok {
-		clog.Panic(fmt.Sprintf("from data_key(%s) assert to type(*[ES any]) fail", palette.Red(DATAKEY_ENV)))
+	keys := configDict.Keys(func(k string) bool {
+		return strings.HasPrefix(k, DATAKEY_ENV+_env_key_sep)
+	})
+	prefixes := make([]string, len(keys))
+	for i, k := range keys {
+		prefixes[i] = strings.TrimPrefix(k, DATAKEY_ENV+_env_key_sep)
+	}
+	slices.Sort(prefixes)
+	return prefixes
+}
+
+// EnvOf return the env struct recorded by prefix.
+func EnvOf(prefix string) (any, bool) {
+	return Get[any](DICTKEY_CONFIG, envDataKey(prefix))
+}
+
+func envDataKey(prefix string) string {
+	return DATAKEY_ENV + _env_key_sep + prefix
+}
+
+// EnvVars return the variables of env struct dest with prefix by the rules of envconfig.
+func EnvVars(prefix string, dest any) []EnvVar {
+	var vars []EnvVar
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := range t.NumField() {
+			sf := t.Field(i)
+			if !sf.IsExported() || sf.Tag.Get("ignored") == "true" {
+				continue
+			}
+			key := sf.Name
+			if sf.Tag.Get("split_words") == "true" {
+				key = util.UpperSnake(sf.Name)
+			}
+			alt := strings.ToUpper(sf.Tag.Get("envconfig"))
+			if alt != "" {
+				key = alt
+			}
+			if prefix != "" {
+				key = prefix + "_" + key
+			}
+			key = strings.ToUpper(key)
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && !isDecoder(ft) {
+				next := prefix
+				if !sf.Anonymous {
+					next = key
+				}
+				walk(ft, next)
+				continue
+			}
+			vars = append(vars, EnvVar{
+				Key:      key,
+				Alt:      alt,
+				Type:     ft.String(),
+				Default:  sf.Tag.Get("default"),
+				Required: sf.Tag.Get("required") == "true",
+				Desc:     sf.Tag.Get("desc"),
+			})
+		}
+	}
+	walk(reflect.TypeOf(dest).Elem(), prefix)
+	return vars
+}
+
+// isDecoder tell whether t is decoded from one variable by envconfig, like time.Time.
+func isDecoder(t reflect.Type) bool {
+	pt := reflect.PointerTo(t)
+	return pt.Implements(reflect.TypeFor[envconfig.Decoder]()) || pt.Implements(reflect.TypeFor[envconfig.Setter]()) ||
+		pt.Implements(reflect.TypeFor[interface{ UnmarshalText([]byte) error }]())
+}
+
+// MissingEnv return the required variables of dest not set in env, without defaults.
+func MissingEnv(prefix string, dest any) []EnvVar {
+	var missing []EnvVar
+	for _, ev := range EnvVars(prefix, dest) {
+		if !ev.Required || ev.Default != "" {
+			continue
+		}
+		if _, found := os.LookupEnv(ev.Key); found {
+			continue
+		}
+		if _, found := os.LookupEnv(ev.Alt); ev.Alt != "" && found {
+			continue
+		}
+		missing = append(missing, ev)
+	}
+	return missing
+}
+
+// CheckEnv return ErrEnvMissing with the missing required variables of all env structs recorded.
+func CheckEnv() error {
+	var keys []string
+	for _, prefix := range EnvPrefixes() {
+		dest, _ := EnvOf(prefix)
+		for _, ev := range MissingEnv(prefix, dest) {
+			keys = append(keys, ev.Key)
+		}
+	}
+	if len(keys) > 0 {
+		return fmt.Errorf("%w: %s", ErrEnvMissing, strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+// ExampleDotenv return an example .env of dest, the descriptions become comments.
+func ExampleDotenv(prefix string, dest any) string {
+	var sb strings.Builder
+	for _, ev := range EnvVars(prefix, dest) {
+		comment := ev.Desc
+		if ev.Required {
+			comment = strings.TrimSpace(comment + " (required)")
+		}
+		if comment != "" {
+			fmt.Fprintf(&sb, "# %s\n", comment)
+		}
+		fmt.Fprintf(&sb, "%s=%s\n", ev.Key, ev.Default)
 	}
-	return e
+	return sb.String()
 }
@@ -1,7 +1,11 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/kelseyhightower/envconfig"
@@ -81,3 +85,81 @@ func Test_load_env(t *testing.T) {
 	LoadEnv(de.Prefix(), &de)
 	print_dev_env()
 }
+
+// test load .env and .env.local with expansion [passed]
+func Test_load_dotenv(t *testing.T) {
+	dir := t.TempDir()
+	base := test_write_yaml(t, dir, ".env", "APP_HOST=base.local\nAPP_URL=http://${APP_HOST}:$APP_PORT\nAPP_PORT=80\nAPP_KEPT=file\n")
+	local := test_write_yaml(t, dir, ".env.local", "APP_HOST=local.host\nAPP_HOME=${HOME_DIR}/app\n")
+	t.Setenv("APP_KEPT", "env")
+	t.Setenv("HOME_DIR", "/home/x")
+	for _, k := range []string{"APP_HOST", "APP_URL", "APP_PORT", "APP_HOME"} {
+		t.Setenv(k, "")
+		os.Unsetenv(k)
+	}
+	set, err := LoadDotenv(base, local, filepath.Join(dir, ".env.missing"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := map[string]string{
+		"APP_HOST": "local.host",
+		"APP_URL":  "http://base.local:80",
+		"APP_PORT": "80",
+		"APP_HOME": "/home/x/app",
+	}
+	for k, v := range expected {
+		if set[k] != v || os.Getenv(k) != v {
+			t.Fatalf("expected %s=%s but got %s", k, v, os.Getenv(k))
+		}
+	}
+	if _, found := set["APP_KEPT"]; found || os.Getenv("APP_KEPT") != "env" {
+		t.Fatal("expected the process env kept")
+	}
+}
+
+type (
+	ApiEnv struct {
+		Token   string `envconfig:"TOKEN" required:"true" desc:"api token"`
+		Timeout int    `default:"30"`
+		Retry   struct {
+			MaxTimes int `split_words:"true" required:"true" default:"3"`
+		}
+	}
+)
+
+// test env structs by prefix, lookup without panic and check [passed]
+func Test_env_prefix(t *testing.T) {
+	t.Setenv("DEV_HOST", "dev.local")
+	var de DevEnv
+	LoadEnv("dev", &de)
+	RegisterEnv("api", &ApiEnv{})
+	if e, err := LookupEnv[DevEnv]("dev"); err != nil || e.Host != "dev.local" {
+		t.Fatalf("unexpected dev env %v, %v", e, err)
+	}
+	if _, err := LookupEnv[ApiEnv]("dev"); !errors.Is(err, ErrDataType) {
+		t.Fatalf("expected type mismatch but got %v", err)
+	}
+	if _, err := LookupEnv[ApiEnv]("none"); !errors.Is(err, ErrDataNotFound) {
+		t.Fatalf("expected not found but got %v", err)
+	}
+	if GetEnv[ApiEnv]() == nil || GetEnv[DevEnv]() != &de {
+		t.Fatal("expected env structs by type")
+	}
+	os.Unsetenv("API_TOKEN")
+	os.Unsetenv("TOKEN")
+	missing := MissingEnv("api", &ApiEnv{})
+	if len(missing) != 1 || missing[0].Key != "API_TOKEN" || missing[0].Alt != "TOKEN" {
+		t.Fatalf("unexpected missing %+v", missing)
+	}
+	if err := CheckEnv(); !errors.Is(err, ErrEnvMissing) || !strings.Contains(err.Error(), "API_TOKEN") {
+		t.Fatalf("expected missing API_TOKEN but got %v", err)
+	}
+	t.Setenv("TOKEN", "x")
+	if err := CheckEnv(); err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := "# api token (required)\nAPI_TOKEN=\nAPI_TIMEOUT=30\n# (required)\nAPI_RETRY_MAX_TIMES=3\n"
+	if got := ExampleDotenv("api", &ApiEnv{}); got != expected {
+		t.Fatalf("expected\n%s\nbut got\n%s", expected, got)
+	}
+}
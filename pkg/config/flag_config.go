@@ -0,0 +1,18 @@
+package config
+
+type (
+	// FlagConfig is a feature flag like flags.newCheckout, see package flags for the rules.
+	// A disabled flag is off for everyone, an enabled one is on for the users and the rules matched,
+	// and rollout 0-100 enables it for that percent of the other users, all of them if not set.
+	FlagConfig struct {
+		Enabled bool       `yaml:"enabled" json:"enabled"`
+		Rollout *int       `yaml:"rollout" json:"rollout,omitempty" check:"min=0,max=100"`
+		Users   []string   `yaml:"users" json:"users,omitempty"` // id or name of jwt claims
+		Rules   []FlagRule `yaml:"rules" json:"rules,omitempty"`
+	}
+	// FlagRule match the users whose jwt claim, like iss, has one of values.
+	FlagRule struct {
+		Claim  string   `yaml:"claim" json:"claim"`
+		Values []string `yaml:"values" json:"values"`
+	}
+)
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// test flags by name with env overlay, path, schema and validation [passed]
+func Test_flag_config(t *testing.T) {
+	t.Setenv("PUZZLE_FLAGS_BETA_ROLLOUT", "25")
+	path := test_write_yaml(t, t.TempDir(), "config.yaml", `flags:
+  newCheckout:
+    enabled: true
+  beta:
+    enabled: true
+    users: [alice]
+    rules:
+      - claim: iss
+        values: [partner]
+`)
+	c, pv, err := LoadLayered(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	beta := c.FlagsConfig["beta"]
+	if beta == nil || beta.Rollout == nil || *beta.Rollout != 25 || beta.Users[0] != "alice" || beta.Rules[0].Values[0] != "partner" {
+		t.Fatalf("unexpected beta %+v", beta)
+	}
+	test_source(t, pv, "flags.beta.rollout", LAYER_ENV, "PUZZLE_FLAGS_BETA_ROLLOUT")
+	if c.FlagsConfig["newCheckout"].Rollout != nil {
+		t.Fatal("expected rollout not set for all users")
+	}
+	if v, err := ConfigValue(c, "flags.newCheckout.enabled"); err != nil || v != true {
+		t.Fatalf("unexpected value %v, %v", v, err)
+	}
+	raw, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var s struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	var flags struct {
+		AdditionalProperties struct {
+			Properties map[string]any `json:"properties"`
+		} `json:"additionalProperties"`
+	}
+	if err = json.Unmarshal(raw, &s); err == nil {
+		err = json.Unmarshal(s.Properties["flags"], &flags)
+	}
+	if err != nil || flags.AdditionalProperties.Properties["rollout"] == nil {
+		t.Fatalf("expected flag schema but got %v", err)
+	}
+	bad := test_write_yaml(t, t.TempDir(), "config.yaml", "flags:\n  beta:\n    rollout: 120\n")
+	if _, err = ValidateFile(bad, WithEnvPrefix("BAD")); err == nil || !strings.Contains(err.Error(), "config.yaml:3: flags.beta.rollout") {
+		t.Fatalf("unexpected errors %v", err)
+	}
+}
@@ -2,17 +2,17 @@ package config
 
 type (
 	Repo struct {
-		Name string `yaml:"name" json:"name"`
+		Name string `yaml:"name" json:"name" check:"min=1"`
 		Ref  string `yaml:"ref" json:"ref"`
 	}
 	GithubConfig struct {
 		ApiHost     string `yaml:"apiHost" json:"apiHost"`
 		RawHost     string `yaml:"rawHost" json:"rawHost"`
-		AccessToken string `yaml:"accessToken" json:"accessToken"`
+		AccessToken string `yaml:"accessToken" json:"accessToken" secret:"true"`
 		UserName    string `yaml:"userName" json:"userName"`
 		UserEmail   string `yaml:"userEmail" json:"userEmail"`
 		Repos       []Repo `yaml:"repos" json:"repos"`
-		ActiveRepo  int    `yaml:"activeRepo" json:"activeRepo"`
+		ActiveRepo  int    `yaml:"activeRepo" json:"activeRepo" check:"min=0"`
 	}
 )
 
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wendisx/puzzle/pkg/util"
+	"go.yaml.in/yaml/v3"
+)
+
+/*
+	config.inspect -- [submodule]
+Tools to inspect and edit the config without code, used by `puzzle config`:
+1. MarshalConfig print the config as yaml or json by the yaml names, redact it first.
+2. ConfigValue return the value of path like server.port, github.repos[0] or a section.
+3. DiffConfig return the changed leaf values between two configs, secrets redacted.
+4. SetFileValue set the value of path in a yaml file, the comments and the order of
+keys are kept, the value is checked by the field type and the validation.
+*/
+
+const (
+	OUTPUT_YAML = "yaml"
+	OUTPUT_JSON = "json"
+)
+
+var (
+	ErrConfigPath = errors.New("unknown config path")
+)
+
+type (
+	// ConfigChange is a leaf value changed between two configs.
+	ConfigChange struct {
+		Path string `json:"path"`
+		Old  string `json:"old"`
+		New  string `json:"new"`
+	}
+)
+
+// MarshalConfig return the config in yaml or json, both keyed by the yaml names.
+func MarshalConfig(c *Config, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode((*plainConfig)(c)); err != nil {
+		return nil, err
+	}
+	enc.Close()
+	switch format {
+	case OUTPUT_YAML:
+		return buf.Bytes(), nil
+	case OUTPUT_JSON:
+		var m map[string]any
+		if err := yaml.Unmarshal(buf.Bytes(), &m); err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(m, "", "  ")
+	default:
+		return nil, fmt.Errorf("%w: %s", util.ErrFormat, format)
+	}
+}
+
+// ConfigValue return the value of path in c, like server.port or github.repos[0].name.
+func ConfigValue(c *Config, path string) (any, error) {
+	v, err := lookupPath(reflect.ValueOf(c).Elem(), path)
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+func lookupPath(v reflect.Value, path string) (reflect.Value, error) {
+	for seg := range strings.SplitSeq(path, ".") {
+		name, rest, _ := strings.Cut(seg, "[")
+		for v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, fmt.Errorf("%w: %s is not set", ErrConfigPath, path)
+			}
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			field, found := fieldByYaml(v, name)
+			if !found {
+				return reflect.Value{}, fmt.Errorf("%w: %s", ErrConfigPath, path)
+			}
+			v = field
+		case reflect.Map:
+			ev := v.MapIndex(reflect.ValueOf(name))
+			if !ev.IsValid() {
+				return reflect.Value{}, fmt.Errorf("%w: %s", ErrConfigPath, path)
+			}
+			v = ev
+		default:
+			return reflect.Value{}, fmt.Errorf("%w: %s", ErrConfigPath, path)
+		}
+		for rest != "" {
+			idx, after, _ := strings.Cut(rest, "]")
+			i, err := strconv.Atoi(idx)
+			if err != nil || v.Kind() != reflect.Slice || i < 0 || i >= v.Len() {
+				return reflect.Value{}, fmt.Errorf("%w: %s", ErrConfigPath, path)
+			}
+			v = v.Index(i)
+			rest = strings.TrimPrefix(after, "[")
+		}
+	}
+	return v, nil
+}
+
+func fieldByYaml(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		yn, inline := yamlName(sf)
+		if inline && isNamedMap(sf.Type) {
+			if ev := v.Field(i).MapIndex(reflect.ValueOf(name)); ev.IsValid() {
+				return ev, true
+			}
+			continue
+		}
+		if inline && v.Field(i).Kind() == reflect.Struct {
+			if fv, found := fieldByYaml(v.Field(i), name); found {
+				return fv, true
+			}
+			continue
+		}
+		if yn == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// DiffConfig return the leaf values changed from a to b sorted by path, secrets are redacted.
+func DiffConfig(a, b *Config) []ConfigChange {
+	olds := make(map[string]configField)
+	for _, f := range configFields(a) {
+		olds[f.path] = f
+	}
+	var changes []ConfigChange
+	seen := make(map[string]bool)
+	for _, f := range configFields(b) {
+		seen[f.path] = true
+		o, found := olds[f.path]
+		oldStr := ""
+		if found {
+			if reflect.DeepEqual(o.value.Interface(), f.value.Interface()) {
+				continue
+			}
+			oldStr = diffValue(o)
+		}
+		changes = append(changes, ConfigChange{Path: f.path, Old: oldStr, New: diffValue(f)})
+	}
+	// the sections only in a, like a pointer set to nil
+	for _, f := range configFields(a) {
+		if !seen[f.path] {
+			changes = append(changes, ConfigChange{Path: f.path, Old: diffValue(f)})
+		}
+	}
+	slices.SortFunc(changes, func(a, b ConfigChange) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return changes
+}
+
+func diffValue(f configField) string {
+	if f.secret && !f.value.IsZero() {
+		return REDACTED
+	}
+	return formatValue(f.value)
+}
+
+// LoadFile load the defaults and the file only, without env, flags and secrets, like for diff.
+func LoadFile(path string) (*Config, error) {
+	c := defaultConfig()
+	pv := &Provenance{entries: make(map[string]ProvenanceEntry)}
+	if err := newLayeredLoader().overlayFile(c, pv, path, LAYER_FILE); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetFileValue set the value of path in the yaml file, keep the comments and write it back.
+func SetFileValue(file, path, value string) error {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if format := util.DetectFormat(file, raw); format != util.FORMAT_YAML {
+		return fmt.Errorf("%w: set only supports yaml but got %s", util.ErrFormat, format)
+	}
+	// check the value by the type of field, the named sections come from the file
+	c, err := LoadFile(file)
+	if err != nil {
+		return err
+	}
+	var field *configField
+	for _, f := range configFields(c) {
+		if f.path == path {
+			field = &f
+			break
+		}
+	}
+	if field == nil {
+		return fmt.Errorf("%w: %s is not a value", ErrConfigPath, path)
+	}
+	if err = setValue(field.value, value); err != nil {
+		return fmt.Errorf("%w for %s", err, path)
+	}
+	var doc yaml.Node
+	if err = yaml.Unmarshal(raw, &doc); err != nil {
+		return fmt.Errorf("parse config(%s) fail for %w", file, err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	node := doc.Content[0]
+	for seg := range strings.SplitSeq(path, ".") {
+		node, err = childNode(node, seg)
+		if err != nil {
+			return fmt.Errorf("%w at %s", err, path)
+		}
+	}
+	setNode(node, field.value)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err = enc.Encode(&doc); err != nil {
+		return err
+	}
+	enc.Close()
+	// the whole file should still be valid
+	nc := defaultConfig()
+	if err = yaml.Unmarshal(buf.Bytes(), nc); err != nil {
+		return fmt.Errorf("%w for %s", err, path)
+	}
+	if err = ValidateConfig(nc, fileProvenance(file, buf.Bytes())); err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(tmp, buf.Bytes(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// childNode return the value node of key in mapping n, created if not exists.
+func childNode(n *yaml.Node, key string) (*yaml.Node, error) {
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		n.Kind, n.Tag, n.Value = yaml.MappingNode, "!!map", ""
+	}
+	if n.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%w: %s is under a non-mapping value", ErrConfigPath, key)
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1], nil
+		}
+	}
+	k := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+	v := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+	n.Content = append(n.Content, k, v)
+	return v, nil
+}
+
+// setNode replace n by the value, the comments of n are kept.
+func setNode(n *yaml.Node, v reflect.Value) {
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice {
+		n.Kind, n.Tag, n.Value, n.Style = yaml.SequenceNode, "!!seq", "", yaml.FlowStyle
+		n.Content = nil
+		for i := range v.Len() {
+			item := &yaml.Node{Kind: yaml.ScalarNode}
+			setScalar(item, v.Index(i))
+			n.Content = append(n.Content, item)
+		}
+		return
+	}
+	n.Kind, n.Content, n.Style = yaml.ScalarNode, nil, 0
+	setScalar(n, v)
+}
+
+func setScalar(n *yaml.Node, v reflect.Value) {
+	n.Value = fmt.Sprint(v.Interface())
+	switch {
+	case v.Type() == reflect.TypeFor[time.Duration]():
+		n.Tag = "!!str"
+	case v.Kind() == reflect.Bool:
+		n.Tag = "!!bool"
+	case v.CanInt() || v.CanUint():
+		n.Tag = "!!int"
+	case v.CanFloat():
+		n.Tag = "!!float"
+	default:
+		n.Tag = "!!str"
+	}
+}
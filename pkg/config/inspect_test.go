@@ -0,0 +1,83 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// test show, get and diff the config with secrets redacted [passed]
+func Test_inspect_config(t *testing.T) {
+	dir := t.TempDir()
+	a, err := LoadFile(test_write_yaml(t, dir, "a.yaml", "server:\n  port: 8000\ndatabase:\n  sql:\n    driver: mysql\n    dsn: root:pw@/a\n"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	b, err := LoadFile(test_write_yaml(t, dir, "b.yaml", "server:\n  port: 9000\n  host: b.local\ndatabase:\n  sql:\n    driver: mysql\n    dsn: root:pw@/b\n"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if v, err := ConfigValue(b, "server.port"); err != nil || v != 9000 {
+		t.Fatalf("expected 9000 but got %v, %v", v, err)
+	}
+	if _, err := ConfigValue(b, "server.nope"); !errors.Is(err, ErrConfigPath) {
+		t.Fatalf("expected unknown path but got %v", err)
+	}
+	out, err := MarshalConfig(b.Redact(), OUTPUT_JSON)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var shown struct {
+		Server map[string]any `json:"server"`
+	}
+	if err = json.Unmarshal(out, &shown); err != nil || shown.Server["host"] != "b.local" || strings.Contains(string(out), "root:pw") {
+		t.Fatalf("unexpected json %s", out)
+	}
+	changes := DiffConfig(a, b)
+	expected := []ConfigChange{
+		{Path: "database.sql.dsn", Old: REDACTED, New: REDACTED},
+		{Path: "server.host", Old: "127.0.0.1", New: "b.local"},
+		{Path: "server.port", Old: "8000", New: "9000"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fatalf("expected %+v but got %+v", expected[i], changes[i])
+		}
+	}
+}
+
+// test set values in yaml file with comments kept [passed]
+func Test_set_file_value(t *testing.T) {
+	path := test_write_yaml(t, t.TempDir(), "config.yaml", "# app\nserver:\n  host: a.local # the host\n  port: 8000\n")
+	if err := SetFileValue(path, "server.port", "8081"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := SetFileValue(path, "swagger.urls", "a,b"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := SetFileValue(path, "server.host", "true"); err != nil {
+		t.Fatal(err.Error())
+	}
+	raw, _ := os.ReadFile(path)
+	expected := "# app\nserver:\n  host: \"true\" # the host\n  port: 8081\nswagger:\n  urls: [a, b]\n"
+	if string(raw) != expected {
+		t.Fatalf("expected\n%s\nbut got\n%s", expected, raw)
+	}
+	if err := SetFileValue(path, "server.port", "eighty"); !errors.Is(err, ErrConfigValue) {
+		t.Fatalf("expected invalid value but got %v", err)
+	}
+	if err := SetFileValue(path, "server.port", "70000"); !errors.Is(err, ErrConfigInvalid) {
+		t.Fatalf("expected invalid config but got %v", err)
+	}
+	if err := SetFileValue(path, "server", "x"); !errors.Is(err, ErrConfigPath) {
+		t.Fatalf("expected unknown path but got %v", err)
+	}
+	if after, _ := os.ReadFile(path); string(after) != expected {
+		t.Fatalf("expected file unchanged after errors but got\n%s", after)
+	}
+}
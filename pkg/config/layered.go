@@ -0,0 +1,534 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/palette"
+	"github.com/wendisx/puzzle/pkg/util"
+	"go.yaml.in/yaml/v3"
+)
+
+/*
+	config.layered -- [submodule]
+LoadLayered merges the configuration from the layers below, each later one overrides
+the values set by the former:
+1. default: the built-in defaults of every section.
+2. file: the base file, like `config.yaml`, in YAML, JSON, TOML or dotenv by util.DetectFormat,
+read from WithFS if set. An empty path is the default path, skipped with its profile
+overlay if not exists so the defaults, env and flags still load, while a given path
+must exist.
+3. profile: the overlay file next to the base with the profile as suffix, like
+`config-dev.yaml`. The profile comes from WithProfile, the flag `--profile` or the
+env `PUZZLE_PROFILE`, no overlay without profile.
+4. env: variables named by the prefix and the yaml path of field in upper snake case,
+like `PUZZLE_SERVER_PORT` for `server.port` and `PUZZLE_DATABASE_SQL_DSN` for `database.sql.dsn`.
+Slices are separated by comma.
+5. flag: changed flags named by the yaml path with dots or dashes, like
+`--server.port` or `--server-port`, or mapped by WithFlagAlias.
+
+The returned Provenance tells which layer, and which file, variable or flag, set
+every value. It is also kept in the config dict and returned by GetProvenance.
+*/
+
+const (
+	LAYER_DEFAULT = "default"
+	LAYER_FILE    = "file"
+	LAYER_PROFILE = "profile"
+	LAYER_ENV     = "env"
+	LAYER_FLAG    = "flag"
+
+	DATAKEY_CONFIG_PROVENANCE = "_data_config_provenance"
+
+	_default_env_prefix = "PUZZLE"
+	_profile_flag       = "profile"
+	_profile_env        = "PROFILE"
+)
+
+var (
+	ErrConfigValue = errors.New("invalid config value")
+)
+
+type (
+	// Functional layered loading configuration.
+	LoadOption    func(l *layeredLoader)
+	layeredLoader struct {
+		profile   string
+		envPrefix string
+		flags     *pflag.FlagSet
+		fsys      fs.FS             // read files from fsys if not nil
+		aliases   map[string]string // flag name -> config path
+	}
+	// ProvenanceEntry tell where a config value comes from.
+	ProvenanceEntry struct {
+		Path   string `json:"path"`   // yaml path like server.port
+		Layer  string `json:"layer"`  // one of LAYER_*
+		Source string `json:"source"` // file, env or flag name
+		Value  string `json:"value"`
+		Line   int    `json:"line,omitempty"` // line in file
+	}
+	// Provenance record the source of every config value.
+	Provenance struct {
+		entries map[string]ProvenanceEntry
+	}
+	// leaf field of config
+	configField struct {
+		path   string
+		value  reflect.Value
+		secret bool // tagged secret:"true"
+	}
+)
+
+// WithProfile choose the profile overlay.
+func WithProfile(profile string) LoadOption {
+	return func(l *layeredLoader) {
+		l.profile = profile
+	}
+}
+
+// WithEnvPrefix set the prefix of env variables, default PUZZLE.
+func WithEnvPrefix(prefix string) LoadOption {
+	return func(l *layeredLoader) {
+		l.envPrefix = prefix
+	}
+}
+
+// WithFlags apply the changed flags of fs, `--profile` in fs chooses the profile.
+func WithFlags(fs *pflag.FlagSet) LoadOption {
+	return func(l *layeredLoader) {
+		l.flags = fs
+	}
+}
+
+// WithFS read the files from fsys, like the default config in embed.FS.
+func WithFS(fsys fs.FS) LoadOption {
+	return func(l *layeredLoader) {
+		l.fsys = fsys
+	}
+}
+
+// WithFlagAlias map the flag to the config path, like `port` to `server.port`.
+func WithFlagAlias(flag, path string) LoadOption {
+	return func(l *layeredLoader) {
+		l.aliases[flag] = path
+	}
+}
+
+// LoadLayered load config from all layers, put it into the config dict and return it with its provenance.
+func LoadLayered(path string, opts ...LoadOption) (*Config, *Provenance, error) {
+	c, pv, err := newLayeredLoader(opts...).load(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	configDict := loadConfigDict()
+	configDict.Record(DATAKEY_CONFIG, c)
+	configDict.Record(DATAKEY_CONFIG_PROVENANCE, pv)
+	applyConfig(c)
+	return c, pv, nil
+}
+
+// GetProvenance return the provenance of config loaded by LoadLayered, false if none.
+func GetProvenance() (*Provenance, bool) {
+	return Get[*Provenance](DICTKEY_CONFIG, DATAKEY_CONFIG_PROVENANCE)
+}
+
+// Source return where the value of path comes from.
+func (pv *Provenance) Source(path string) (ProvenanceEntry, bool) {
+	e, found := pv.entries[path]
+	return e, found
+}
+
+// Entries return all entries sorted by path.
+func (pv *Provenance) Entries() []ProvenanceEntry {
+	entries := make([]ProvenanceEntry, 0, len(pv.entries))
+	for _, e := range pv.entries {
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b ProvenanceEntry) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return entries
+}
+
+// String return the report with one line per value.
+func (pv *Provenance) String() string {
+	var sb strings.Builder
+	for _, e := range pv.Entries() {
+		source := e.Layer
+		if e.Source != "" {
+			source += " " + e.Source
+		}
+		if e.Line > 0 {
+			source += ":" + strconv.Itoa(e.Line)
+		}
+		fmt.Fprintf(&sb, "%s = %s (%s)\n", e.Path, e.Value, source)
+	}
+	return sb.String()
+}
+
+func (pv *Provenance) set(path, layer, source string, line int) {
+	e := pv.entries[path]
+	e.Path, e.Layer, e.Source, e.Line = path, layer, source, line
+	pv.entries[path] = e
+}
+
+func (pv *Provenance) value(path string, v reflect.Value, secret bool) {
+	if e, found := pv.entries[path]; found {
+		e.Value = formatValue(v)
+		if secret && !v.IsZero() {
+			e.Value = REDACTED
+		}
+		pv.entries[path] = e
+	}
+}
+
+func newLayeredLoader(opts ...LoadOption) *layeredLoader {
+	l := &layeredLoader{
+		envPrefix: _default_env_prefix,
+		aliases:   make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// load parse and validate the config from all layers without storing it.
+func (l *layeredLoader) load(path string) (*Config, *Provenance, error) {
+	c := defaultConfig()
+	pv := &Provenance{entries: make(map[string]ProvenanceEntry)}
+	for _, f := range configFields(c) {
+		pv.set(f.path, LAYER_DEFAULT, "", 0)
+	}
+	files := l.files(path)
+	if path == "" && !l.exists(files[0]) {
+		// the default file is optional
+		clog.Info(fmt.Sprintf("config(%s) not found, load without file", palette.SkyBlue(files[0])))
+		files = nil
+	}
+	if len(files) > 0 {
+		if err := l.overlayFile(c, pv, files[0], LAYER_FILE); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(files) > 1 {
+		if err := l.overlayFile(c, pv, files[1], LAYER_PROFILE); err != nil {
+			return nil, nil, err
+		}
+	}
+	fields := configFields(c)
+	if err := l.overlayEnv(fields, pv); err != nil {
+		return nil, nil, err
+	}
+	if err := l.overlayFlags(fields, pv); err != nil {
+		return nil, nil, err
+	}
+	resolved, err := resolveSecrets(configFields(c), l.envPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range configFields(c) {
+		pv.value(f.path, f.value, f.secret || resolved[f.path])
+	}
+	if err := ValidateConfig(c, pv); err != nil {
+		return nil, nil, err
+	}
+	return c, pv, nil
+}
+
+// files return the base file and the profile overlay if any.
+func (l *layeredLoader) files(path string) []string {
+	if path == "" {
+		path = _default_config_path
+	}
+	files := []string{path}
+	if profile := l.chooseProfile(); profile != "" {
+		ext := filepath.Ext(path)
+		files = append(files, strings.TrimSuffix(path, ext)+"-"+profile+ext)
+	}
+	return files
+}
+
+func (l *layeredLoader) chooseProfile() string {
+	if l.profile != "" {
+		return l.profile
+	}
+	if l.flags != nil {
+		if f := l.flags.Lookup(_profile_flag); f != nil && f.Value.String() != "" {
+			return f.Value.String()
+		}
+	}
+	return os.Getenv(l.envPrefix + "_" + _profile_env)
+}
+
+func (l *layeredLoader) overlayEnv(fields []configField, pv *Provenance) error {
+	for _, f := range fields {
+		name := l.envPrefix + "_" + util.UpperSnake(f.path)
+		str, found := os.LookupEnv(name)
+		if !found {
+			continue
+		}
+		if err := setValue(f.value, str); err != nil {
+			return fmt.Errorf("%w: env %s for %s", err, name, f.path)
+		}
+		pv.set(f.path, LAYER_ENV, name, 0)
+	}
+	return nil
+}
+
+func (l *layeredLoader) overlayFlags(fields []configField, pv *Provenance) error {
+	if l.flags == nil {
+		return nil
+	}
+	byPath := make(map[string]configField, len(fields))
+	for _, f := range fields {
+		byPath[f.path] = f
+	}
+	var err error
+	l.flags.Visit(func(fl *pflag.Flag) {
+		path, found := l.aliases[fl.Name]
+		if !found {
+			path = strings.ReplaceAll(fl.Name, "-", ".")
+		}
+		f, found := byPath[path]
+		if !found || err != nil {
+			return
+		}
+		str := fl.Value.String()
+		if _, ok := fl.Value.(pflag.SliceValue); ok {
+			str = strings.Trim(str, "[]")
+		}
+		if err = setValue(f.value, str); err != nil {
+			err = fmt.Errorf("%w: flag --%s for %s", err, fl.Name, path)
+			return
+		}
+		pv.set(path, LAYER_FLAG, "--"+fl.Name, 0)
+	})
+	return err
+}
+
+// overlayFile decode the file over c and mark the paths it sets, lines are only known for yaml and json.
+func (l *layeredLoader) overlayFile(c *Config, pv *Provenance, path, layer string) error {
+	raw, err := l.readFile(path)
+	if err != nil {
+		clog.Error(fmt.Sprintf("read config(%s) fail for %s", palette.Red(path), err.Error()))
+		return err
+	}
+	var doc yaml.Node
+	lines := true
+	switch util.DetectFormat(path, raw) {
+	case util.FORMAT_TOML:
+		var m map[string]any
+		if err = util.ParseBytes(raw, util.FORMAT_TOML, &m); err == nil {
+			lines = false
+			err = doc.Encode(m)
+		}
+	case util.FORMAT_DOTENV:
+		return l.overlayDotenv(c, pv, path, layer, raw)
+	default:
+		err = yaml.Unmarshal(raw, &doc)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config(%s) fail for %w", path, err)
+	}
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return nil
+		}
+		doc = *doc.Content[0]
+	}
+	if err = doc.Decode(c); err != nil {
+		return fmt.Errorf("decode config(%s) fail for %w", path, err)
+	}
+	known := make(map[string]bool)
+	for _, f := range configFields(c) {
+		known[f.path] = true
+	}
+	walkYaml(&doc, "", func(p string, n *yaml.Node) {
+		// unknown keys are ignored like the decoder does
+		if !known[p] {
+			return
+		}
+		if lines {
+			pv.set(p, layer, path, n.Line)
+		} else {
+			pv.set(p, layer, path, 0)
+		}
+	})
+	clog.Info(fmt.Sprintf("overlay config(%s) as %s layer", palette.SkyBlue(path), palette.SkyBlue(layer)))
+	return nil
+}
+
+// overlayDotenv set the fields by the keys in upper snake case, like SERVER_PORT.
+func (l *layeredLoader) overlayDotenv(c *Config, pv *Provenance, path, layer string, raw []byte) error {
+	var vars map[string]string
+	if err := util.ParseBytes(raw, util.FORMAT_DOTENV, &vars); err != nil {
+		return fmt.Errorf("parse config(%s) fail for %w", path, err)
+	}
+	for _, f := range configFields(c) {
+		str, found := vars[util.UpperSnake(f.path)]
+		if !found {
+			continue
+		}
+		if err := setValue(f.value, str); err != nil {
+			return fmt.Errorf("%w: %s in %s", err, util.UpperSnake(f.path), path)
+		}
+		pv.set(f.path, layer, path, 0)
+	}
+	clog.Info(fmt.Sprintf("overlay config(%s) as %s layer", palette.SkyBlue(path), palette.SkyBlue(layer)))
+	return nil
+}
+
+func (l *layeredLoader) exists(path string) bool {
+	var err error
+	if l.fsys != nil {
+		_, err = fs.Stat(l.fsys, path)
+	} else {
+		_, err = os.Stat(path)
+	}
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+func (l *layeredLoader) readFile(path string) ([]byte, error) {
+	if l.fsys != nil {
+		return fs.ReadFile(l.fsys, path)
+	}
+	return os.ReadFile(path)
+}
+
+// walkYaml call fn for every leaf of mapping node, a sequence is a leaf.
+func walkYaml(n *yaml.Node, prefix string, fn func(path string, n *yaml.Node)) {
+	if n.Kind != yaml.MappingNode {
+		fn(prefix, n)
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := n.Content[i].Value
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		walkYaml(n.Content[i+1], key, fn)
+	}
+}
+
+// configFields return the leaf fields of c by yaml path, nil pointers are skipped.
+func configFields(c any) []configField {
+	var fields []configField
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := range t.NumField() {
+			sf := t.Field(i)
+			if !sf.IsExported() {
+				continue
+			}
+			name, inline := yamlName(sf)
+			if name == "-" {
+				continue
+			}
+			fv := v.Field(i)
+			path := name
+			if inline {
+				path = strings.TrimSuffix(prefix, ".")
+			} else if prefix != "" {
+				path = prefix + name
+			}
+			if fv.Kind() == reflect.Pointer && fv.Type().Elem().Kind() == reflect.Struct {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct && fv.Type() != reflect.TypeFor[time.Time]() {
+				next := path + "."
+				if path == "" {
+					next = ""
+				}
+				walk(fv, next)
+				continue
+			}
+			// named sections like database.sql.main, the pointers keep them settable
+			if isNamedMap(fv.Type()) {
+				for _, k := range sortedKeys(fv) {
+					if ev := fv.MapIndex(k); !ev.IsNil() {
+						walk(ev.Elem(), path+"."+k.String()+".")
+					}
+				}
+				continue
+			}
+			fields = append(fields, configField{path: path, value: fv, secret: sf.Tag.Get("secret") == "true"})
+		}
+	}
+	walk(reflect.ValueOf(c).Elem(), "")
+	return fields
+}
+
+// isNamedMap tell whether t is a map from names to the pointers of sections.
+func isNamedMap(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String &&
+		t.Elem().Kind() == reflect.Pointer && t.Elem().Elem().Kind() == reflect.Struct
+}
+
+// sortedKeys return the keys of map v in order.
+func sortedKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	slices.SortFunc(keys, func(a, b reflect.Value) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	return keys
+}
+
+// yamlName return the key of field in yaml and whether it is inlined.
+func yamlName(sf reflect.StructField) (string, bool) {
+	name, opts, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+	if name == "" {
+		name = strings.ToLower(sf.Name)
+	}
+	return name, strings.Contains(opts, "inline")
+}
+
+// setValue parse str into v of scalar, time.Duration or slice kind.
+func setValue(v reflect.Value, str string) error {
+	if err := util.SetString(v, str); err != nil {
+		return fmt.Errorf("%w: %s", ErrConfigValue, err.Error())
+	}
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		// the optional values, empty if not set
+		if v.IsNil() {
+			return ""
+		}
+		return formatValue(v.Elem())
+	}
+	if v.Kind() == reflect.Slice {
+		parts := make([]string, v.Len())
+		for i := range v.Len() {
+			parts[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// loadConfigDict return the config dict, create it if not exists.
+func loadConfigDict() DataDict[any] {
+	if HasDict(DICTKEY_CONFIG) {
+		return GetDict(DICTKEY_CONFIG)
+	}
+	configDict := NewDataDict[any](DICTKEY_CONFIG, WithNoExpiry())
+	PutDict(configDict.Name(), configDict)
+	return configDict
+}
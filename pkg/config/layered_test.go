@@ -0,0 +1,142 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/spf13/pflag"
+)
+
+func test_write_yaml(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err.Error())
+	}
+	return path
+}
+
+func test_layered_files(t *testing.T) string {
+	dir := t.TempDir()
+	path := test_write_yaml(t, dir, "config.yaml", `server:
+  host: base.local
+  port: 8000
+  readTimeout: 5
+database:
+  sql:
+    driver: mysql
+    dsn: base-dsn
+unknown: ignored
+`)
+	test_write_yaml(t, dir, "config-dev.yaml", `server:
+  port: 8080
+swagger:
+  oauth:
+    realm: dev
+`)
+	return path
+}
+
+func test_source(t *testing.T, pv *Provenance, path, layer, source string) {
+	t.Helper()
+	e, found := pv.Source(path)
+	if !found || e.Layer != layer || e.Source != source {
+		t.Fatalf("expected %s from %s %s but got %+v", path, layer, source, e)
+	}
+}
+
+// test layers override in order with provenance [passed]
+func Test_load_layered(t *testing.T) {
+	path := test_layered_files(t)
+	profile := strings.TrimSuffix(path, ".yaml") + "-dev.yaml"
+	t.Setenv("PUZZLE_PROFILE", "dev")
+	t.Setenv("PUZZLE_SERVER_READ_TIMEOUT", "30")
+	t.Setenv("PUZZLE_DATABASE_SQL_DSN", "env-dsn")
+	t.Setenv("PUZZLE_SERVER_MAX_HEADER_BYTES", "2, 10")
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("profile", "", "")
+	fs.String("database-sql-dsn", "", "")
+	fs.Int("port", 0, "")
+	fs.Bool("server.enableOptions", true, "")
+	if err := fs.Parse([]string{"--port=9090", "--server.enableOptions=false"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	c, pv, err := LoadLayered(path, WithFlags(fs), WithFlagAlias("port", "server.port"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if c.ServerConfig.Host != "base.local" || c.ServerConfig.Port != 9090 || c.ServerConfig.ReadTimeout != 30 || c.ServerConfig.EnableOptions {
+		t.Fatalf("unexpected server config %+v", c.ServerConfig)
+	}
+	if c.DBConfig.SqlDBConfig.Dsn != "env-dsn" || c.DBConfig.SqlDBConfig.MaxIdleConn != 20 {
+		t.Fatalf("unexpected sql config %+v", c.DBConfig.SqlDBConfig)
+	}
+	if len(c.ServerConfig.MaxHeaderBytes) != 2 || c.ServerConfig.MaxHeaderBytes[1] != 10 {
+		t.Fatalf("unexpected max header bytes %v", c.ServerConfig.MaxHeaderBytes)
+	}
+	if c.SwagConfig.OAuth == nil || c.SwagConfig.OAuth.Realm != "dev" {
+		t.Fatalf("unexpected oauth %+v", c.SwagConfig.OAuth)
+	}
+	test_source(t, pv, "server.host", LAYER_FILE, path)
+	test_source(t, pv, "server.port", LAYER_FLAG, "--port")
+	test_source(t, pv, "server.enableOptions", LAYER_FLAG, "--server.enableOptions")
+	test_source(t, pv, "server.readTimeout", LAYER_ENV, "PUZZLE_SERVER_READ_TIMEOUT")
+	test_source(t, pv, "swagger.oauth.realm", LAYER_PROFILE, profile)
+	test_source(t, pv, "database.sql.maxIdleConn", LAYER_DEFAULT, "")
+	if e, _ := pv.Source("server.host"); e.Line != 2 || e.Value != "base.local" {
+		t.Fatalf("expected line 2 and value but got %+v", e)
+	}
+	if _, found := pv.Source("unknown"); found {
+		t.Fatal("expected unknown key ignored")
+	}
+	if !strings.Contains(pv.String(), "server.port = 9090 (flag --port)\n") {
+		t.Fatalf("unexpected report\n%s", pv.String())
+	}
+	if got, ok := GetProvenance(); !ok || got != pv || GetConfig() != c {
+		t.Fatal("expected config and provenance in the config dict")
+	}
+}
+
+// test missing profile file and bad values fail [passed]
+func Test_load_layered_error(t *testing.T) {
+	path := test_layered_files(t)
+	if _, _, err := LoadLayered(path, WithProfile("prod")); err == nil {
+		t.Fatal("expected missing profile error")
+	}
+	t.Setenv("APP_SERVER_PORT", "eighty")
+	if _, _, err := LoadLayered(path, WithEnvPrefix("APP")); err == nil || !strings.Contains(err.Error(), "APP_SERVER_PORT") {
+		t.Fatalf("expected invalid env error but got %v", err)
+	}
+}
+
+// test toml and dotenv files and files in fs.FS [passed]
+func Test_load_layered_formats(t *testing.T) {
+	fsys := fstest.MapFS{
+		"config.toml":     {Data: []byte("[server]\nhost = \"toml.local\"\nport = 8000\n[database.sql]\nmaxIdleConn = 5\n")},
+		"config-dev.toml": {Data: []byte("[server]\nport = 8080\n")},
+		"app.env":         {Data: []byte("SERVER_PORT=7000\nSWAGGER_URLS=a,b\n")},
+	}
+	c, pv, err := LoadLayered("config.toml", WithFS(fsys), WithProfile("dev"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if c.ServerConfig.Host != "toml.local" || c.ServerConfig.Port != 8080 || c.DBConfig.SqlDBConfig.MaxIdleConn != 5 {
+		t.Fatalf("unexpected config %+v", *(*plainConfig)(c))
+	}
+	test_source(t, pv, "server.port", LAYER_PROFILE, "config-dev.toml")
+	test_source(t, pv, "database.sql.maxIdleConn", LAYER_FILE, "config.toml")
+	c, pv, err = LoadLayered("app.env", WithFS(fsys))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if c.ServerConfig.Port != 7000 || len(c.SwagConfig.URLs) != 2 {
+		t.Fatalf("unexpected config %+v", *(*plainConfig)(c))
+	}
+	test_source(t, pv, "swagger.urls", LAYER_FILE, "app.env")
+	if c = LoadConfigFS(fsys, "config.toml"); c.ServerConfig.Host != "toml.local" || GetConfig() != c {
+		t.Fatalf("unexpected config %+v", *(*plainConfig)(c))
+	}
+}
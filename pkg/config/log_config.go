@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/wendisx/puzzle/pkg/clog"
+)
+
+type (
+	// LogConfig drives the default logger, it is applied again on reload.
+	LogConfig struct {
+		Level string `yaml:"level" json:"level"` // debug, info, warn or error
+	}
+)
+
+func initLogConfig() LogConfig {
+	return LogConfig{Level: "info"}
+}
+
+// LogLevel return the clog level of Level, false if unknown.
+func (lc LogConfig) LogLevel() (clog.LogLevel, bool) {
+	var lv slog.Level
+	if err := lv.UnmarshalText([]byte(lc.Level)); err != nil || lv < clog.DEBUG || lv > clog.ERROR {
+		return clog.INFO, false
+	}
+	return lv, true
+}
+
+func (lc LogConfig) crossCheck() []ConfigError {
+	if _, ok := lc.LogLevel(); !ok {
+		return []ConfigError{{Path: "level", Rule: "oneof", Value: lc.Level, Message: fmt.Sprintf("level %s is not one of debug, info, warn and error", lc.Level)}}
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+/*
+	config.reload -- [submodule]
+Reloader reloads the config without restarting. It polls the config file, and the
+profile overlay, for changes and also reloads on SIGHUP. Every reload parses the
+layers again like LoadLayered and runs the validators, then swaps the *Config under
+DATAKEY_CONFIG in one step and notifies the subscribers:
+1. Subscribe(r, fn) is called with ConfigDiff[*Config] on any change.
+2. OnSection[T](r, fn) is called with ConfigDiff[T] only when the section of type T
+changes, like OnSection[ServerConfig].
+If a validator fails, the old config is kept. If a subscriber fails, the old config
+is swapped back and the subscribers already notified get the reverse diff.
+*/
+
+const (
+	_default_reload_interval = 2 * time.Second
+)
+
+var (
+	ErrConfigInvalid   = errors.New("invalid config")
+	ErrReloaderRunning = errors.New("reloader is already running")
+)
+
+type (
+	// Functional reloader configuration.
+	ReloadOption func(r *Reloader)
+	// ConfigDiff carries the old and new value of a changed section.
+	ConfigDiff[T any] struct {
+		Section string // yaml name of section, empty for the whole config
+		Old     T
+		New     T
+	}
+	Reloader struct {
+		path       string // empty for the default one, which is optional like LoadLayered
+		name       string // the path in logs
+		loadOpts   []LoadOption
+		validators []func(c *Config) error
+		interval   time.Duration
+		signals    []os.Signal
+		current    atomic.Pointer[Config]
+		mu         sync.Mutex // serialize reloads
+		subMu      sync.RWMutex
+		subSeq     uint64
+		subs       []*reloadSubscriber
+		stats      map[string]fileStat
+		quit       chan struct{}
+		done       chan struct{}
+	}
+	reloadSubscriber struct {
+		id     uint64
+		notify func(old, new *Config) error
+	}
+	fileStat struct {
+		modTime time.Time
+		size    int64
+	}
+)
+
+// WithReloadLoad set the options to load layers on every reload.
+func WithReloadLoad(opts ...LoadOption) ReloadOption {
+	return func(r *Reloader) {
+		r.loadOpts = append(r.loadOpts, opts...)
+	}
+}
+
+// WithReloadValidator add a validator, the new config is rejected if it fails.
+func WithReloadValidator(fn func(c *Config) error) ReloadOption {
+	return func(r *Reloader) {
+		r.validators = append(r.validators, fn)
+	}
+}
+
+// WithReloadInterval set the interval to poll the files, 0 disables polling.
+func WithReloadInterval(d time.Duration) ReloadOption {
+	return func(r *Reloader) {
+		r.interval = d
+	}
+}
+
+// WithReloadSignals set the signals to trigger a reload, default SIGHUP.
+func WithReloadSignals(sig ...os.Signal) ReloadOption {
+	return func(r *Reloader) {
+		r.signals = sig
+	}
+}
+
+// NewReloader return a reloader of the config path, empty path for the default one.
+func NewReloader(path string, opts ...ReloadOption) *Reloader {
+	name := path
+	if name == "" {
+		name = _default_config_path
+	}
+	r := &Reloader{
+		path:     path,
+		name:     name,
+		interval: _default_reload_interval,
+		signals:  []os.Signal{syscall.SIGHUP},
+		stats:    make(map[string]fileStat),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Subscribe call fn with the whole config when it changes, return the function to unsubscribe.
+func Subscribe(r *Reloader, fn func(d ConfigDiff[*Config]) error) (cancel func()) {
+	return r.subscribe(func(old, new *Config) error {
+		if reflect.DeepEqual(old, new) {
+			return nil
+		}
+		return fn(ConfigDiff[*Config]{Old: old, New: new})
+	})
+}
+
+// OnSection call fn when the section of type T in Config changes, like OnSection[ServerConfig],
+// and will panic if no such section.
+func OnSection[T any](r *Reloader, fn func(d ConfigDiff[T]) error) (cancel func()) {
+	idx, section := -1, ""
+	t := reflect.TypeFor[Config]()
+	for i := range t.NumField() {
+		if t.Field(i).Type == reflect.TypeFor[T]() {
+			idx = i
+			section = t.Field(i).Tag.Get("yaml")
+			break
+		}
+	}
+	if idx < 0 {
+		clog.Panic(fmt.Sprintf("no section of type(%s) in config", palette.Red(reflect.TypeFor[T]())))
+	}
+	return r.subscribe(func(old, new *Config) error {
+		var o T
+		if old != nil {
+			o = reflect.ValueOf(old).Elem().Field(idx).Interface().(T)
+		}
+		n := reflect.ValueOf(new).Elem().Field(idx).Interface().(T)
+		if old != nil && reflect.DeepEqual(o, n) {
+			return nil
+		}
+		return fn(ConfigDiff[T]{Section: section, Old: o, New: n})
+	})
+}
+
+// Current return the config in use.
+func (r *Reloader) Current() *Config {
+	return r.current.Load()
+}
+
+// Start load the config if not loaded yet, then watch the files and signals.
+func (r *Reloader) Start() error {
+	r.mu.Lock()
+	if r.quit != nil {
+		r.mu.Unlock()
+		return ErrReloaderRunning
+	}
+	r.mu.Unlock()
+	if c, ok := Get[*Config](DICTKEY_CONFIG, DATAKEY_CONFIG); ok && c != nil {
+		r.mu.Lock()
+		r.current.Store(c)
+		r.changed()
+		r.mu.Unlock()
+	} else if err := r.Reload(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.quit, r.done = make(chan struct{}), make(chan struct{})
+	// listen before returning so no signal is missed
+	sig := make(chan os.Signal, 1)
+	if len(r.signals) > 0 {
+		signal.Notify(sig, r.signals...)
+	}
+	go r.run(sig, r.quit, r.done)
+	clog.Info(fmt.Sprintf("watch config(%s) for reload", palette.SkyBlue(r.name)))
+	return nil
+}
+
+// Stop stop watching, the config in use is kept.
+func (r *Reloader) Stop() {
+	r.mu.Lock()
+	quit, done := r.quit, r.done
+	r.quit, r.done = nil, nil
+	r.mu.Unlock()
+	if quit == nil {
+		return
+	}
+	close(quit)
+	<-done
+}
+
+// Reload parse and validate the config, then swap it and notify the subscribers.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, pv, err := newLayeredLoader(r.loadOpts...).load(r.path)
+	if err != nil {
+		clog.Error(fmt.Sprintf("reload config(%s) fail for %s", palette.Red(r.name), err.Error()))
+		return err
+	}
+	for _, validate := range r.validators {
+		if err = validate(c); err != nil {
+			err = fmt.Errorf("%w: %w", ErrConfigInvalid, err)
+			clog.Error(fmt.Sprintf("reject config(%s) for %s", palette.Red(r.name), err.Error()))
+			return err
+		}
+	}
+	old := r.current.Load()
+	oldPv, _ := GetProvenance()
+	r.swap(c, pv)
+	r.subMu.RLock()
+	subs := append([]*reloadSubscriber(nil), r.subs...)
+	r.subMu.RUnlock()
+	for i, sub := range subs {
+		if err = sub.notify(old, c); err == nil {
+			continue
+		}
+		// roll back, the notified ones get the reverse diff
+		r.swap(old, oldPv)
+		if old != nil {
+			for _, notified := range subs[:i] {
+				notified.notify(c, old)
+			}
+		}
+		err = fmt.Errorf("%w: %w", ErrConfigInvalid, err)
+		clog.Error(fmt.Sprintf("roll back config(%s) for %s", palette.Red(r.name), err.Error()))
+		return err
+	}
+	r.changed()
+	clog.Info(fmt.Sprintf("reload config(%s)", palette.Green(r.name)))
+	return nil
+}
+
+func (r *Reloader) subscribe(notify func(old, new *Config) error) (cancel func()) {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+	r.subSeq++
+	id := r.subSeq
+	r.subs = append(r.subs, &reloadSubscriber{id: id, notify: notify})
+	return func() {
+		r.subMu.Lock()
+		defer r.subMu.Unlock()
+		for i, sub := range r.subs {
+			if sub.id == id {
+				r.subs = append(r.subs[:i], r.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (r *Reloader) swap(c *Config, pv *Provenance) {
+	r.current.Store(c)
+	applyConfig(c)
+	configDict := loadConfigDict()
+	if c == nil {
+		configDict.Remove(DATAKEY_CONFIG)
+	} else {
+		configDict.Record(DATAKEY_CONFIG, c)
+	}
+	if pv == nil {
+		configDict.Remove(DATAKEY_CONFIG_PROVENANCE)
+	} else {
+		configDict.Record(DATAKEY_CONFIG_PROVENANCE, pv)
+	}
+}
+
+func (r *Reloader) run(sig chan os.Signal, quit, done chan struct{}) {
+	defer close(done)
+	defer signal.Stop(sig)
+	var tick <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-quit:
+			return
+		case s := <-sig:
+			clog.Info(fmt.Sprintf("reload config(%s) for signal(%s)", palette.SkyBlue(r.name), palette.SkyBlue(s)))
+			r.Reload()
+		case <-tick:
+			r.mu.Lock()
+			changed := r.changed()
+			r.mu.Unlock()
+			if changed {
+				r.Reload()
+			}
+		}
+	}
+}
+
+// changed update the file stats and report whether any file changed, must hold mu.
+func (r *Reloader) changed() bool {
+	changed := false
+	for _, file := range newLayeredLoader(r.loadOpts...).files(r.path) {
+		var st fileStat
+		if info, err := os.Stat(file); err == nil {
+			st = fileStat{modTime: info.ModTime(), size: info.Size()}
+		}
+		if old, found := r.stats[file]; !found || old != st {
+			r.stats[file] = st
+			changed = changed || found
+		}
+	}
+	return changed
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func test_reloader(t *testing.T, opts ...ReloadOption) (*Reloader, string) {
+	t.Helper()
+	path := test_write_yaml(t, t.TempDir(), "config.yaml", "server:\n  port: 8000\n")
+	configDict := loadConfigDict()
+	configDict.Remove(DATAKEY_CONFIG)
+	r := NewReloader(path, opts...)
+	if err := r.Start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(r.Stop)
+	return r, path
+}
+
+// change the file with a different size so the poll sees it
+func test_rewrite(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func test_wait_port(t *testing.T, port int) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); GetConfig().ServerConfig.Port != port; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected port %d but got %d", port, GetConfig().ServerConfig.Port)
+		}
+	}
+}
+
+// test file changes are reloaded and section subscribers get the diff [passed]
+func Test_reload_watch(t *testing.T) {
+	r, path := test_reloader(t, WithReloadInterval(5*time.Millisecond))
+	if err := r.Start(); !errors.Is(err, ErrReloaderRunning) {
+		t.Fatalf("expected ErrReloaderRunning but got %v", err)
+	}
+	diffs := make(chan ConfigDiff[ServerConfig], 4)
+	OnSection(r, func(d ConfigDiff[ServerConfig]) error {
+		diffs <- d
+		return nil
+	})
+	swagger := 0
+	OnSection(r, func(d ConfigDiff[SwagConfig]) error {
+		swagger++
+		return nil
+	})
+	test_rewrite(t, path, "server:\n  port: 9000\n")
+	test_wait_port(t, 9000)
+	d := <-diffs
+	if d.Section != "server" || d.Old.Port != 8000 || d.New.Port != 9000 || r.Current() != GetConfig() {
+		t.Fatalf("unexpected diff %+v", d)
+	}
+	if swagger != 0 {
+		t.Fatal("expected unchanged section not notified")
+	}
+}
+
+// test invalid config and failed subscribers keep the old config [passed]
+func Test_reload_rollback(t *testing.T) {
+	r, path := test_reloader(t, WithReloadInterval(0), WithReloadValidator(func(c *Config) error {
+		if c.ServerConfig.Port <= 0 {
+			return errors.New("port must be positive")
+		}
+		return nil
+	}))
+	old := GetConfig()
+	test_rewrite(t, path, "server:\n  port: -1\n")
+	if err := r.Reload(); !errors.Is(err, ErrConfigInvalid) || GetConfig() != old {
+		t.Fatalf("expected rejected but got %v", err)
+	}
+	var got []int
+	Subscribe(r, func(d ConfigDiff[*Config]) error {
+		got = append(got, d.New.ServerConfig.Port)
+		return nil
+	})
+	OnSection(r, func(d ConfigDiff[ServerConfig]) error {
+		if d.New.Port == 9999 {
+			return errors.New("port in use")
+		}
+		return nil
+	})
+	test_rewrite(t, path, "server:\n  port: 9999\n")
+	if err := r.Reload(); !errors.Is(err, ErrConfigInvalid) || GetConfig() != old || r.Current() != old {
+		t.Fatalf("expected rolled back but got %v", err)
+	}
+	// the first subscriber is notified of the change and of the roll back
+	if len(got) != 2 || got[0] != 9999 || got[1] != 8000 {
+		t.Fatalf("expected 9999 then 8000 but got %v", got)
+	}
+}
+
+// test SIGHUP triggers a reload [passed]
+func Test_reload_signal(t *testing.T) {
+	_, path := test_reloader(t, WithReloadInterval(0))
+	test_rewrite(t, path, "server:\n  port: 7000\n")
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err.Error())
+	}
+	test_wait_port(t, 7000)
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	_schema_draft = "http://json-schema.org/draft-07/schema#"
+)
+
+type (
+	// JSON Schema subset for editors.
+	jsonSchema struct {
+		Schema               string                 `json:"$schema,omitempty"`
+		Title                string                 `json:"title,omitempty"`
+		Type                 string                 `json:"type,omitempty"`
+		Format               string                 `json:"format,omitempty"`
+		Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+		AdditionalProperties any                    `json:"additionalProperties,omitempty"` // false or the schema of named sections
+		Items                *jsonSchema            `json:"items,omitempty"`
+		Default              any                    `json:"default,omitempty"`
+		Minimum              *int64                 `json:"minimum,omitempty"`
+		Maximum              *int64                 `json:"maximum,omitempty"`
+		MinLength            *int64                 `json:"minLength,omitempty"`
+		MaxLength            *int64                 `json:"maxLength,omitempty"`
+	}
+)
+
+// JSONSchema return the JSON Schema of Config for editor autocompletion, the check
+// rules become the bounds and the built-in defaults become the defaults.
+func JSONSchema() ([]byte, error) {
+	defaults := defaultConfig()
+	s := schemaOf(reflect.ValueOf(defaults).Elem())
+	s.Schema, s.Title = _schema_draft, "puzzle config"
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// schemaOf return the schema of type of v, v holds the defaults.
+func schemaOf(v reflect.Value) *jsonSchema {
+	t := v.Type()
+	if t == reflect.TypeFor[time.Duration]() {
+		return &jsonSchema{Type: "string", Format: "duration", Default: defaultOf(v)}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(reflect.New(t.Elem()).Elem())
+	case reflect.Struct:
+		closed := false
+		s := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema), AdditionalProperties: &closed}
+		var named reflect.Type
+		for i := range t.NumField() {
+			sf := t.Field(i)
+			name, inline := yamlName(sf)
+			if !sf.IsExported() || name == "-" {
+				continue
+			}
+			if inline && isNamedMap(sf.Type) {
+				named = sf.Type.Elem().Elem()
+				continue
+			}
+			fs := schemaOf(v.Field(i))
+			applyRules(fs, sf.Tag.Get("check"))
+			if inline {
+				for k, p := range fs.Properties {
+					s.Properties[k] = p
+				}
+				continue
+			}
+			s.Properties[name] = fs
+		}
+		// other keys are named sections, like database.sql.main of the same type
+		if named == t {
+			elem := *s
+			s.AdditionalProperties = &elem
+		} else if named != nil {
+			s.AdditionalProperties = schemaOf(reflect.New(named).Elem())
+		}
+		return s
+	case reflect.Map:
+		// sections by name, like flags.newCheckout
+		return &jsonSchema{Type: "object", AdditionalProperties: schemaOf(reflect.New(t.Elem()).Elem())}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: schemaOf(reflect.New(t.Elem()).Elem()), Default: defaultOf(v)}
+	case reflect.String:
+		return &jsonSchema{Type: "string", Default: defaultOf(v)}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean", Default: defaultOf(v)}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer", Default: defaultOf(v)}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number", Default: defaultOf(v)}
+	default:
+		return &jsonSchema{}
+	}
+}
+
+// defaultOf return the value if not zero.
+func defaultOf(v reflect.Value) any {
+	if v.IsZero() {
+		return nil
+	}
+	if v.Type() == reflect.TypeFor[time.Duration]() {
+		return time.Duration(v.Int()).String()
+	}
+	return v.Interface()
+}
+
+// applyRules map the check rules to the schema bounds.
+func applyRules(s *jsonSchema, rules string) {
+	for rule := range strings.SplitSeq(rules, ",") {
+		key, want, _ := strings.Cut(rule, "=")
+		n, err := strconv.ParseInt(want, 10, 64)
+		switch {
+		case key == "email" || key == "uuid":
+			s.Format = key
+		case err != nil:
+		case key == "min" && s.Type == "integer":
+			s.Minimum = &n
+		case key == "max" && s.Type == "integer":
+			s.Maximum = &n
+		case key == "min" && s.Type == "string":
+			s.MinLength = &n
+		case key == "max" && s.Type == "string":
+			s.MaxLength = &n
+		case key == "fixed" && s.Type == "string":
+			s.MinLength, s.MaxLength = &n, &n
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/util"
+)
+
+/*
+	config.secret -- [submodule]
+String values of config can refer to secrets, resolved at load time after all layers:
+1. `${env:NAME}` is replaced by the env variable NAME.
+2. `${file:/run/secrets/x}` is replaced by the content of file without the trailing newline.
+3. `enc:...` is decrypted by AES-GCM with the key in the env `PUZZLE_CONFIG_KEY`
+(`<prefix>_CONFIG_KEY` for WithEnvPrefix), the key is a base64 of 16, 24 or 32 bytes.
+References can be a part of value, like `mysql://root:${env:DB_PASSWORD}@/app`, while
+`enc:` must be the whole value. EncryptValue and DecryptValue, or `puzzle config
+encrypt/decrypt`, convert the values.
+Fields with the tag `secret:"true"`, and values resolved from references, are redacted
+in the provenance and the errors, and Redact return a copy of config to log or dump.
+*/
+
+const (
+	REDACTED = "******"
+
+	_secret_env_key    = "CONFIG_KEY"
+	_secret_enc_prefix = "enc:"
+)
+
+var (
+	ErrSecret = errors.New("unresolved config secret")
+
+	_secret_ref_pattern = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+)
+
+type (
+	// print config without the redaction
+	plainConfig Config
+)
+
+// SecretKey return the key of `enc:` values from the env PUZZLE_CONFIG_KEY.
+func SecretKey() ([]byte, error) {
+	return secretKey(_default_env_prefix)
+}
+
+func secretKey(prefix string) ([]byte, error) {
+	name := prefix + "_" + _secret_env_key
+	str, found := os.LookupEnv(name)
+	if !found || str == "" {
+		return nil, fmt.Errorf("%w: env %s not set for the key", ErrSecret, name)
+	}
+	key, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return nil, fmt.Errorf("%w: env %s is not base64", ErrSecret, name)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%w: key in env %s should be 16, 24 or 32 bytes", ErrSecret, name)
+	}
+}
+
+// EncryptValue encrypt plain to `enc:...` by AES-GCM.
+func EncryptValue(key []byte, plain string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return _secret_enc_prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptValue decrypt the `enc:...` value.
+func DecryptValue(key []byte, value string) (string, error) {
+	str, ok := strings.CutPrefix(value, _secret_enc_prefix)
+	if !ok {
+		return "", fmt.Errorf("%w: value without prefix %s", ErrSecret, _secret_enc_prefix)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return "", fmt.Errorf("%w: value is not base64", ErrSecret)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("%w: value is too short", ErrSecret)
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: decrypt fail for wrong key or broken value", ErrSecret)
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSecret, err.Error())
+	}
+	return cipher.NewGCM(block)
+}
+
+// resolveSecrets resolve the references in string fields and return the paths resolved.
+func resolveSecrets(fields []configField, prefix string) (map[string]bool, error) {
+	resolved := make(map[string]bool)
+	var key []byte
+	resolve := func(path, str string) (string, error) {
+		if strings.HasPrefix(str, _secret_enc_prefix) {
+			var err error
+			if key == nil {
+				if key, err = secretKey(prefix); err != nil {
+					return "", fmt.Errorf("%w for %s", err, path)
+				}
+			}
+			plain, err := DecryptValue(key, str)
+			if err != nil {
+				return "", fmt.Errorf("%w for %s", err, path)
+			}
+			resolved[path] = true
+			return plain, nil
+		}
+		var err error
+		out := _secret_ref_pattern.ReplaceAllStringFunc(str, func(ref string) string {
+			m := _secret_ref_pattern.FindStringSubmatch(ref)
+			resolved[path] = true
+			switch m[1] {
+			case "env":
+				v, found := os.LookupEnv(m[2])
+				if !found && err == nil {
+					err = fmt.Errorf("%w: env %s not set for %s", ErrSecret, m[2], path)
+				}
+				return v
+			default:
+				raw, rerr := os.ReadFile(m[2])
+				if rerr != nil && err == nil {
+					err = fmt.Errorf("%w: %s for %s", ErrSecret, rerr.Error(), path)
+				}
+				return strings.TrimRight(string(raw), "\r\n")
+			}
+		})
+		return out, err
+	}
+	for _, f := range fields {
+		switch {
+		case f.value.Kind() == reflect.String:
+			str, err := resolve(f.path, f.value.String())
+			if err != nil {
+				return nil, err
+			}
+			f.value.SetString(str)
+		case f.value.Kind() == reflect.Slice && f.value.Type().Elem().Kind() == reflect.String:
+			for i := range f.value.Len() {
+				str, err := resolve(f.path, f.value.Index(i).String())
+				if err != nil {
+					return nil, err
+				}
+				f.value.Index(i).SetString(str)
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// Redact return a copy of config with the secret fields replaced by REDACTED.
+func (c *Config) Redact() *Config {
+	cp := *c
+	redactValue(reflect.ValueOf(&cp).Elem())
+	return &cp
+}
+
+// String print the redacted config.
+func (c *Config) String() string {
+	return fmt.Sprintf("%+v", *(*plainConfig)(c.Redact()))
+}
+
+// GoString print the redacted config for %#v.
+func (c *Config) GoString() string {
+	return fmt.Sprintf("%#v", *(*plainConfig)(c.Redact()))
+}
+
+// redactValue redact the secret fields of addressable struct v, the pointers and slices are copied before changed.
+func redactValue(v reflect.Value) {
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		if sf.Tag.Get("secret") == "true" {
+			if fv.Kind() == reflect.String && fv.String() != "" {
+				fv.SetString(REDACTED)
+			}
+			continue
+		}
+		switch fv.Kind() {
+		case reflect.Struct:
+			redactValue(fv)
+		case reflect.Pointer:
+			if !fv.IsNil() && fv.Elem().Kind() == reflect.Struct {
+				cp := reflect.New(fv.Elem().Type())
+				cp.Elem().Set(fv.Elem())
+				redactValue(cp.Elem())
+				fv.Set(cp)
+			}
+		case reflect.Map:
+			if isNamedMap(fv.Type()) && fv.Len() > 0 {
+				cp := reflect.MakeMapWithSize(fv.Type(), fv.Len())
+				for _, k := range fv.MapKeys() {
+					ev := fv.MapIndex(k)
+					if !ev.IsNil() {
+						next := reflect.New(ev.Elem().Type())
+						next.Elem().Set(ev.Elem())
+						redactValue(next.Elem())
+						ev = next
+					}
+					cp.SetMapIndex(k, ev)
+				}
+				fv.Set(cp)
+			}
+		case reflect.Slice:
+			if fv.Type().Elem().Kind() == reflect.Struct && fv.Len() > 0 {
+				cp := reflect.MakeSlice(fv.Type(), fv.Len(), fv.Len())
+				reflect.Copy(cp, fv)
+				for j := range cp.Len() {
+					redactValue(cp.Index(j))
+				}
+				fv.Set(cp)
+			}
+		}
+	}
+}
+
+// applyConfig apply the config to the packages driven by it.
+func applyConfig(c *Config) {
+	if c == nil {
+		return
+	}
+	if c.ServerConfig.JwtSecret != "" {
+		util.SetJwtSecret([]byte(c.ServerConfig.JwtSecret))
+	} else if util.JwtSecretSet() {
+		// falling back to the built-in secret would accept tokens signed by anyone
+		clog.Warn("server.jwtSecret is removed from config, the previous secret is kept until restart")
+	}
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wendisx/puzzle/pkg/util"
+)
+
+func test_secret_key(t *testing.T) []byte {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	t.Setenv("PUZZLE_CONFIG_KEY", base64.StdEncoding.EncodeToString(key))
+	return key
+}
+
+// test env, file and encrypted references are resolved and redacted [passed]
+func Test_resolve_secrets(t *testing.T) {
+	key := test_secret_key(t)
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(secretFile, []byte("gh-token\n"), 0o600); err != nil {
+		t.Fatal(err.Error())
+	}
+	enc, err := EncryptValue(key, "jwt-secret")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Setenv("DB_PASSWORD", "p@ss")
+	t.Setenv("GH_USER", "octocat")
+	path := test_write_yaml(t, dir, "config.yaml", fmt.Sprintf(`server:
+  jwtSecret: %s
+database:
+  sql:
+    driver: mysql
+    dsn: root:${env:DB_PASSWORD}@/app
+github:
+  accessToken: ${file:%s}
+  userName: ${env:GH_USER}
+`, enc, secretFile))
+	c, pv, err := LoadLayered(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if c.GithubConfig.UserName != "octocat" || c.ServerConfig.JwtSecret != "jwt-secret" || c.DBConfig.SqlDBConfig.Dsn != "root:p@ss@/app" || c.GithubConfig.AccessToken != "gh-token" {
+		t.Fatalf("unexpected resolved config %+v", *(*plainConfig)(c))
+	}
+	// the jwt secret is applied
+	token, _ := util.GenToken(util.JwtCustomClaims{Name: "a"})
+	util.SetJwtSecret([]byte("jwt-secret"))
+	if _, err = util.ParseToken(token); err != nil {
+		t.Fatalf("expected token signed by config secret but got %v", err)
+	}
+	for _, p := range []string{"server.jwtSecret", "database.sql.dsn", "github.accessToken", "github.userName"} {
+		if e, _ := pv.Source(p); e.Value != REDACTED {
+			t.Fatalf("expected %s redacted but got %+v", p, e)
+		}
+	}
+	for _, dump := range []string{c.String(), fmt.Sprintf("%v", c), fmt.Sprintf("%#v", c), pv.String()} {
+		if strings.Contains(dump, "p@ss") || strings.Contains(dump, "gh-token") || strings.Contains(dump, "jwt-secret") {
+			t.Fatalf("secret leaked in %s", dump)
+		}
+	}
+	if r := c.Redact(); r.DBConfig.SqlDBConfig.Dsn != REDACTED || c.DBConfig.SqlDBConfig.Dsn != "root:p@ss@/app" {
+		t.Fatal("expected redacted copy only")
+	}
+}
+
+// test unresolved references fail the load [passed]
+func Test_resolve_secrets_error(t *testing.T) {
+	key := test_secret_key(t)
+	enc, _ := EncryptValue(key, "x")
+	dir := t.TempDir()
+	for _, value := range []string{"${env:PUZZLE_NOT_SET}", "${file:" + filepath.Join(dir, "none") + "}", enc[:len(enc)-4] + "AAA="} {
+		path := test_write_yaml(t, dir, "config.yaml", "github:\n  accessToken: \""+value+"\"\n")
+		if _, _, err := LoadLayered(path); !errors.Is(err, ErrSecret) {
+			t.Fatalf("expected ErrSecret for %s but got %v", value, err)
+		}
+	}
+	t.Setenv("PUZZLE_CONFIG_KEY", "")
+	if _, err := SecretKey(); !errors.Is(err, ErrSecret) {
+		t.Fatalf("expected ErrSecret without key but got %v", err)
+	}
+}
+
+// test the jwt secret kept when a reload removes it [passed]
+func Test_jwt_secret_removed(t *testing.T) {
+	applyConfig(&Config{ServerConfig: ServerConfig{JwtSecret: "kept-secret"}})
+	token, err := util.GenToken(util.JwtCustomClaims{Name: "a"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	applyConfig(&Config{})
+	if !util.JwtSecretSet() {
+		t.Fatal("expected the configured secret kept")
+	}
+	if _, err = util.ParseToken(token); err != nil {
+		t.Fatalf("expected token of the kept secret valid but got %v", err)
+	}
+}
@@ -1,22 +1,53 @@
 package config
 
+import (
+	"net"
+	"strconv"
+)
+
 type (
+	// ServerConfig drives the http.Server, the timeouts are in seconds.
 	ServerConfig struct {
-		Addr              string `yaml:"addr"`
+		Addr              string `yaml:"addr"` // listen address, take precedence over host and port
 		Host              string `yaml:"host"`
-		Port              int    `yaml:"port"`
+		Port              int    `yaml:"port" check:"min=0,max=65535"`
 		EnableOptions     bool   `yaml:"enableOptions"`
-		ReadTimeout       int    `yaml:"readTimeout"`
-		ReadHeaderTimeout int    `yaml:"readHeaderTimeout"`
-		WriteTimeout      int    `yaml:"writeTimeout"`
-		IdleTimeout       int    `yaml:"idleTimeout"`
-		MaxHeaderBytes    []int  `yaml:"maxHeaderBytes"`
+		ReadTimeout       int    `yaml:"readTimeout" check:"min=0"`
+		ReadHeaderTimeout int    `yaml:"readHeaderTimeout" check:"min=0"`
+		WriteTimeout      int    `yaml:"writeTimeout" check:"min=0"`
+		IdleTimeout       int    `yaml:"idleTimeout" check:"min=0"`
+		ShutdownTimeout   int    `yaml:"shutdownTimeout" check:"min=0"` // grace period to finish requests
+		MaxHeaderBytes    []int  `yaml:"maxHeaderBytes"`                // [n, shift] means n<<shift bytes
+		JwtSecret         string `yaml:"jwtSecret" secret:"true"`
 	}
 )
 
 func initServerConfig() ServerConfig {
 	return ServerConfig{
-		EnableOptions:  true,
-		MaxHeaderBytes: []int{1, 20}, // default -> 1Mib
+		Host:            "127.0.0.1",
+		Port:            3333,
+		EnableOptions:   true,
+		ShutdownTimeout: 5,
+		MaxHeaderBytes:  []int{1, 20}, // default -> 1Mib
+	}
+}
+
+// ListenAddr return Addr if set, or host:port.
+func (sc ServerConfig) ListenAddr() string {
+	if sc.Addr != "" {
+		return sc.Addr
+	}
+	return net.JoinHostPort(sc.Host, strconv.Itoa(sc.Port))
+}
+
+// HeaderBytes return the max header bytes, 0 for the default of http.Server.
+func (sc ServerConfig) HeaderBytes() int {
+	switch len(sc.MaxHeaderBytes) {
+	case 0:
+		return 0
+	case 1:
+		return sc.MaxHeaderBytes[0]
+	default:
+		return sc.MaxHeaderBytes[0] << sc.MaxHeaderBytes[1]
 	}
 }
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wendisx/puzzle/pkg/util"
+	"go.yaml.in/yaml/v3"
+)
+
+/*
+	config.validate -- [submodule]
+ValidateConfig checks the config when it is loaded, instead of crashing later:
+1. every field with the `check` tag, by the rules of util.Validator, like
+`check:"min=0,max=65535"`, nested sections and slices of structs included.
+2. the rules across fields of a section, like the dsn required by a sql driver and
+the activeRepo within the repos.
+Every problem is reported with the yaml path and where its value comes from, the
+file and line for file layers, the variable or flag for the others.
+*/
+
+var (
+	_config_validator = newConfigValidator()
+)
+
+type (
+	// ConfigError is one problem of config.
+	ConfigError struct {
+		Path    string // yaml path like server.port
+		Rule    string // failed rule like min=0
+		Value   any
+		Source  string // file, env or flag name, empty for default
+		Line    int    // line in file
+		Message string
+	}
+	// ConfigErrors are all problems of config, errors.Is(ErrConfigInvalid) holds.
+	ConfigErrors []ConfigError
+	// section with rules across fields, the paths are relative to the section.
+	crossChecker interface {
+		crossCheck() []ConfigError
+	}
+)
+
+func newConfigValidator() util.RuleChecker {
+	va := util.NewValidator(nil)
+	va.SetupDefaultRules()
+	return va
+}
+
+func (ce ConfigError) Error() string {
+	var where string
+	switch {
+	case ce.Line > 0:
+		where = ce.Source + ":" + strconv.Itoa(ce.Line) + ": "
+	case ce.Source != "":
+		where = ce.Source + ": "
+	}
+	msg := ce.Message
+	if msg == "" {
+		value := fmt.Sprint(ce.Value)
+		if str, ok := ce.Value.(string); ok {
+			value = strconv.Quote(str)
+		}
+		msg = fmt.Sprintf("value %s breaks rule(%s)", value, ce.Rule)
+	}
+	return where + ce.Path + ": " + msg
+}
+
+func (ces ConfigErrors) Error() string {
+	lines := make([]string, len(ces))
+	for i, ce := range ces {
+		lines[i] = ce.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (ces ConfigErrors) Is(target error) bool {
+	return target == ErrConfigInvalid
+}
+
+// ValidateConfig check c and return ConfigErrors with all problems, the sources are found in pv if not nil.
+func ValidateConfig(c *Config, pv *Provenance) error {
+	var errs ConfigErrors
+	checkStruct(reflect.ValueOf(c).Elem(), "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	if pv != nil {
+		for i := range errs {
+			errs[i].Source, errs[i].Line = pv.locate(errs[i].Path)
+		}
+	}
+	return errs
+}
+
+// ValidateFile load the config of path by layers and validate it, without storing it.
+func ValidateFile(path string, opts ...LoadOption) (*Config, error) {
+	c, _, err := newLayeredLoader(opts...).load(path)
+	return c, err
+}
+
+func checkStruct(v reflect.Value, prefix string, errs *ConfigErrors) {
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, inline := yamlName(sf)
+		if name == "-" {
+			continue
+		}
+		path := prefix + name
+		if inline {
+			path = strings.TrimSuffix(prefix, ".")
+		}
+		fv := v.Field(i)
+		// the optional values like *int are checked only if set
+		if rules := sf.Tag.Get("check"); rules != "" && !(fv.Kind() == reflect.Pointer && fv.IsNil()) {
+			rv := reflect.Indirect(fv)
+			value := rv.Interface()
+			if sf.Tag.Get("secret") == "true" && !rv.IsZero() {
+				value = REDACTED
+			}
+			for _, rule := range _config_validator.CheckRules(rules, rv.Interface()) {
+				*errs = append(*errs, ConfigError{Path: path, Rule: rule, Value: value})
+			}
+		}
+		checkValue(fv, path, errs)
+	}
+	if cc, ok := v.Interface().(crossChecker); ok && !promoted(t) {
+		for _, ce := range cc.crossCheck() {
+			ce.Path = prefix + ce.Path
+			*errs = append(*errs, ce)
+		}
+	}
+}
+
+// promoted tell whether crossCheck of t comes from an embedded section, which is checked on its own.
+func promoted(t reflect.Type) bool {
+	checker := reflect.TypeFor[crossChecker]()
+	for i := range t.NumField() {
+		if sf := t.Field(i); sf.Anonymous && sf.Type.Implements(checker) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkValue(v reflect.Value, path string, errs *ConfigErrors) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			checkValue(v.Elem(), path, errs)
+		}
+	case reflect.Struct:
+		if v.Type() != reflect.TypeFor[time.Time]() {
+			checkStruct(v, path+".", errs)
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			checkValue(v.Index(i), path+"["+strconv.Itoa(i)+"]", errs)
+		}
+	case reflect.Map:
+		if isNamedMap(v.Type()) {
+			for _, k := range sortedKeys(v) {
+				checkValue(v.MapIndex(k), path+"."+k.String(), errs)
+			}
+		}
+	}
+}
+
+// locate return where the value of path, or its nearest parent, comes from.
+func (pv *Provenance) locate(path string) (string, int) {
+	for path != "" {
+		if e, found := pv.entries[path]; found {
+			return e.Source, e.Line
+		}
+		if i := strings.LastIndexAny(path, ".["); i >= 0 {
+			path = path[:i]
+		} else {
+			path = ""
+		}
+	}
+	return "", 0
+}
+
+// fileProvenance return the provenance of values set by the yaml or json file.
+func fileProvenance(name string, raw []byte) *Provenance {
+	pv := &Provenance{entries: make(map[string]ProvenanceEntry)}
+	switch util.DetectFormat(name, raw) {
+	case util.FORMAT_YAML, util.FORMAT_JSON:
+	default:
+		return pv
+	}
+	var doc yaml.Node
+	if yaml.Unmarshal(raw, &doc) != nil || len(doc.Content) == 0 {
+		return pv
+	}
+	walkYaml(doc.Content[0], "", func(p string, n *yaml.Node) {
+		pv.set(p, LAYER_FILE, name, n.Line)
+	})
+	return pv
+}
+
+func (sc SqlDBConfig) crossCheck() []ConfigError {
+	// the unnamed driver can be only the default of named ones
+	if sc.Driver != "" && sc.Dsn == "" && len(sc.Named) == 0 {
+		return []ConfigError{{Path: "dsn", Rule: "required", Message: fmt.Sprintf("dsn is required by driver(%s)", sc.Driver)}}
+	}
+	return nil
+}
+
+func (gc GithubConfig) crossCheck() []ConfigError {
+	if gc.ActiveRepo != 0 && gc.ActiveRepo >= len(gc.Repos) {
+		return []ConfigError{{
+			Path:    "activeRepo",
+			Rule:    "range",
+			Value:   gc.ActiveRepo,
+			Message: fmt.Sprintf("activeRepo %d is out of range of %d repos", gc.ActiveRepo, len(gc.Repos)),
+		}}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// test every problem is reported with the file line [passed]
+func Test_validate_config(t *testing.T) {
+	path := test_write_yaml(t, t.TempDir(), "config.yaml", `server:
+  port: -5
+database:
+  sql:
+    driver: mysql
+github:
+  activeRepo: 3
+  repos:
+    - name: ""
+`)
+	_, _, err := LoadLayered(path)
+	var errs ConfigErrors
+	if !errors.Is(err, ErrConfigInvalid) || !errors.As(err, &errs) {
+		t.Fatalf("expected ConfigErrors but got %v", err)
+	}
+	want := map[string]int{"server.port": 2, "database.sql.dsn": 0, "github.activeRepo": 7, "github.repos[0].name": 9}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d problems but got\n%s", len(want), errs.Error())
+	}
+	for _, ce := range errs {
+		line, found := want[ce.Path]
+		if !found || ce.Line != line {
+			t.Fatalf("unexpected problem %+v", ce)
+		}
+	}
+	if !strings.Contains(err.Error(), filepath.Base(path)+":2: server.port: value -5 breaks rule(min=0)") {
+		t.Fatalf("unexpected report\n%s", err.Error())
+	}
+	// the source of env values
+	t.Setenv("PUZZLE_SERVER_PORT", "70000")
+	_, err = ValidateFile(test_write_yaml(t, t.TempDir(), "config.yaml", "server:\n  port: 80\n"))
+	if err == nil || err.Error() != "PUZZLE_SERVER_PORT: server.port: value 70000 breaks rule(max=65535)" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// test json schema carries the rules and defaults [passed]
+func Test_json_schema(t *testing.T) {
+	raw, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var schema jsonSchema
+	if err = json.Unmarshal(raw, &schema); err != nil {
+		t.Fatal(err.Error())
+	}
+	port := schema.Properties["server"].Properties["port"]
+	if port.Type != "integer" || *port.Minimum != 0 || *port.Maximum != 65535 {
+		t.Fatalf("unexpected port schema %+v", port)
+	}
+	idle := schema.Properties["database"].Properties["sql"].Properties["maxIdleConn"]
+	if idle.Default != float64(20) {
+		t.Fatalf("expected default 20 but got %v", idle.Default)
+	}
+	name := schema.Properties["github"].Properties["repos"].Items.Properties["name"]
+	if name.Type != "string" || *name.MinLength != 1 {
+		t.Fatalf("unexpected repo name schema %+v", name)
+	}
+}
@@ -0,0 +1,348 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+/*
+	database.batch -- [submodule]
+BatchInsert and Upsert build multi-row VALUES statements from the `db` tags of T
+instead of calling InsertWithName row by row. Rows are split into chunks so that a
+single statement never exceeds the bind parameter limit of the dialect. Every chunk
+reports its own result, and all chunks can run inside the caller's transaction or
+an atomic transaction opened by the helper.
+*/
+
+const (
+	DIALECT_MYSQL    = "mysql"
+	DIALECT_SQLITE   = "sqlite"
+	DIALECT_POSTGRES = "postgres"
+
+	// max bind parameters of a single statement
+	_max_params_mysql    = 65535
+	_max_params_sqlite   = 32766 // SQLITE_MAX_VARIABLE_NUMBER since 3.32.0
+	_max_params_postgres = 65535
+
+	_tag_db = "db"
+)
+
+var (
+	ErrEmptyColumns = errors.New("no db tagged columns in type")
+	ErrDialect      = errors.New("unsupported sql dialect")
+	ErrNilRow       = errors.New("nil row in batch")
+
+	// T -> columns and field index cache
+	_batch_columns sync.Map
+)
+
+type (
+	// Functional batch configuration.
+	BatchOption  func(bo *batchOptions)
+	batchOptions struct {
+		tx        *sqlx.Tx
+		atomic    bool
+		maxParams int
+		omit      []string
+		update    []string
+	}
+	// ChunkResult record the result of a single multi-row statement.
+	ChunkResult struct {
+		Index    int   // chunk index
+		Rows     int   // rows in chunk
+		Affected int64 // rows affected reported by driver
+		Err      error // error of chunk
+	}
+	// BatchResult aggregate all chunk results.
+	BatchResult struct {
+		Chunks   []ChunkResult
+		Rows     int
+		Affected int64
+	}
+	batchColumn struct {
+		name  string
+		index []int
+		typ   reflect.Type
+	}
+)
+
+// WithBatchTx run all chunks inside the caller's transaction, the first failed chunk stops the batch
+// and the caller decides to rollback.
+func WithBatchTx(tx *sqlx.Tx) BatchOption {
+	return func(bo *batchOptions) {
+		bo.tx = tx
+	}
+}
+
+// WithAtomic run all chunks inside a new transaction which is rolled back if any chunk fails.
+func WithAtomic() BatchOption {
+	return func(bo *batchOptions) {
+		bo.atomic = true
+	}
+}
+
+// WithMaxParams override the bind parameter limit of the dialect.
+func WithMaxParams(n int) BatchOption {
+	return func(bo *batchOptions) {
+		bo.maxParams = n
+	}
+}
+
+// WithOmit skip columns, like the auto increment primary key.
+func WithOmit(cols ...string) BatchOption {
+	return func(bo *batchOptions) {
+		bo.omit = append(bo.omit, cols...)
+	}
+}
+
+// WithUpdate restrict columns updated by Upsert on conflict.
+func WithUpdate(cols ...string) BatchOption {
+	return func(bo *batchOptions) {
+		bo.update = append(bo.update, cols...)
+	}
+}
+
+// Dialect return the sql dialect of the database driver.
+func Dialect(db *sqlx.DB) (string, error) {
+	return DriverDialect(db.DriverName())
+}
+
+// DriverDialect return the sql dialect of the driver name, such as the one of sqlx.Tx.
+func DriverDialect(driver string) (string, error) {
+	switch driver {
+	case "mysql":
+		return DIALECT_MYSQL, nil
+	case "sqlite3", "sqlite":
+		return DIALECT_SQLITE, nil
+	case "postgres", "pgx", "pq":
+		return DIALECT_POSTGRES, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrDialect, driver)
+}
+
+// BatchInsert insert rows into table by multi-row VALUES statements.
+// T should be a struct (or pointer to struct) with `db` tags, embedded structs and struct pointers
+// are expanded, the columns under a nil embedded pointer get zero values as sqlx binds them.
+// A nil row fails with ErrNilRow.
+// Without WithBatchTx or WithAtomic every chunk runs on its own and the failed chunks are reported.
+func BatchInsert[T any](ctx context.Context, db *sqlx.DB, table string, rows []T, opts ...BatchOption) (BatchResult, error) {
+	return execBatch(ctx, db, table, rows, nil, opts)
+}
+
+// Upsert insert rows into table and update them when the conflict columns already exist.
+// It uses ON DUPLICATE KEY UPDATE for mysql and ON CONFLICT for sqlite and postgres.
+// All non-conflict columns are updated unless WithUpdate is given.
+func Upsert[T any](ctx context.Context, db *sqlx.DB, table string, rows []T, conflict []string, opts ...BatchOption) (BatchResult, error) {
+	if len(conflict) == 0 {
+		return BatchResult{}, errors.New("upsert need at least one conflict column")
+	}
+	return execBatch(ctx, db, table, rows, conflict, opts)
+}
+
+func execBatch[T any](ctx context.Context, db *sqlx.DB, table string, rows []T, conflict []string, opts []BatchOption) (BatchResult, error) {
+	var res BatchResult
+	bo := batchOptions{}
+	for _, fn := range opts {
+		fn(&bo)
+	}
+	dialect, err := Dialect(db)
+	if err != nil {
+		clog.Error(err.Error())
+		return res, err
+	}
+	if len(rows) == 0 {
+		return res, nil
+	}
+	cols, err := batchColumns(reflect.TypeFor[T](), bo.omit)
+	if err != nil {
+		clog.Error(err.Error())
+		return res, err
+	}
+	for i := range rows {
+		if v := reflect.ValueOf(rows[i]); !v.IsValid() || (v.Kind() == reflect.Pointer && v.IsNil()) {
+			err = fmt.Errorf("%w: rows[%d]", ErrNilRow, i)
+			clog.Error(err.Error())
+			return res, err
+		}
+	}
+	if bo.maxParams <= 0 {
+		bo.maxParams = maxParams(dialect)
+	}
+	size := max(bo.maxParams/len(cols), 1)
+	suffix := upsertClause(dialect, cols, conflict, bo.update)
+	var exec sqlx.ExtContext = db
+	tx := bo.tx
+	if tx == nil && bo.atomic {
+		if tx, err = db.BeginTxx(ctx, nil); err != nil {
+			clog.Error(err.Error())
+			return res, err
+		}
+	}
+	if tx != nil {
+		exec = tx
+	}
+	var errs []error
+	for i := 0; i*size < len(rows); i += 1 {
+		chunk := rows[i*size : min((i+1)*size, len(rows))]
+		sqlStr, args := buildInsert(table, cols, chunk, suffix)
+		cr := ChunkResult{
+			Index: i,
+			Rows:  len(chunk),
+		}
+		result, err := exec.ExecContext(ctx, db.Rebind(sqlStr), args...)
+		if err == nil {
+			cr.Affected, err = result.RowsAffected()
+		}
+		if err != nil {
+			cr.Err = err
+			errs = append(errs, fmt.Errorf("chunk(%d): %w", i, err))
+			clog.Error(fmt.Sprintf("batch into table(%s) chunk(%d) fail for %s", palette.Red(table), i, err.Error()))
+		} else {
+			res.Rows += cr.Rows
+			res.Affected += cr.Affected
+		}
+		res.Chunks = append(res.Chunks, cr)
+		if err != nil && tx != nil {
+			break
+		}
+	}
+	err = errors.Join(errs...)
+	if bo.tx == nil && tx != nil {
+		if err != nil {
+			_ = tx.Rollback()
+			res.Rows, res.Affected = 0, 0
+		} else if err = tx.Commit(); err != nil {
+			clog.Error(err.Error())
+		}
+	}
+	return res, err
+}
+
+func maxParams(dialect string) int {
+	switch dialect {
+	case DIALECT_MYSQL:
+		return _max_params_mysql
+	case DIALECT_SQLITE:
+		return _max_params_sqlite
+	default:
+		return _max_params_postgres
+	}
+}
+
+func upsertClause(dialect string, cols []batchColumn, conflict, update []string) string {
+	if conflict == nil {
+		return ""
+	}
+	if len(update) == 0 {
+		for _, col := range cols {
+			if !slices.Contains(conflict, col.name) {
+				update = append(update, col.name)
+			}
+		}
+	}
+	sets := make([]string, 0, len(update))
+	switch dialect {
+	case DIALECT_MYSQL:
+		for _, col := range update {
+			sets = append(sets, fmt.Sprintf("%s = VALUES(%s)", col, col))
+		}
+		if len(sets) == 0 {
+			// nothing to update, keep the existing row
+			sets = append(sets, fmt.Sprintf("%s = %s", conflict[0], conflict[0]))
+		}
+		return " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+	default:
+		if len(update) == 0 {
+			return fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(conflict, ", "))
+		}
+		for _, col := range update {
+			sets = append(sets, fmt.Sprintf("%s = excluded.%s", col, col))
+		}
+		return fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(conflict, ", "), strings.Join(sets, ", "))
+	}
+}
+
+func buildInsert[T any](table string, cols []batchColumn, rows []T, suffix string) (string, []any) {
+	var sb strings.Builder
+	names := make([]string, len(cols))
+	for i := range cols {
+		names[i] = cols[i].name
+	}
+	group := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ") + ")"
+	fmt.Fprintf(&sb, "INSERT INTO %s (%s) VALUES ", table, strings.Join(names, ", "))
+	args := make([]any, 0, len(rows)*len(cols))
+	for i := range rows {
+		if i != 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(group)
+		v := reflect.Indirect(reflect.ValueOf(rows[i]))
+		for _, col := range cols {
+			fv, err := v.FieldByIndexErr(col.index)
+			if err != nil {
+				// under a nil embedded pointer, like sqlx binds it
+				fv = reflect.Zero(col.typ)
+			}
+			args = append(args, fv.Interface())
+		}
+	}
+	sb.WriteString(suffix)
+	return sb.String(), args
+}
+
+// batchColumns return the db tagged columns of t, fields without tag are skipped.
+func batchColumns(t reflect.Type, omit []string) ([]batchColumn, error) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %s", ErrEmptyColumns, t.String())
+	}
+	var all []batchColumn
+	if v, ok := _batch_columns.Load(t); ok {
+		all = v.([]batchColumn)
+	} else {
+		var walk func(t reflect.Type, prefix []int)
+		walk = func(t reflect.Type, prefix []int) {
+			for i := 0; i < t.NumField(); i += 1 {
+				f := t.Field(i)
+				index := append(slices.Clone(prefix), i)
+				name, tagged := f.Tag.Lookup(_tag_db)
+				ft := f.Type
+				if f.Anonymous && ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if f.Anonymous && ft.Kind() == reflect.Struct && !tagged {
+					walk(ft, index)
+					continue
+				}
+				name, _, _ = strings.Cut(name, ",")
+				if !f.IsExported() || name == "" || name == "-" {
+					continue
+				}
+				all = append(all, batchColumn{name: name, index: index, typ: f.Type})
+			}
+		}
+		walk(t, nil)
+		_batch_columns.Store(t, all)
+	}
+	cols := make([]batchColumn, 0, len(all))
+	for _, col := range all {
+		if !slices.Contains(omit, col.name) {
+			cols = append(cols, col)
+		}
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEmptyColumns, t.String())
+	}
+	return cols, nil
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/wendisx/puzzle/pkg/db/dbtest"
+)
+
+const (
+	_test_batch_schema = `
+	create table namespace (
+		id      integer primary key autoincrement,
+		name    text    not null unique,
+		visible integer not null default 0
+	);
+	`
+)
+
+type (
+	Namespace struct {
+		Id      uint64 `db:"id"`
+		Name    string `db:"name"`
+		Visible int    `db:"visible"`
+	}
+)
+
+func test_namespaces(n int, visible int) []Namespace {
+	list := make([]Namespace, 0, n)
+	for i := 0; i < n; i += 1 {
+		list = append(list, Namespace{Name: fmt.Sprintf("namespace_%d", i), Visible: visible})
+	}
+	return list
+}
+
+// test chunked batch insert [passed]
+func Test_batch_insert(t *testing.T) {
+	h := dbtest.New(t, dbtest.WithSQL(_test_batch_schema))
+	// 2 columns and 7 params per statement -> 3 rows per chunk
+	res, err := BatchInsert(t.Context(), h.DB, "namespace", test_namespaces(10, 1), WithOmit("id"), WithMaxParams(7))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(res.Chunks) != 4 || res.Rows != 10 || res.Affected != 10 {
+		t.Errorf("unexpected batch result %+v", res)
+	}
+	if n := h.Count("namespace"); n != 10 {
+		t.Errorf("expected 10 rows but got %d", n)
+	}
+}
+
+// test upsert with on conflict [passed]
+func Test_upsert(t *testing.T) {
+	h := dbtest.New(t, dbtest.WithSQL(_test_batch_schema))
+	if _, err := BatchInsert(t.Context(), h.DB, "namespace", test_namespaces(5, 0), WithOmit("id")); err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err := Upsert(t.Context(), h.DB, "namespace", test_namespaces(8, 1), []string{"name"}, WithOmit("id"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	visible, err := QueryWithPlace[int](t.Context(), h.DB, `select count(*) from namespace where visible = 1`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if n := h.Count("namespace"); n != 8 || visible != 8 {
+		t.Errorf("expected 8 visible rows but got %d/%d", visible, n)
+	}
+}
+
+// test failed chunk rolls back the atomic batch [passed]
+func Test_batch_atomic(t *testing.T) {
+	h := dbtest.New(t, dbtest.WithoutTx(), dbtest.WithSQL(_test_batch_schema))
+	rows := append(test_namespaces(4, 0), test_namespaces(1, 0)...) // duplicated name in last chunk
+	res, err := BatchInsert(t.Context(), h.DB, "namespace", rows, WithOmit("id"), WithMaxParams(4), WithAtomic())
+	if err == nil {
+		t.Fatal("expected unique constraint error")
+	}
+	if last := res.Chunks[len(res.Chunks)-1]; last.Err == nil {
+		t.Errorf("expected error in last chunk but got %+v", res.Chunks)
+	}
+	if n := h.Count("namespace"); n != 0 {
+		t.Errorf("expected rollback but got %d rows", n)
+	}
+}
+
+// test embedded struct pointers and nil rows [passed]
+func Test_batch_pointer_rows(t *testing.T) {
+	type visibility struct {
+		Visible int `db:"visible"`
+	}
+	type row struct {
+		Name string `db:"name"`
+		*visibility
+	}
+	h := dbtest.New(t, dbtest.WithSQL(_test_batch_schema))
+	rows := []*row{{Name: "a", visibility: &visibility{Visible: 1}}, {Name: "b"}}
+	if _, err := BatchInsert(t.Context(), h.DB, "namespace", rows); err != nil {
+		t.Fatal(err.Error())
+	}
+	visible, err := QueryWithPlace[int](t.Context(), h.DB, `select count(*) from namespace where visible = 1`)
+	if err != nil || visible != 1 || h.Count("namespace") != 2 {
+		t.Fatalf("unexpected rows %d, %v", visible, err)
+	}
+	_, err = BatchInsert(t.Context(), h.DB, "namespace", []*row{{Name: "c"}, nil})
+	if !errors.Is(err, ErrNilRow) {
+		t.Fatalf("expected nil row error but got %v", err)
+	}
+}
@@ -183,37 +183,26 @@ func DeleteWithName(ctx context.Context, db *sqlx.DB, sqlStr string, obj any) er
 // The database instance needs to be explicitly specified.
 func QueryWithName[R any](ctx context.Context, db *sqlx.DB, sqlStr string, obj any) (R, error) {
 	var dest R
-	rows, err := db.NamedQueryContext(ctx, sqlStr, obj)
-	if err == nil {
-		for rows.Next() {
-			err = rows.StructScan(&dest)
-		}
-	}
-	if err != nil {
-		clog.Error(err.Error())
+	var err error
+	for row, e := range QStreamWithName[R](ctx, db, sqlStr, obj) {
+		dest, err = row, e
 	}
 	return dest, err
 }
 
 // QListWithName return the list of specify generic type and error occurred during the execution of the select SQL with named parameters.
 // R should have the largest set of all fields that need to be retrieved and not be a pointer type.
+// The rows scanned before the first error are returned with the error.
 // The database instance needs to be explicitly specified.
 func QListWithName[R any](ctx context.Context, db *sqlx.DB, sqlStr string, obj any) ([]R, error) {
 	dest := make([]R, 0)
-	rows, err := db.NamedQueryContext(ctx, sqlStr, obj)
-	var row R
-	for rows.Next() {
-		err = rows.StructScan(&row)
+	for row, err := range QStreamWithName[R](ctx, db, sqlStr, obj) {
 		if err != nil {
-			clog.Error(err.Error())
-			continue
+			return dest, err
 		}
 		dest = append(dest, row)
 	}
-	if err != nil {
-		clog.Error(err.Error())
-	}
-	return dest, err
+	return dest, nil
 }
 
 // QPageWithName return the page of specify generic type and error occurred during the execution of the select SQL with named parameters.
@@ -0,0 +1,286 @@
+// Package dbtest provides a hermetic sqlite harness for tests of pkg/db users.
+//
+// Every call to New opens a private in-memory sqlite database, applies the
+// migrations and loads the yaml fixtures, so service tests no longer need the
+// mysql container from ci/docker-compose.yaml.
+//
+//	func Test_user_service(t *testing.T) {
+//		h := dbtest.New(t,
+//			dbtest.WithMigrations("testdata/schema.sql"),
+//			dbtest.WithFixtures("testdata/users.yaml"),
+//		)
+//		list, err := database.QListWithPlace[User](t.Context(), h.DB, `select * from user_basic`)
+//		...
+//	}
+//
+// The harness pins the database to a single connection and wraps the whole test
+// in a transaction which is rolled back on cleanup. Code that begins its own
+// transaction should use WithoutTx, the database is still dropped after the test.
+//
+// A fixture file maps table names to rows, tables are filled in file order:
+//
+//	user_basic:
+//	  - id: 1
+//	    user_name: puzzle
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"go.yaml.in/yaml/v3"
+)
+
+const (
+	_driver_sqlite = "sqlite3"
+	// Every harness has its own named memory database shared by its connections,
+	// the plain `file::memory:?cache=shared` would be shared by the whole process.
+	_memory_dsn = "file:%s?mode=memory&cache=shared"
+
+	_suffix_sql  = ".sql"
+	_suffix_yaml = ".yaml"
+	_suffix_yml  = ".yml"
+)
+
+var (
+	_harness_seq atomic.Uint64
+)
+
+type (
+	// Functional harness configuration.
+	Option func(h *Harness)
+	// Harness hold the database of a single test.
+	Harness struct {
+		DB         *sqlx.DB
+		tb         testing.TB
+		keep       *sql.Conn // keep the memory database alive
+		name       string
+		wrap       bool
+		migrations []string
+		statements []string
+		fixtures   []string
+	}
+)
+
+// WithMigrations apply sql files before the test, a directory applies all
+// *.sql files in lexical order.
+func WithMigrations(paths ...string) Option {
+	return func(h *Harness) {
+		h.migrations = append(h.migrations, paths...)
+	}
+}
+
+// WithSQL apply inline sql statements after the migration files.
+func WithSQL(stmts ...string) Option {
+	return func(h *Harness) {
+		h.statements = append(h.statements, stmts...)
+	}
+}
+
+// WithFixtures load yaml fixtures into tables, a directory loads all yaml
+// files in lexical order.
+func WithFixtures(paths ...string) Option {
+	return func(h *Harness) {
+		h.fixtures = append(h.fixtures, paths...)
+	}
+}
+
+// WithoutTx disable the transaction around the test.
+func WithoutTx() Option {
+	return func(h *Harness) {
+		h.wrap = false
+	}
+}
+
+// New return a harness with a fresh in-memory sqlite database for tb.
+// Any failure during setup stops the test immediately.
+func New(tb testing.TB, opts ...Option) *Harness {
+	tb.Helper()
+	h := &Harness{
+		tb:   tb,
+		wrap: true,
+	}
+	for _, fn := range opts {
+		fn(h)
+	}
+	h.name = fmt.Sprintf("dbtest_%d_%s", _harness_seq.Add(1), sanitize(tb.Name()))
+	db, err := sqlx.Open(_driver_sqlite, fmt.Sprintf(_memory_dsn, h.name))
+	if err != nil {
+		tb.Fatalf("open sqlite harness(%s) fail for %s", h.name, err.Error())
+	}
+	h.DB = db
+	tb.Cleanup(h.close)
+	if h.wrap {
+		// all statements share the connection which holds the transaction.
+		db.SetMaxOpenConns(1)
+	} else if h.keep, err = db.Conn(context.Background()); err != nil {
+		tb.Fatalf("connect sqlite harness(%s) fail for %s", h.name, err.Error())
+	}
+	for _, path := range h.migrations {
+		h.Migrate(path)
+	}
+	for _, stmt := range h.statements {
+		h.Exec(stmt)
+	}
+	if h.wrap {
+		h.Exec("BEGIN")
+	}
+	for _, path := range h.fixtures {
+		h.Load(path)
+	}
+	return h
+}
+
+// Name return the memory database name of harness.
+func (h *Harness) Name() string {
+	return h.name
+}
+
+// Exec execute sql and stop the test if it fails.
+func (h *Harness) Exec(sqlStr string, args ...any) {
+	h.tb.Helper()
+	if _, err := h.DB.Exec(sqlStr, args...); err != nil {
+		h.tb.Fatalf("exec sql fail for %s:\n%s", err.Error(), sqlStr)
+	}
+}
+
+// Migrate apply a sql file or all sql files in a directory.
+func (h *Harness) Migrate(path string) {
+	h.tb.Helper()
+	for _, file := range h.files(path, _suffix_sql) {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			h.tb.Fatalf("read migration(%s) fail for %s", file, err.Error())
+		}
+		if _, err = h.DB.Exec(string(content)); err != nil {
+			h.tb.Fatalf("apply migration(%s) fail for %s", file, err.Error())
+		}
+	}
+}
+
+// Load insert the rows of a yaml fixture file or all fixture files in a directory.
+func (h *Harness) Load(path string) {
+	h.tb.Helper()
+	for _, file := range h.files(path, _suffix_yaml, _suffix_yml) {
+		if err := h.loadFixture(file); err != nil {
+			h.tb.Fatalf("load fixture(%s) fail for %s", file, err.Error())
+		}
+	}
+}
+
+// Count return the number of rows in table.
+func (h *Harness) Count(table string) int {
+	h.tb.Helper()
+	var n int
+	if err := h.DB.Get(&n, "SELECT count(*) FROM "+table); err != nil {
+		h.tb.Fatalf("count table(%s) fail for %s", table, err.Error())
+	}
+	return n
+}
+
+func (h *Harness) files(path string, suffixes ...string) []string {
+	h.tb.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		h.tb.Fatalf("stat %s fail for %s", path, err.Error())
+	}
+	if !info.IsDir() {
+		return []string{path}
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		h.tb.Fatalf("read dir %s fail for %s", path, err.Error())
+	}
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !slices.Contains(suffixes, strings.ToLower(filepath.Ext(entry.Name()))) {
+			continue
+		}
+		files = append(files, filepath.Join(path, entry.Name()))
+	}
+	slices.Sort(files)
+	return files
+}
+
+func (h *Harness) loadFixture(file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err = yaml.Unmarshal(content, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: fixture should map table to rows", root.Line)
+	}
+	// keep the table order of file for foreign keys.
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		table := root.Content[i].Value
+		var rows []map[string]any
+		if err = root.Content[i+1].Decode(&rows); err != nil {
+			return fmt.Errorf("table %s: %s", table, err.Error())
+		}
+		for _, row := range rows {
+			if err = h.insert(table, row); err != nil {
+				return fmt.Errorf("table %s: %s", table, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func (h *Harness) insert(table string, row map[string]any) error {
+	cols := make([]string, 0, len(row))
+	for col := range row {
+		cols = append(cols, col)
+	}
+	slices.Sort(cols)
+	args := make([]any, len(cols))
+	for i := range cols {
+		args[i] = row[cols[i]]
+	}
+	sqlStr := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		table,
+		strings.Join(cols, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "),
+	)
+	_, err := h.DB.Exec(sqlStr, args...)
+	return err
+}
+
+func (h *Harness) close() {
+	if h.wrap {
+		// rollback fails only if the test already ended the transaction.
+		_, _ = h.DB.Exec("ROLLBACK")
+	}
+	if h.keep != nil {
+		_ = h.keep.Close()
+	}
+	if err := h.DB.Close(); err != nil {
+		h.tb.Errorf("close sqlite harness(%s) fail for %s", h.name, err.Error())
+	}
+}
+
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,68 @@
+package dbtest
+
+import (
+	"testing"
+
+	database "github.com/wendisx/puzzle/pkg/db"
+)
+
+type (
+	UserBasic struct {
+		Id           uint64 `db:"id"`
+		UserName     string `db:"user_name"`
+		UserPassword string `db:"user_password"`
+		Deleted      bool   `db:"deleted"`
+	}
+)
+
+// test migrations and fixtures with pkg/db helpers [passed]
+func Test_harness(t *testing.T) {
+	h := New(t,
+		WithMigrations("testdata"),
+		WithFixtures("testdata/users.yaml"),
+	)
+	list, err := database.QListWithPlace[UserBasic](t.Context(), h.DB, `select id, user_name, user_password, deleted from user_basic where deleted = 0`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(list) != 1 || list[0].UserName != "puzzle" {
+		t.Errorf("unexpected users %+v", list)
+	}
+	if n := h.Count("user_detail"); n != 1 {
+		t.Errorf("expected 1 user detail but got %d", n)
+	}
+}
+
+// test every harness is isolated from the others [passed]
+func Test_harness_isolation(t *testing.T) {
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			h := New(t, WithMigrations("testdata/schema.sql"))
+			if n := h.Count("user_basic"); n != 0 {
+				t.Fatalf("expected empty table but got %d rows", n)
+			}
+			h.Load("testdata/users.yaml")
+			if n := h.Count("user_basic"); n != 2 {
+				t.Errorf("expected 2 users but got %d", n)
+			}
+		})
+	}
+}
+
+// test code owning its transaction without wrapping [passed]
+func Test_harness_without_tx(t *testing.T) {
+	h := New(t, WithoutTx(), WithMigrations("testdata/schema.sql"))
+	tx, err := database.ToTx(h.DB)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = tx.Exec(`insert into user_basic(user_name, user_password) values ('tx', 'tx')`); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if n := h.Count("user_basic"); n != 1 {
+		t.Errorf("expected 1 user but got %d", n)
+	}
+}
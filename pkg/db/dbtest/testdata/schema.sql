@@ -0,0 +1,12 @@
+create table user_basic (
+    id            integer primary key autoincrement,
+    user_name     text    not null unique,
+    user_password text    not null,
+    deleted       integer not null default 0
+);
+
+create table user_detail (
+    user_id  integer primary key references user_basic(id),
+    nickname text not null,
+    email    text not null default ''
+);
@@ -0,0 +1,264 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+/*
+	database.health -- [submodule]
+Every init function registers a pinger for the client it creates, so the server can
+answer whether its dependencies are still alive after startup. A check owns its own
+timeout and criticality: a failing critical check turns readiness down, a failing
+non-critical check only degrades it. Results are cached for a short time to keep
+probes from hammering the databases.
+*/
+
+const (
+	HEALTH_STATUS_UP       = "up"
+	HEALTH_STATUS_DOWN     = "down"
+	HEALTH_STATUS_DEGRADED = "degraded"
+
+	_default_health_timeout = 2 * time.Second
+	_default_health_cache   = 5 * time.Second
+
+	_health_mysql  = "mysql"
+	_health_sqlite = "sqlite"
+	_health_redis  = "redis"
+	_health_mongo  = "mongo"
+)
+
+var (
+	_default_health = NewHealthRegistry()
+
+	ErrHealthTimeout = errors.New("health check timeout")
+)
+
+type (
+	// Pinger report whether the dependency is reachable.
+	Pinger func(ctx context.Context) error
+	// Functional health check configuration.
+	HealthOption func(hc *HealthCheck)
+	// HealthCheck describe how to probe a single dependency.
+	HealthCheck struct {
+		Name     string        // unique name in registry
+		Timeout  time.Duration // timeout for a single ping
+		Critical bool          // whether failure makes the service not ready
+		CacheTTL time.Duration // how long the last result is reused
+		ping     Pinger
+		mu       sync.Mutex
+		last     HealthResult
+	}
+	// HealthResult is the outcome of a single check.
+	HealthResult struct {
+		Name      string    `json:"name"`
+		Status    string    `json:"status"`
+		Critical  bool      `json:"critical"`
+		Latency   string    `json:"latency"`
+		Error     string    `json:"error,omitempty"`
+		CheckedAt time.Time `json:"checked_at"`
+		Cached    bool      `json:"cached"`
+	}
+	// HealthReport aggregate all check results.
+	HealthReport struct {
+		Status string         `json:"status"`
+		Checks []HealthResult `json:"checks"`
+	}
+	// HealthRegistry hold all registered health checks.
+	HealthRegistry struct {
+		mu     sync.RWMutex
+		order  []string
+		checks map[string]*HealthCheck
+	}
+)
+
+func WithHealthTimeout(timeout time.Duration) HealthOption {
+	return func(hc *HealthCheck) {
+		hc.Timeout = timeout
+	}
+}
+
+func WithHealthCritical(critical bool) HealthOption {
+	return func(hc *HealthCheck) {
+		hc.Critical = critical
+	}
+}
+
+func WithHealthCacheTTL(ttl time.Duration) HealthOption {
+	return func(hc *HealthCheck) {
+		hc.CacheTTL = ttl
+	}
+}
+
+// NewHealthRegistry return an empty health registry.
+func NewHealthRegistry() *HealthRegistry {
+	return &HealthRegistry{
+		checks: make(map[string]*HealthCheck),
+	}
+}
+
+// DefaultHealth return the registry used by all init functions.
+func DefaultHealth() *HealthRegistry {
+	return _default_health
+}
+
+// Register put a pinger into registry, a check with the same name will be replaced.
+func (r *HealthRegistry) Register(name string, ping Pinger, opts ...HealthOption) {
+	if ping == nil {
+		clog.Error(fmt.Sprintf("register health check(%s) with nil pinger", palette.Red(name)))
+		return
+	}
+	hc := &HealthCheck{
+		Name:     name,
+		Timeout:  _default_health_timeout,
+		Critical: true,
+		CacheTTL: _default_health_cache,
+		ping:     ping,
+	}
+	for _, fn := range opts {
+		fn(hc)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, found := r.checks[name]; found {
+		clog.Warn(fmt.Sprintf("replace health check(%s)", palette.SkyBlue(name)))
+	} else {
+		r.order = append(r.order, name)
+	}
+	r.checks[name] = hc
+	clog.Info(fmt.Sprintf("register health check(%s)", palette.SkyBlue(name)))
+}
+
+// Setup update options of a registered check and report whether it exists.
+func (r *HealthRegistry) Setup(name string, opts ...HealthOption) bool {
+	r.mu.RLock()
+	hc, found := r.checks[name]
+	r.mu.RUnlock()
+	if !found {
+		return false
+	}
+	hc.mu.Lock()
+	for _, fn := range opts {
+		fn(hc)
+	}
+	hc.last = HealthResult{}
+	hc.mu.Unlock()
+	return true
+}
+
+// Unregister remove the check with specific name.
+func (r *HealthRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, found := r.checks[name]; !found {
+		return
+	}
+	delete(r.checks, name)
+	for i := range r.order {
+		if r.order[i] == name {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Names return all check names in registration order.
+func (r *HealthRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, len(r.order))
+	copy(names, r.order)
+	return names
+}
+
+// Check run all checks concurrently and aggregate the results.
+func (r *HealthRegistry) Check(ctx context.Context) HealthReport {
+	r.mu.RLock()
+	checks := make([]*HealthCheck, 0, len(r.order))
+	for _, name := range r.order {
+		checks = append(checks, r.checks[name])
+	}
+	r.mu.RUnlock()
+	report := HealthReport{
+		Status: HEALTH_STATUS_UP,
+		Checks: make([]HealthResult, len(checks)),
+	}
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Checks[i] = checks[i].run(ctx)
+		}(i)
+	}
+	wg.Wait()
+	for _, res := range report.Checks {
+		if res.Status == HEALTH_STATUS_UP {
+			continue
+		}
+		if res.Critical {
+			report.Status = HEALTH_STATUS_DOWN
+			break
+		}
+		report.Status = HEALTH_STATUS_DEGRADED
+	}
+	return report
+}
+
+func (hc *HealthCheck) run(ctx context.Context) HealthResult {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if !hc.last.CheckedAt.IsZero() && time.Since(hc.last.CheckedAt) < hc.CacheTTL {
+		res := hc.last
+		res.Cached = true
+		return res
+	}
+	ctx, cancle := context.WithTimeout(ctx, hc.Timeout)
+	defer cancle()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- hc.ping(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ErrHealthTimeout
+	}
+	res := HealthResult{
+		Name:      hc.Name,
+		Status:    HEALTH_STATUS_UP,
+		Critical:  hc.Critical,
+		Latency:   time.Since(start).String(),
+		CheckedAt: start,
+	}
+	if err != nil {
+		res.Status = HEALTH_STATUS_DOWN
+		res.Error = err.Error()
+		clog.Warn(fmt.Sprintf("health check(%s) fail for %s", palette.Red(hc.Name), err.Error()))
+	}
+	hc.last = res
+	return res
+}
+
+// RegisterHealth put a pinger into the default registry.
+func RegisterHealth(name string, ping Pinger, opts ...HealthOption) {
+	_default_health.Register(name, ping, opts...)
+}
+
+// UnregisterHealth remove a check from the default registry.
+func UnregisterHealth(name string) {
+	_default_health.Unregister(name)
+}
+
+// CheckHealth run all checks in the default registry.
+func CheckHealth(ctx context.Context) HealthReport {
+	return _default_health.Check(ctx)
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// test aggregated status from critical and non-critical checks [passed]
+func Test_health_registry(t *testing.T) {
+	r := NewHealthRegistry()
+	r.Register("up", func(ctx context.Context) error { return nil })
+	report := r.Check(t.Context())
+	if report.Status != HEALTH_STATUS_UP {
+		t.Errorf("expected status %s but got %s", HEALTH_STATUS_UP, report.Status)
+	}
+	r.Register("cache", func(ctx context.Context) error { return errors.New("refused") }, WithHealthCritical(false))
+	report = r.Check(t.Context())
+	if report.Status != HEALTH_STATUS_DEGRADED {
+		t.Errorf("expected status %s but got %s", HEALTH_STATUS_DEGRADED, report.Status)
+	}
+	r.Setup("cache", WithHealthCritical(true))
+	report = r.Check(t.Context())
+	if report.Status != HEALTH_STATUS_DOWN {
+		t.Errorf("expected status %s but got %s", HEALTH_STATUS_DOWN, report.Status)
+	}
+	r.Unregister("cache")
+	if names := r.Names(); len(names) != 1 || names[0] != "up" {
+		t.Errorf("unexpected check names %v", names)
+	}
+}
+
+// test timeout and cached result [passed]
+func Test_health_timeout_cache(t *testing.T) {
+	r := NewHealthRegistry()
+	var calls atomic.Int32
+	r.Register("slow", func(ctx context.Context) error {
+		calls.Add(1)
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithHealthTimeout(10*time.Millisecond), WithHealthCacheTTL(time.Minute))
+	report := r.Check(context.Background())
+	if report.Status != HEALTH_STATUS_DOWN || report.Checks[0].Error == "" {
+		t.Errorf("expected timeout check down but got %+v", report)
+	}
+	report = r.Check(context.Background())
+	if !report.Checks[0].Cached || calls.Load() != 1 {
+		t.Errorf("expected cached result but got %+v with %d calls", report.Checks[0], calls.Load())
+	}
+}
@@ -28,6 +28,9 @@ func InitMongo(dsn string) MongoDB {
 	if err != nil {
 		clog.Panic(err.Error())
 	}
+	RegisterHealth(_health_mongo, func(ctx context.Context) error {
+		return client.Ping(ctx, nil)
+	})
 	return MongoDB{
 		client: client,
 	}
@@ -36,6 +39,7 @@ func InitMongo(dsn string) MongoDB {
 // In most scenarios, the client needs to be closed in defer,
 // which may be related to the location where initialization is called.
 func (db *MongoDB) Close() {
+	UnregisterHealth(_health_mongo)
 	if err := db.client.Disconnect(context.TODO()); err != nil {
 		clog.Panic(err.Error())
 	}
@@ -29,6 +29,7 @@ func InitMysql(dsn string) MysqlDB {
 	} else {
 		clog.Info("Mysql Database initialization successful.")
 	}
+	RegisterHealth(_health_mysql, db.PingContext)
 	return db
 }
 
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/redis/go-redis/v9"
@@ -18,20 +19,22 @@ func InitRedisDB(ro *redis.Options) *redis.Client {
 	}
 	rc := redis.NewClient(ro)
 	clog.Info("init redis database")
+	RegisterHealth(_health_redis, func(ctx context.Context) error {
+		return rc.Ping(ctx).Err()
+	})
 	return rc
 }
 
 func GetRedisDB() *redis.Client {
-	rdb := InitRedisDB(nil)
-	if config.HasDict(config.DICTKEY_CLIENT) {
-		clientDict := config.GetDict(config.DICTKEY_CLIENT)
-		if clientDict.Has(config.DATAKEY_DB_REDIS) {
-			rdb = clientDict.Find(config.DATAKEY_DB_REDIS).Value().(*redis.Client)
-		} else {
-			clientDict.Record(config.DATAKEY_DB_REDIS, rdb)
-		}
-	} else {
+	if !config.HasDict(config.DICTKEY_CLIENT) {
 		clog.Warn(fmt.Sprintf("not exists dict_key(%s) to store data_key(%s)", palette.Red(config.DICTKEY_CLIENT), palette.Red(config.DATAKEY_DB_REDIS)))
+		return InitRedisDB(nil)
+	}
+	clientDict := config.GetDict(config.DICTKEY_CLIENT)
+	if clientDict.Has(config.DATAKEY_DB_REDIS) {
+		return clientDict.Find(config.DATAKEY_DB_REDIS).Value().(*redis.Client)
 	}
+	rdb := InitRedisDB(nil)
+	clientDict.Record(config.DATAKEY_DB_REDIS, rdb)
 	return rdb
 }
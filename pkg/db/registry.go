@@ -0,0 +1,227 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/config"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+/*
+	database.registry -- [submodule]
+Named databases of config, like database.sql.main and database.redis.cache, are opened
+lazily on the first Get and reused after, each one registers its health check as
+sql:<name> or redis:<name>. The unnamed database is config.DB_DEFAULT. CloseAll closes
+all opened ones, the web server calls it on shutdown.
+*/
+
+const (
+	_health_sql_prefix   = "sql:"
+	_health_redis_prefix = "redis:"
+)
+
+var (
+	_default_sql_registry   = NewDBRegistry(openSqlDB, closeSqlDB)
+	_default_redis_registry = NewDBRegistry(openRedisDB, closeRedisDB)
+
+	ErrDBNotConfigured = errors.New("database not configured")
+)
+
+type (
+	// DBRegistry open the database of T by name lazily and close them all at last.
+	DBRegistry[T any] struct {
+		mu      sync.Mutex
+		open    func(name string) (T, error)
+		close   func(name string, db T) error
+		entries map[string]*dbEntry[T]
+		order   []string
+		gen     uint64 // increased by Close, the databases opened for an older one are closed
+	}
+	dbEntry[T any] struct {
+		mu     sync.Mutex
+		db     T
+		opened bool
+		closed bool // closed by Close, Get takes the entry of the new generation
+	}
+)
+
+// NewDBRegistry return an empty registry opening the databases by open and closing them by close.
+func NewDBRegistry[T any](open func(name string) (T, error), close func(name string, db T) error) *DBRegistry[T] {
+	return &DBRegistry[T]{
+		open:    open,
+		close:   close,
+		entries: make(map[string]*dbEntry[T]),
+	}
+}
+
+// Get return the database of name, open it if not opened, a failed open is tried again next time.
+func (r *DBRegistry[T]) Get(name string) (T, error) {
+	if name == "" {
+		name = config.DB_DEFAULT
+	}
+	for {
+		r.mu.Lock()
+		gen := r.gen
+		e, found := r.entries[name]
+		if !found {
+			e = &dbEntry[T]{}
+			r.entries[name] = e
+		}
+		r.mu.Unlock()
+		db, current, err := r.openEntry(name, e, gen)
+		if current {
+			return db, err
+		}
+		// closed meanwhile
+	}
+}
+
+// openEntry return the database of e, false if the registry is closed after gen.
+func (r *DBRegistry[T]) openEntry(name string, e *dbEntry[T], gen uint64) (T, bool, error) {
+	// open out of the registry lock, the others are not blocked
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return e.db, false, nil
+	}
+	if e.opened {
+		return e.db, true, nil
+	}
+	db, err := r.open(name)
+	if err != nil {
+		return db, true, err
+	}
+	// recorded with the generation check, so Close either sees it or it is closed here
+	r.mu.Lock()
+	current := r.gen == gen
+	if current {
+		e.db, e.opened = db, true
+		r.order = append(r.order, name)
+	}
+	r.mu.Unlock()
+	if !current {
+		if err = r.close(name, db); err != nil {
+			clog.Warn(fmt.Sprintf("close database(%s) opened while closing fail for %s", palette.Red(name), err.Error()))
+		}
+	}
+	return db, current, nil
+}
+
+// Names return the names of opened databases in order.
+func (r *DBRegistry[T]) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, len(r.order))
+	copy(names, r.order)
+	return names
+}
+
+// Close close all opened databases in reverse order, they are opened again by next Get.
+func (r *DBRegistry[T]) Close() error {
+	r.mu.Lock()
+	order, entries := r.order, r.entries
+	r.order, r.entries = nil, make(map[string]*dbEntry[T])
+	r.gen++
+	r.mu.Unlock()
+	var errs []error
+	for i := len(order) - 1; i >= 0; i-- {
+		e := entries[order[i]]
+		e.mu.Lock()
+		if err := r.close(order[i], e.db); err != nil {
+			errs = append(errs, fmt.Errorf("close database(%s) fail for %w", order[i], err))
+		}
+		e.closed = true
+		e.mu.Unlock()
+		clog.Info(fmt.Sprintf("close database(%s)", palette.SkyBlue(order[i])))
+	}
+	return errors.Join(errs...)
+}
+
+// SqlDB return the sql database of name in config, opened lazily.
+func SqlDB(name string) (*sqlx.DB, error) {
+	return _default_sql_registry.Get(name)
+}
+
+// RedisDB return the redis of name in config, opened lazily.
+func RedisDB(name string) (*redis.Client, error) {
+	return _default_redis_registry.Get(name)
+}
+
+// CloseAll close all databases opened by SqlDB and RedisDB.
+func CloseAll() error {
+	return errors.Join(_default_redis_registry.Close(), _default_sql_registry.Close())
+}
+
+func dbConfig() (*config.DBConfig, error) {
+	c, err := config.Lookup[*config.Config](config.DICTKEY_CONFIG, config.DATAKEY_CONFIG)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDBNotConfigured, err.Error())
+	}
+	return &c.DBConfig, nil
+}
+
+func openSqlDB(name string) (*sqlx.DB, error) {
+	dc, err := dbConfig()
+	if err != nil {
+		return nil, err
+	}
+	sc, found := dc.SqlDB(name)
+	if !found {
+		return nil, fmt.Errorf("%w: database.sql.%s", ErrDBNotConfigured, name)
+	}
+	driver := sc.Driver
+	if driver == "" {
+		driver = _driver_mysql
+	}
+	ctx, cancle := context.WithTimeout(context.Background(), _conn_sql_timeout)
+	defer cancle()
+	db, err := sqlx.ConnectContext(ctx, driver, sc.Dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database.sql.%s fail for %w", name, err)
+	}
+	db.SetMaxIdleConns(sc.MaxIdleConn)
+	db.SetMaxOpenConns(sc.MaxOpenConn)
+	db.SetConnMaxIdleTime(time.Duration(sc.MaxConnIdleTime) * time.Second)
+	db.SetConnMaxLifetime(time.Duration(sc.MaxConnLifeTime) * time.Second)
+	RegisterHealth(_health_sql_prefix+name, db.PingContext)
+	clog.Info(fmt.Sprintf("open database.sql.%s with driver(%s)", palette.SkyBlue(name), palette.SkyBlue(driver)))
+	return db, nil
+}
+
+func closeSqlDB(name string, db *sqlx.DB) error {
+	UnregisterHealth(_health_sql_prefix + name)
+	return db.Close()
+}
+
+func openRedisDB(name string) (*redis.Client, error) {
+	dc, err := dbConfig()
+	if err != nil {
+		return nil, err
+	}
+	rc, found := dc.RedisDB(name)
+	if !found {
+		return nil, fmt.Errorf("%w: database.redis.%s", ErrDBNotConfigured, name)
+	}
+	ro, err := redis.ParseURL(rc.Dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database.redis.%s fail for %w", name, err)
+	}
+	client := redis.NewClient(ro)
+	RegisterHealth(_health_redis_prefix+name, func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	})
+	clog.Info(fmt.Sprintf("open database.redis.%s", palette.SkyBlue(name)))
+	return client, nil
+}
+
+func closeRedisDB(name string, client *redis.Client) error {
+	UnregisterHealth(_health_redis_prefix + name)
+	return client.Close()
+}
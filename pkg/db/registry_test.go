@@ -0,0 +1,148 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/wendisx/puzzle/pkg/config"
+)
+
+// test lazy open, reuse, retry and close in reverse order [passed]
+func Test_db_registry(t *testing.T) {
+	opens := make(map[string]int)
+	var closed []string
+	r := NewDBRegistry(func(name string) (*string, error) {
+		opens[name]++
+		if name == "bad" && opens[name] == 1 {
+			return nil, errors.New("refused")
+		}
+		db := "db-" + name
+		return &db, nil
+	}, func(name string, db *string) error {
+		closed = append(closed, *db)
+		return nil
+	})
+	if names := r.Names(); len(names) != 0 {
+		t.Fatalf("expected nothing opened but got %v", names)
+	}
+	main, err := r.Get("main")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if again, _ := r.Get("main"); again != main || opens["main"] != 1 {
+		t.Fatalf("expected main opened once but got %d", opens["main"])
+	}
+	if _, err = r.Get("bad"); err == nil {
+		t.Fatal("expected open error")
+	}
+	if _, err = r.Get(""); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = r.Get("bad"); err != nil || opens["bad"] != 2 {
+		t.Fatalf("expected bad opened again but got %v", err)
+	}
+	if names := r.Names(); !slices.Equal(names, []string{"main", config.DB_DEFAULT, "bad"}) {
+		t.Fatalf("unexpected names %v", names)
+	}
+	if err = r.Close(); err != nil || !slices.Equal(closed, []string{"db-bad", "db-default", "db-main"}) {
+		t.Fatalf("unexpected close %v, %v", closed, err)
+	}
+	if _, _ = r.Get("main"); opens["main"] != 2 {
+		t.Fatal("expected main opened again after close")
+	}
+}
+
+// test named sql databases of config [passed]
+func Test_sql_db_named(t *testing.T) {
+	config.LoadConfigFS(fstest.MapFS{"config.yaml": {Data: []byte(`database:
+  sql:
+    driver: sqlite3
+    maxOpenConn: 2
+    main:
+      dsn: "file:main?mode=memory&cache=shared"
+    analytics:
+      dsn: "file:analytics?mode=memory&cache=shared"
+      maxOpenConn: 4
+`)}}, "config.yaml")
+	defer CloseAll()
+	main, err := SqlDB("main")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if main.Stats().MaxOpenConnections != 2 {
+		t.Fatalf("expected max open 2 but got %d", main.Stats().MaxOpenConnections)
+	}
+	analytics, err := SqlDB("analytics")
+	if err != nil || analytics.Stats().MaxOpenConnections != 4 {
+		t.Fatalf("unexpected analytics %v", err)
+	}
+	if again, _ := SqlDB("main"); again != main {
+		t.Fatal("expected the same database")
+	}
+	if _, err = SqlDB("none"); !errors.Is(err, ErrDBNotConfigured) {
+		t.Fatalf("expected not configured but got %v", err)
+	}
+	if !slices.Contains(DefaultHealth().Names(), "sql:main") {
+		t.Fatal("expected health check of sql:main")
+	}
+	if err = CloseAll(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if slices.Contains(DefaultHealth().Names(), "sql:main") || main.Ping() == nil {
+		t.Fatal("expected sql:main closed")
+	}
+}
+
+// test concurrent get and close never leak or return a closed database [passed]
+func Test_db_registry_concurrent(t *testing.T) {
+	type conn struct {
+		closed atomic.Bool
+	}
+	var opens, closes atomic.Int32
+	r := NewDBRegistry(func(name string) (*conn, error) {
+		opens.Add(1)
+		// slow open, so Close runs meanwhile
+		time.Sleep(50 * time.Microsecond)
+		return &conn{}, nil
+	}, func(name string, db *conn) error {
+		if db.closed.Swap(true) {
+			return errors.New("closed twice")
+		}
+		closes.Add(1)
+		return nil
+	})
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				if _, err := r.Get([]string{"main", "cache"}[i%2]); err != nil {
+					t.Error(err.Error())
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			if err := r.Close(); err != nil {
+				t.Error(err.Error())
+			}
+		}
+	}()
+	wg.Wait()
+	if err := r.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if opens.Load() != closes.Load() || len(r.Names()) != 0 {
+		t.Fatalf("expected every opened database closed but got %d/%d", closes.Load(), opens.Load())
+	}
+}
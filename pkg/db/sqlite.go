@@ -26,6 +26,7 @@ func InitSqlite(dsn string) SqliteDB {
 	} else {
 		clog.Info("Sqlite Database initialization successful.")
 	}
+	RegisterHealth(_health_sqlite, db.PingContext)
 	return db
 }
 
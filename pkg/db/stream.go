@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"iter"
+	"reflect"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/wendisx/puzzle/pkg/clog"
+)
+
+/*
+	database.stream -- [submodule]
+QStream scans rows lazily instead of loading the whole result into a slice, which
+is what large exports need. The sequence yields every row with a nil error, and
+stops after yielding the first error of query, scan, context or rows. Rows are
+closed when the sequence ends, including when the consumer breaks out early.
+
+	seq := database.QStream[UserBasic](c.Request().Context(), db, `select * from user_basic`)
+	w := csv.NewWriter(c.Response())
+	for user, err := range seq {
+		if err != nil {
+			return err
+		}
+		_ = w.Write([]string{user.UserName})
+	}
+	w.Flush()
+*/
+
+var (
+	_type_scanner = reflect.TypeFor[sql.Scanner]()
+	_type_time    = reflect.TypeFor[time.Time]()
+)
+
+// QStream return the lazy sequence of specify generic type during the execution of the select SQL with placeholder parameters.
+// R should have the largest set of all fields that need to be retrieved and not be a pointer type.
+// The database instance needs to be explicitly specified.
+func QStream[R any](ctx context.Context, db *sqlx.DB, sqlStr string, args ...any) iter.Seq2[R, error] {
+	return func(yield func(R, error) bool) {
+		rows, err := db.QueryxContext(ctx, sqlStr, args...)
+		streamRows(ctx, rows, err, yield)
+	}
+}
+
+// QStreamWithName return the lazy sequence of specify generic type during the execution of the select SQL with named parameters.
+// R should have the largest set of all fields that need to be retrieved and not be a pointer type.
+// The database instance needs to be explicitly specified.
+func QStreamWithName[R any](ctx context.Context, db *sqlx.DB, sqlStr string, obj any) iter.Seq2[R, error] {
+	return func(yield func(R, error) bool) {
+		rows, err := db.NamedQueryContext(ctx, sqlStr, obj)
+		streamRows(ctx, rows, err, yield)
+	}
+}
+
+func streamRows[R any](ctx context.Context, rows *sqlx.Rows, err error, yield func(R, error) bool) {
+	var zero R
+	if err != nil {
+		clog.Error(err.Error())
+		yield(zero, err)
+		return
+	}
+	defer rows.Close()
+	structScan := isStructRow(reflect.TypeFor[R]())
+	for rows.Next() {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		var dest R
+		if structScan {
+			err = rows.StructScan(&dest)
+		} else {
+			err = rows.Scan(&dest)
+		}
+		if err != nil {
+			break
+		}
+		if !yield(dest, nil) {
+			return
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		clog.Error(err.Error())
+		yield(zero, err)
+	}
+}
+
+// isStructRow report whether rows should be scanned into struct fields,
+// the same as sqlx does for Get and Select.
+func isStructRow(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t == _type_time {
+		return false
+	}
+	return !reflect.PointerTo(t).Implements(_type_scanner)
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/wendisx/puzzle/pkg/db/dbtest"
+)
+
+// test lazy stream, early break and scan error [passed]
+func Test_stream(t *testing.T) {
+	h := dbtest.New(t, dbtest.WithSQL(_test_batch_schema))
+	if _, err := BatchInsert(t.Context(), h.DB, "namespace", test_namespaces(10, 1), WithOmit("id")); err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Run("all", func(t *testing.T) {
+		n := 0
+		for ns, err := range QStream[Namespace](t.Context(), h.DB, `select id, name, visible from namespace order by id`) {
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if ns.Id != uint64(n+1) {
+				t.Errorf("expected id %d but got %d", n+1, ns.Id)
+			}
+			n += 1
+		}
+		if n != 10 {
+			t.Errorf("expected 10 rows but got %d", n)
+		}
+	})
+	t.Run("break", func(t *testing.T) {
+		for range QStream[Namespace](t.Context(), h.DB, `select id, name, visible from namespace`) {
+			break
+		}
+		// rows are closed, the only connection can be used again
+		if n := h.Count("namespace"); n != 10 {
+			t.Errorf("expected 10 rows but got %d", n)
+		}
+	})
+	t.Run("scalar", func(t *testing.T) {
+		names := []string{}
+		for name, err := range QStreamWithName[string](t.Context(), h.DB, `select name from namespace where id <= :max_id`, map[string]any{"max_id": 2}) {
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			names = append(names, name)
+		}
+		if len(names) != 2 {
+			t.Errorf("expected 2 names but got %v", names)
+		}
+	})
+	t.Run("scan error", func(t *testing.T) {
+		list, err := QListWithName[Namespace](t.Context(), h.DB, `select id, name, visible, 1 as unknown from namespace`, map[string]any{})
+		if err == nil || len(list) != 0 {
+			t.Errorf("expected scan error but got %d rows and %v", len(list), err)
+		}
+	})
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancle := context.WithCancel(t.Context())
+		defer cancle()
+		n := 0
+		var last error
+		for _, err := range QStream[Namespace](ctx, h.DB, `select id, name, visible from namespace`) {
+			if err != nil {
+				last = err
+				break
+			}
+			n += 1
+			cancle()
+		}
+		if last == nil || n != 1 {
+			t.Errorf("expected cancel after 1 row but got %d rows and %v", n, last)
+		}
+	})
+}
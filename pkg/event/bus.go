@@ -0,0 +1,237 @@
+package event
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	event.bus -- [submodule]
+Bus is the registry of listeners, subscriptions never expire until Unsubscribe.
+Topics are segments joined by `.`, a pattern may use wildcards:
+	*  -- match exactly one segment, `user.*` match `user.created`.
+	** -- match all remaining segments (maybe none), `user.**` match `user` and `user.profile.updated`.
+Listeners with higher priority are called first, listeners with the same priority
+are called in subscribing order. Listeners recorded in the event dict are still
+found and called after the subscriptions with the same priority.
+*/
+
+const (
+	_topic_sep       = "."
+	_topic_wildcard  = "*"
+	_topic_wildcards = "**"
+	feature_sub      = "sub:"
+)
+
+var (
+	_default_bus *Bus
+	_bus_once    sync.Once
+)
+
+type (
+	// Functional subscription configuration.
+	SubscribeOption func(s *Subscription)
+	// Bus hold all subscriptions in memory.
+	Bus struct {
+		seq         atomic.Uint64
+		mu          sync.RWMutex
+		subs        []*Subscription
+		retry       RetryPolicy
+		threshold   int
+		cooldown    time.Duration
+		deadLetters DeadLetterSink
+		counters    counters
+	}
+)
+
+// WithPriority set the priority of subscription, higher runs first.
+func WithPriority(priority int) SubscribeOption {
+	return func(s *Subscription) {
+		s.priority = priority
+	}
+}
+
+// NewBus return a new empty bus, by default listeners are not retried, suspended
+// after 10 consecutive failures for 30s, and the latest 1024 dead letters are kept in memory.
+func NewBus(opts ...BusOption) *Bus {
+	b := &Bus{
+		retry:       NoRetry,
+		threshold:   _default_breaker_threshold,
+		cooldown:    _default_breaker_cooldown,
+		deadLetters: NewMemoryDeadLetters(_default_dead_letters),
+	}
+	for _, fn := range opts {
+		fn(b)
+	}
+	return b
+}
+
+// DefaultBus return the single instance of Bus.
+func DefaultBus() *Bus {
+	_bus_once.Do(func() {
+		_default_bus = NewBus()
+	})
+	return _default_bus
+}
+
+// Subscribe register listener to the default bus.
+func Subscribe(topic string, listener EventListener, opts ...SubscribeOption) *Subscription {
+	return DefaultBus().Subscribe(topic, listener, opts...)
+}
+
+// Subscribe register listener for all events matching the topic pattern.
+// The id of ObserableListener is assigned here if not set.
+func (b *Bus) Subscribe(topic string, listener EventListener, opts ...SubscribeOption) *Subscription {
+	seq := b.seq.Add(1)
+	s := &Subscription{
+		id:       feature_sub + fmt.Sprint(seq),
+		listener: listener,
+		topic:    topic,
+		pattern:  splitTopic(topic),
+		seq:      seq,
+		bus:      b,
+		retry:    b.retry,
+	}
+	for _, fn := range opts {
+		fn(s)
+	}
+	if s.breaker == nil {
+		s.breaker = &breaker{threshold: b.threshold, cooldown: b.cooldown}
+	}
+	if ol, ok := listener.(*ObserableListener); ok && ol.id == 0 {
+		ol.id = uint(seq)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, s)
+	sortSubscriptions(b.subs)
+	return s
+}
+
+// Subscriptions return a copy of all subscriptions sorted by priority.
+func (b *Bus) Subscriptions() []*Subscription {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return slices.Clone(b.subs)
+}
+
+// Match return the subscriptions whose topic pattern matches the topic.
+func (b *Bus) Match(topic string) []*Subscription {
+	subs := b.Subscriptions()
+	return slices.DeleteFunc(subs, func(s *Subscription) bool {
+		return !s.Match(topic)
+	})
+}
+
+func (b *Bus) remove(s *Subscription) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(b.subs)
+	b.subs = slices.DeleteFunc(b.subs, func(o *Subscription) bool {
+		return o == s
+	})
+	return len(b.subs) != n
+}
+
+// Topic return the topic pattern of subscription.
+func (s *Subscription) Topic() string {
+	return s.topic
+}
+
+// Priority return the priority of subscription.
+func (s *Subscription) Priority() int {
+	return s.priority
+}
+
+// Unsubscribe remove subscription from its bus, return false if already removed.
+// The lanes of dispatchers for it are retired after their queued events.
+func (s *Subscription) Unsubscribe() bool {
+	if s.bus == nil || !s.bus.remove(s) {
+		return false
+	}
+	s.hooksMu.Lock()
+	s.removed = true
+	hooks := s.onRemove
+	s.onRemove = nil
+	s.hooksMu.Unlock()
+	for _, fn := range hooks {
+		fn()
+	}
+	return true
+}
+
+// watch add fn called when the subscription is removed, false if already removed.
+func (s *Subscription) watch(fn func()) bool {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	if s.removed {
+		return false
+	}
+	s.onRemove = append(s.onRemove, fn)
+	return true
+}
+
+// Match report whether the topic matches the pattern of subscription.
+// Subscriptions without pattern (from the event dict) match all topics.
+func (s *Subscription) Match(topic string) bool {
+	if s.pattern == nil {
+		return true
+	}
+	return matchTopic(s.pattern, splitTopic(topic))
+}
+
+// MatchTopic report whether the topic matches the pattern like `order.*` or `order.**`.
+func MatchTopic(pattern, topic string) bool {
+	return matchTopic(splitTopic(pattern), splitTopic(topic))
+}
+
+// allSubscriptions return the subscriptions of default bus and event dict sorted by priority.
+func allSubscriptions() []*Subscription {
+	subs := append(DefaultBus().Subscriptions(), dictSubscriptions()...)
+	sortSubscriptions(subs)
+	return subs
+}
+
+// topicOf return the topic of event, empty if the event has no topic.
+func topicOf(e Event) string {
+	if t, ok := e.(interface{ Topic() string }); ok {
+		return t.Topic()
+	}
+	return ""
+}
+
+func sortSubscriptions(subs []*Subscription) {
+	slices.SortStableFunc(subs, func(a, b *Subscription) int {
+		return cmp.Compare(b.priority, a.priority)
+	})
+}
+
+func splitTopic(topic string) []string {
+	if topic == "" {
+		return []string{}
+	}
+	return strings.Split(topic, _topic_sep)
+}
+
+func matchTopic(pattern, topic []string) bool {
+	for i, seg := range pattern {
+		if seg == _topic_wildcards {
+			rest := pattern[i+1:]
+			for j := i; j <= len(topic); j++ {
+				if matchTopic(rest, topic[j:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if i >= len(topic) || (seg != _topic_wildcard && seg != topic[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(topic)
+}
@@ -0,0 +1,75 @@
+package event
+
+import (
+	"testing"
+)
+
+type orderListener struct {
+	name  string
+	order *[]string
+}
+
+func (l *orderListener) Want(e Event) bool {
+	return true
+}
+
+func (l *orderListener) Listen(e Event) error {
+	*l.order = append(*l.order, l.name)
+	return nil
+}
+
+// test wildcard topic matching [passed]
+func Test_match_topic(t *testing.T) {
+	cases := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{"user.created", "user.created", true},
+		{"user.created", "user.deleted", false},
+		{"user.*", "user.created", true},
+		{"user.*", "user", false},
+		{"user.*", "user.profile.updated", false},
+		{"user.**", "user", true},
+		{"user.**", "user.profile.updated", true},
+		{"*.created", "order.created", true},
+		{"**.created", "a.b.created", true},
+		{"**.created", "a.b.deleted", false},
+		{"**", "", true},
+		{"", "", true},
+		{"", "user", false},
+	}
+	for _, c := range cases {
+		if got := matchTopic(splitTopic(c.pattern), splitTopic(c.topic)); got != c.want {
+			t.Errorf("pattern(%s) topic(%s) expected %v but got %v", c.pattern, c.topic, c.want, got)
+		}
+	}
+}
+
+// test priority order and unsubscribe [passed]
+func Test_bus_subscribe(t *testing.T) {
+	b := NewBus()
+	order := []string{}
+	low := b.Subscribe("user.**", &orderListener{name: "low", order: &order}, WithPriority(-1))
+	b.Subscribe("user.*", &orderListener{name: "normal", order: &order})
+	b.Subscribe("user.created", &orderListener{name: "high", order: &order}, WithPriority(10))
+	b.Subscribe("order.*", &orderListener{name: "other", order: &order})
+	ol := &ObserableListener{}
+	b.Subscribe("user.created", ol)
+	if ol.id == 0 {
+		t.Errorf("expected listener id assigned")
+	}
+	d := NewDispatcher(WithSource(b.Subscriptions))
+	if err := d.Publish(NewTopicEvent("user.created", 1, nil)); err != nil {
+		t.Fatal(err.Error())
+	}
+	if want := []string{"high", "normal", "low"}; len(order) != 3 || order[0] != want[0] || order[1] != want[1] || order[2] != want[2] {
+		t.Errorf("expected %v but got %v", want, order)
+	}
+	if !low.Unsubscribe() || low.Unsubscribe() {
+		t.Errorf("expected unsubscribe only once")
+	}
+	if n := len(b.Match("user.created")); n != 3 {
+		t.Errorf("expected 3 subscriptions but got %d", n)
+	}
+}
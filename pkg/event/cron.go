@@ -0,0 +1,204 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	event.cron -- [submodule]
+Cron parses the standard 5 fields expression `minute hour day-of-month month day-of-week`,
+each field supports `*`, `a`, `a-b`, steps `a-b/n` (or `*` and `a` with `/n`) and lists
+of them joined by `,`.
+Months and weekdays also accept names like `jan` and `mon`, sunday is 0 or 7.
+Descriptors `@yearly`, `@monthly`, `@weekly`, `@daily`, `@midnight`, `@hourly`
+and `@every <duration>` are supported too. Like the classic cron, when both
+day-of-month and day-of-week are restricted a day matching either one fires.
+*/
+
+var (
+	ErrCronSpec = errors.New("invalid cron spec")
+
+	_cron_descriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+	_cron_months   = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	_cron_weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+type (
+	// Cron is a parsed cron schedule.
+	Cron struct {
+		spec   string
+		every  time.Duration
+		minute uint64
+		hour   uint64
+		dom    uint64
+		month  uint64
+		dow    uint64
+		anyDom bool
+		anyDow bool
+	}
+	cronField struct {
+		min, max int
+		names    []string
+		offset   int // value of names[0]
+	}
+)
+
+// ParseCron parse the cron spec.
+func ParseCron(spec string) (*Cron, error) {
+	spec = strings.TrimSpace(spec)
+	c := &Cron{spec: spec}
+	if after, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(after))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%w: %s", ErrCronSpec, spec)
+		}
+		c.every = d
+		return c, nil
+	}
+	if expr, found := _cron_descriptors[spec]; found {
+		spec = expr
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: %s need 5 fields", ErrCronSpec, c.spec)
+	}
+	defs := []cronField{
+		{min: 0, max: 59},
+		{min: 0, max: 23},
+		{min: 1, max: 31},
+		{min: 1, max: 12, names: _cron_months, offset: 1},
+		{min: 0, max: 7, names: _cron_weekdays},
+	}
+	sets := make([]uint64, 5)
+	for i, f := range fields {
+		set, err := defs[i].parse(f)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s field(%s) %s", ErrCronSpec, c.spec, f, err.Error())
+		}
+		sets[i] = set
+	}
+	c.minute, c.hour, c.dom, c.month, c.dow = sets[0], sets[1], sets[2], sets[3], sets[4]
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.anyDom, c.anyDow = fields[2] == "*", fields[4] == "*"
+	return c, nil
+}
+
+// MustParseCron is ParseCron but panic for invalid spec.
+func MustParseCron(spec string) *Cron {
+	c, err := ParseCron(spec)
+	if err != nil {
+		panic(err.Error())
+	}
+	return c
+}
+
+func (c *Cron) String() string {
+	return c.spec
+}
+
+// Next return the first time after t matching the schedule, zero if none in 5 years.
+func (c *Cron) Next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Truncate(time.Second).Add(c.every)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c *Cron) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (f cronField) parse(expr string) (uint64, error) {
+	var set uint64
+	for part := range strings.SplitSeq(expr, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %s", stepStr)
+			}
+			step = n
+		}
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(loStr); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(hiStr); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range %s", rng)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	if bits.OnesCount64(set) == 0 {
+		return 0, fmt.Errorf("empty field")
+	}
+	return set, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return i + f.offset, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("value %s out of [%d, %d]", s, f.min, f.max)
+	}
+	return n, nil
+}
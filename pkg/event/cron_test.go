@@ -0,0 +1,38 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+// test cron parsing and next time [passed]
+func Test_cron_next(t *testing.T) {
+	from := time.Date(2025, 1, 31, 23, 59, 30, 0, time.UTC) // friday
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/15 9-17 * * mon-fri", time.Date(2025, 2, 3, 9, 0, 0, 0, time.UTC)},
+		{"30 3 * * *", time.Date(2025, 2, 1, 3, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 * 0", time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)}, // day 1 or sunday
+		{"0 0 * * 7", time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2025, 2, 1, 0, 1, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		cron, err := ParseCron(c.spec)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if got := cron.Next(from); !got.Equal(c.want) {
+			t.Errorf("spec(%s) expected %s but got %s", c.spec, c.want, got)
+		}
+	}
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * * foo *", "5-1 * * * *", "*/0 * * * *", "@every -1s"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("expected spec(%s) invalid", spec)
+		}
+	}
+}
@@ -0,0 +1,544 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+/*
+	event.dispatcher -- [submodule]
+Dispatcher delivers an event to every listener wanting it in one of three modes:
+	sync  -- call listeners in the caller goroutine and return the result.
+	async -- queue the event and return a future of the result.
+	fire  -- queue the event and forget it, listener errors are only logged.
+Every listener owns a lane of workers, each worker has a bounded queue, the lane is
+retired after its queued events when the subscription is unsubscribed. The listeners
+of event dict share one lane, which lives until Close, as they are looked up on every
+publish and never unsubscribed. Events
+implementing Keyed are always routed to the same worker of a lane, so a listener
+observes the events of one key in publishing order. A full queue blocks the
+publisher, which is the backpressure of the dispatcher. A panic in a listener is
+recovered and reported as its error. Close stops accepting events and drains all
+queued events before returning, the retry backoff of queued events is given up when
+the ctx of Close is done.
+*/
+
+const (
+	DISPATCH_SYNC  DispatchMode = iota // wait listeners and collect errors
+	DISPATCH_ASYNC                     // return future of listener errors
+	DISPATCH_FIRE                      // fire and forget
+
+	_default_lane_workers = 4
+	_default_lane_queue   = 256
+	_dict_lane_id         = feature_listener // shared by the listeners of event dict
+)
+
+var (
+	ErrDispatcherClosed = errors.New("event dispatcher closed")
+	ErrListenerPanic    = errors.New("event listener panic")
+	ErrUnsubscribed     = errors.New("event listener unsubscribed")
+
+	_default_dispatcher *Dispatcher
+	_dispatcher_once    sync.Once
+)
+
+type (
+	DispatchMode uint8
+	// Keyed is implemented by events which need ordered delivery per key.
+	Keyed interface {
+		Key() string
+	}
+	// Subscription bind a listener to a topic pattern.
+	Subscription struct {
+		id       string
+		listener EventListener
+		topic    string
+		pattern  []string
+		priority int
+		seq      uint64
+		bus      *Bus
+		retry    RetryPolicy
+		breaker  *breaker
+		counters counters
+		hooksMu  sync.Mutex
+		removed  bool
+		onRemove []func() // like retiring the lanes of dispatchers
+		dict     bool     // from the event dict, built on every lookup
+	}
+	// Functional dispatcher configuration.
+	DispatcherOption func(d *Dispatcher)
+	// Dispatcher deliver events to listeners concurrently.
+	Dispatcher struct {
+		mode     DispatchMode
+		workers  int
+		queue    int
+		source   func() []*Subscription
+		mu       sync.RWMutex // guards closed only, never held while blocking
+		closed   bool
+		closing  chan struct{}   // closed by Close, wakes the blocked senders
+		stopped  chan struct{}   // closed after all lanes are closed
+		abort    context.Context // done when Close gives up draining, cancels the queued deliveries
+		abortFn  context.CancelFunc
+		sending  sync.WaitGroup
+		mwMu     sync.Mutex
+		pubMw    atomic.Pointer[[]Middleware] // copied on write, read without lock
+		listenMw atomic.Pointer[[]Middleware]
+		lanesMu  sync.Mutex
+		lanes    map[string]*lane
+		running  sync.WaitGroup
+	}
+	// Failure record the error of a single listener.
+	Failure struct {
+		Listener string
+		Err      error
+	}
+	// Result collect the delivery of an event.
+	Result struct {
+		Event     Event
+		Delivered int
+		Failures  []Failure
+	}
+	// Future will hold the result after all listeners finished.
+	Future struct {
+		mu      sync.Mutex
+		pending int
+		res     Result
+		done    chan struct{}
+	}
+	lane struct {
+		next    atomic.Uint64
+		shards  []chan task
+		senders sync.WaitGroup // sends in flight, the shards are closed after them
+		retired bool           // guarded by lanesMu
+	}
+	task struct {
+		ctx  context.Context
+		sub  *Subscription
+		e    Event
+		done func(s *Subscription, err error)
+	}
+)
+
+// WithMode set the mode used by Publish.
+func WithMode(mode DispatchMode) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.mode = mode
+	}
+}
+
+// WithWorkers set the number of workers per listener.
+func WithWorkers(n int) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.workers = max(n, 1)
+	}
+}
+
+// WithQueue set the bounded queue size per worker.
+func WithQueue(n int) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.queue = max(n, 0)
+	}
+}
+
+// WithSource set where the dispatcher find listeners,
+// default from the default bus and event dict with key prefix `listener:`.
+func WithSource(source func() []*Subscription) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.source = source
+	}
+}
+
+// NewDispatcher return a new dispatcher.
+func NewDispatcher(opts ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{
+		mode:    DISPATCH_SYNC,
+		workers: _default_lane_workers,
+		queue:   _default_lane_queue,
+		source:  allSubscriptions,
+		closing: make(chan struct{}),
+		stopped: make(chan struct{}),
+		lanes:   make(map[string]*lane),
+	}
+	d.abort, d.abortFn = context.WithCancel(context.Background())
+	for _, fn := range opts {
+		fn(d)
+	}
+	return d
+}
+
+// DefaultDispatcher return the single instance of Dispatcher.
+func DefaultDispatcher() *Dispatcher {
+	_dispatcher_once.Do(func() {
+		_default_dispatcher = NewDispatcher()
+	})
+	return _default_dispatcher
+}
+
+// ID return the unique id of subscription.
+func (s *Subscription) ID() string {
+	return s.id
+}
+
+// Listener return the listener of subscription.
+func (s *Subscription) Listener() EventListener {
+	return s.listener
+}
+
+// Publish dispatch the event with the mode of dispatcher.
+// Only sync mode reports listener errors, the other modes report whether the event is accepted.
+func (d *Dispatcher) Publish(e Event) error {
+	return d.PublishContext(context.Background(), e)
+}
+
+// PublishContext dispatch the event with the mode of dispatcher, ctx is passed through
+// the middleware chains to listeners and its request id becomes the correlation id of event.
+func (d *Dispatcher) PublishContext(ctx context.Context, e Event) error {
+	switch d.mode {
+	case DISPATCH_ASYNC, DISPATCH_FIRE:
+		return d.FireContext(ctx, e)
+	default:
+		return d.PublishSyncContext(ctx, e).Err()
+	}
+}
+
+// PublishSync call all listeners in the caller goroutine and wait their result.
+func (d *Dispatcher) PublishSync(e Event) Result {
+	return d.PublishSyncContext(context.Background(), e)
+}
+
+// PublishSyncContext is PublishSync with ctx.
+func (d *Dispatcher) PublishSyncContext(ctx context.Context, e Event) Result {
+	res := Result{Event: e}
+	err := d.publish(ctx, e, func(ctx context.Context, e Event) error {
+		if d.isClosed() {
+			return ErrDispatcherClosed
+		}
+		for _, s := range d.targets(e) {
+			res.collect(s, d.invoke(ctx, s, e))
+		}
+		return res.Err()
+	})
+	if err != nil && len(res.Failures) == 0 {
+		// rejected by publish middleware
+		res.Failures = append(res.Failures, Failure{Err: err})
+	}
+	return res
+}
+
+// PublishAsync queue the event to all listeners and return the future of result.
+func (d *Dispatcher) PublishAsync(e Event) *Future {
+	return d.PublishAsyncContext(context.Background(), e)
+}
+
+// PublishAsyncContext is PublishAsync with ctx, the cancellation of ctx only stops waiting
+// for a full queue and is not passed to listeners.
+func (d *Dispatcher) PublishAsyncContext(ctx context.Context, e Event) *Future {
+	f := &Future{
+		res:  Result{Event: e},
+		done: make(chan struct{}),
+	}
+	queued := false
+	err := d.publish(ctx, e, func(ctx context.Context, e Event) error {
+		subs := d.targets(e)
+		if len(subs) == 0 {
+			return nil
+		}
+		queued = true
+		f.pending = len(subs)
+		return d.enqueue(ctx, e, subs, f.complete)
+	})
+	if !queued {
+		if err != nil {
+			f.res.Failures = append(f.res.Failures, Failure{Err: err})
+		}
+		close(f.done)
+	}
+	return f
+}
+
+// Fire queue the event to all listeners without waiting, listener errors are logged.
+func (d *Dispatcher) Fire(e Event) error {
+	return d.FireContext(context.Background(), e)
+}
+
+// FireContext is Fire with ctx, the cancellation of ctx only stops waiting for a full queue
+// and is not passed to listeners.
+func (d *Dispatcher) FireContext(ctx context.Context, e Event) error {
+	return d.publish(ctx, e, func(ctx context.Context, e Event) error {
+		return d.enqueue(ctx, e, d.targets(e), nil)
+	})
+}
+
+// Close stop accepting events and wait until all queued events are delivered or ctx is done.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.closing)
+		go func() {
+			// the blocked senders give up by closing, then nothing is sent to the lanes,
+			// the retired lanes are closed by retire
+			d.sending.Wait()
+			d.lanesMu.Lock()
+			for id, l := range d.lanes {
+				l.retired = true
+				l.close()
+				delete(d.lanes, id)
+			}
+			d.lanesMu.Unlock()
+			close(d.stopped)
+		}()
+	}
+	d.mu.Unlock()
+	drained := make(chan struct{})
+	go func() {
+		<-d.stopped
+		d.running.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		clog.Info("event dispatcher drained")
+		return nil
+	case <-ctx.Done():
+		clog.Warn(fmt.Sprintf("event dispatcher drain interrupted for %s", palette.Red(ctx.Err())))
+		d.abortFn()
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) isClosed() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.closed
+}
+
+func (d *Dispatcher) targets(e Event) []*Subscription {
+	subs := d.source()
+	topic := topicOf(e)
+	wants := make([]*Subscription, 0, len(subs))
+	for _, s := range subs {
+		if s.Match(topic) && s.listener.Want(e) {
+			wants = append(wants, s)
+		}
+	}
+	return wants
+}
+
+// enqueue send the tasks of event to the lanes of subs, blocking on a full queue is the
+// backpressure, it gives up when the dispatcher is closing or ctx is done.
+func (d *Dispatcher) enqueue(ctx context.Context, e Event, subs []*Subscription, done func(*Subscription, error)) error {
+	d.mu.RLock()
+	closed := d.closed
+	if !closed {
+		d.sending.Add(1)
+	}
+	d.mu.RUnlock()
+	if closed {
+		fail(subs, done, ErrDispatcherClosed)
+		return ErrDispatcherClosed
+	}
+	defer d.sending.Done()
+	t := task{ctx: context.WithoutCancel(ctx), e: e, done: done}
+	for i, s := range subs {
+		l := d.lane(s)
+		if l == nil {
+			// unsubscribed meanwhile, not counted by the result
+			if done != nil {
+				done(s, ErrUnsubscribed)
+			}
+			continue
+		}
+		t.sub = s
+		var err error
+		select {
+		case l.pick(e) <- t:
+		case <-d.closing:
+			err = ErrDispatcherClosed
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		l.senders.Done()
+		if err != nil {
+			fail(subs[i:], done, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func fail(subs []*Subscription, done func(*Subscription, error), err error) {
+	if done == nil {
+		return
+	}
+	for _, s := range subs {
+		done(s, err)
+	}
+}
+
+// lane return the lane of subscription and start its workers lazily, nil if the subscription
+// is removed. The caller must call senders.Done of the lane after sending.
+func (d *Dispatcher) lane(s *Subscription) *lane {
+	id := s.id
+	if s.dict {
+		id = _dict_lane_id
+	}
+	d.lanesMu.Lock()
+	defer d.lanesMu.Unlock()
+	if l, found := d.lanes[id]; found {
+		l.senders.Add(1)
+		return l
+	}
+	l := &lane{
+		shards: make([]chan task, d.workers),
+	}
+	// the lane is retired with the subscription, so its workers don't outlive it
+	if !s.dict && !s.watch(func() { d.retire(id, l) }) {
+		return nil
+	}
+	for i := range l.shards {
+		l.shards[i] = make(chan task, d.queue)
+		d.running.Add(1)
+		go d.work(l.shards[i])
+	}
+	d.lanes[id] = l
+	l.senders.Add(1)
+	return l
+}
+
+// retire remove the lane of unsubscribed subscription, its workers exit after the queued events.
+func (d *Dispatcher) retire(id string, l *lane) {
+	d.lanesMu.Lock()
+	if d.lanes[id] != l || l.retired {
+		d.lanesMu.Unlock()
+		return
+	}
+	delete(d.lanes, id)
+	l.retired = true
+	d.lanesMu.Unlock()
+	go func() {
+		l.senders.Wait()
+		l.close()
+	}()
+}
+
+func (l *lane) close() {
+	for _, ch := range l.shards {
+		close(ch)
+	}
+}
+
+func (d *Dispatcher) work(ch chan task) {
+	defer d.running.Done()
+	for t := range ch {
+		ctx, cancel := context.WithCancel(t.ctx)
+		stop := context.AfterFunc(d.abort, cancel)
+		err := d.invoke(ctx, t.sub, t.e)
+		stop()
+		cancel()
+		if t.done != nil {
+			t.done(t.sub, err)
+		} else if err != nil {
+			clog.Error(fmt.Sprintf("listener(%s) fail for %s", palette.Red(t.sub.id), err.Error()))
+		}
+	}
+}
+
+// invoke deliver the event to subscription through the listen chain with its retry and breaker.
+func (d *Dispatcher) invoke(ctx context.Context, s *Subscription, e Event) error {
+	ctx = context.WithValue(ctx, _ctx_listener_id, s.id)
+	// workers never take the lock of dispatcher, so they keep draining while Close waits
+	h := chain(func(ctx context.Context, e Event) error {
+		return callListener(ctx, s, e)
+	}, middlewares(&d.listenMw))
+	return s.deliver(ctx, e, h)
+}
+
+// callListener call listener and isolate its panic.
+func callListener(ctx context.Context, s *Subscription, e Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrListenerPanic, r)
+			clog.Error(fmt.Sprintf("listener(%s) panic for %v", palette.Red(s.id), r))
+		}
+	}()
+	if cl, ok := s.listener.(ContextListener); ok {
+		return cl.ListenContext(ctx, e)
+	}
+	return s.listener.Listen(e)
+}
+
+// pick return the queue for event, keyed events always use the same queue.
+func (l *lane) pick(e Event) chan task {
+	if k, ok := e.(Keyed); ok {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(k.Key()))
+		return l.shards[h.Sum32()%uint32(len(l.shards))]
+	}
+	return l.shards[l.next.Add(1)%uint64(len(l.shards))]
+}
+
+func (r *Result) collect(s *Subscription, err error) {
+	if errors.Is(err, ErrUnsubscribed) {
+		return
+	}
+	if err != nil {
+		r.Failures = append(r.Failures, Failure{Listener: s.id, Err: err})
+		return
+	}
+	r.Delivered += 1
+}
+
+// Err return the joined errors of all failed listeners.
+func (r Result) Err() error {
+	errs := make([]error, 0, len(r.Failures))
+	for _, f := range r.Failures {
+		if f.Listener == "" {
+			errs = append(errs, f.Err)
+			continue
+		}
+		errs = append(errs, fmt.Errorf("listener(%s): %w", f.Listener, f.Err))
+	}
+	return errors.Join(errs...)
+}
+
+func (f *Future) complete(s *Subscription, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.res.collect(s, err)
+	f.pending -= 1
+	if f.pending == 0 {
+		close(f.done)
+	}
+}
+
+// Done return a channel closed after all listeners finished.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result return the result collected so far.
+func (f *Future) Result() Result {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := f.res
+	res.Failures = append([]Failure(nil), f.res.Failures...)
+	return res
+}
+
+// Wait block until all listeners finished or ctx is done.
+func (f *Future) Wait(ctx context.Context) (Result, error) {
+	select {
+	case <-f.done:
+		return f.Result(), nil
+	case <-ctx.Done():
+		return f.Result(), ctx.Err()
+	}
+}
@@ -0,0 +1,217 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wendisx/puzzle/pkg/config"
+)
+
+type (
+	keyedEvent struct {
+		ObserableEvent
+		key string
+		seq int
+	}
+	recordListener struct {
+		mu    sync.Mutex
+		fail  bool
+		panic bool
+		seen  map[string][]int
+	}
+)
+
+func (e keyedEvent) Key() string {
+	return e.key
+}
+
+func (l *recordListener) Want(e Event) bool {
+	return true
+}
+
+func (l *recordListener) Listen(e Event) error {
+	if l.panic {
+		panic("listener broken")
+	}
+	if l.fail {
+		return errors.New("listener fail")
+	}
+	if ke, ok := e.(keyedEvent); ok {
+		l.mu.Lock()
+		l.seen[ke.key] = append(l.seen[ke.key], ke.seq)
+		l.mu.Unlock()
+	}
+	return nil
+}
+
+func test_source(ls ...EventListener) func() []*Subscription {
+	subs := make([]*Subscription, 0, len(ls))
+	for i, l := range ls {
+		subs = append(subs, &Subscription{id: fmt.Sprintf("test:%d", i), listener: l})
+	}
+	return func() []*Subscription {
+		return subs
+	}
+}
+
+// test sync errors and panic isolation [passed]
+func Test_dispatch_sync(t *testing.T) {
+	ok := &recordListener{seen: map[string][]int{}}
+	d := NewDispatcher(WithSource(test_source(ok, &recordListener{fail: true}, &recordListener{panic: true})))
+	res := d.PublishSync(NewObserableEvent(1, nil))
+	if res.Delivered != 1 || len(res.Failures) != 2 {
+		t.Fatalf("expected 1 delivered and 2 failures but got %+v", res)
+	}
+	if !errors.Is(res.Err(), ErrListenerPanic) {
+		t.Errorf("expected panic error but got %v", res.Err())
+	}
+}
+
+// test ordered delivery per key and drain on close [passed]
+func Test_dispatch_async(t *testing.T) {
+	l := &recordListener{seen: map[string][]int{}}
+	d := NewDispatcher(WithSource(test_source(l)), WithWorkers(4), WithQueue(2))
+	futures := []*Future{}
+	for i := range 100 {
+		e := keyedEvent{ObserableEvent: *NewObserableEvent(1, nil), key: fmt.Sprintf("k%d", i%3), seq: i}
+		futures = append(futures, d.PublishAsync(e))
+	}
+	ctx, cancle := context.WithTimeout(t.Context(), time.Second)
+	defer cancle()
+	for _, f := range futures {
+		res, err := f.Wait(ctx)
+		if err != nil || res.Err() != nil {
+			t.Fatalf("expected delivered but got %v %v", err, res.Err())
+		}
+	}
+	for i := range 50 {
+		_ = d.Fire(keyedEvent{ObserableEvent: *NewObserableEvent(1, nil), key: "k0", seq: 100 + i})
+	}
+	if err := d.Close(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	for k, seqs := range l.seen {
+		for i := 1; i < len(seqs); i++ {
+			if seqs[i] < seqs[i-1] {
+				t.Fatalf("key %s out of order: %v", k, seqs)
+			}
+		}
+	}
+	if n := len(l.seen["k0"]); n != 84 {
+		t.Errorf("expected 84 events of k0 but got %d", n)
+	}
+	if err := d.Fire(NewObserableEvent(1, nil)); !errors.Is(err, ErrDispatcherClosed) {
+		t.Errorf("expected closed error but got %v", err)
+	}
+}
+
+// test the lanes of unsubscribed listeners are retired [passed]
+func Test_dispatch_unsubscribe(t *testing.T) {
+	b := NewBus()
+	d := NewDispatcher(WithSource(b.Subscriptions), WithWorkers(4))
+	base := runtime.NumGoroutine()
+	for range 50 {
+		l := &recordListener{seen: map[string][]int{}}
+		s := b.Subscribe("**", l)
+		f := d.PublishAsync(NewObserableEvent(1, nil))
+		if _, err := f.Wait(t.Context()); err != nil {
+			t.Fatal(err.Error())
+		}
+		s.Unsubscribe()
+	}
+	d.lanesMu.Lock()
+	lanes := len(d.lanes)
+	d.lanesMu.Unlock()
+	if lanes != 0 {
+		t.Fatalf("expected lanes retired but got %d", lanes)
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > base; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected workers exit but got %d goroutines over %d", runtime.NumGoroutine(), base)
+		}
+	}
+	if err := d.Close(t.Context()); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+// test close with senders blocked on a full queue [passed]
+func Test_dispatch_close_blocked(t *testing.T) {
+	b := NewBus()
+	d := NewDispatcher(WithSource(b.Subscriptions), WithWorkers(1), WithQueue(1))
+	b.Subscribe("**", &funcListener{fn: func(e Event) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}})
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Fire(NewObserableEvent(1, nil))
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	d.UseListen(func(next Handler) Handler {
+		return next
+	})
+	ctx, cancel := context.WithTimeout(t.Context(), 2*time.Second)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		t.Fatalf("expected close without deadlock but got %v", err)
+	}
+	wg.Wait()
+	if err := d.Fire(NewObserableEvent(1, nil)); !errors.Is(err, ErrDispatcherClosed) {
+		t.Fatalf("expected closed but got %v", err)
+	}
+}
+
+// test a listener unsubscribed while publishing is neither delivered nor failed [passed]
+func Test_dispatch_unsubscribed(t *testing.T) {
+	b := NewBus()
+	d := NewDispatcher(WithSource(b.Subscriptions))
+	l := &recordListener{seen: map[string][]int{}}
+	gone, kept := b.Subscribe("**", l), b.Subscribe("**", l)
+	gone.Unsubscribe()
+	f := &Future{res: Result{Event: NewObserableEvent(1, nil)}, pending: 2, done: make(chan struct{})}
+	if err := d.enqueue(t.Context(), f.res.Event, []*Subscription{gone, kept}, f.complete); err != nil {
+		t.Fatal(err.Error())
+	}
+	res, err := f.Wait(t.Context())
+	if err != nil || res.Delivered != 1 || len(res.Failures) != 0 {
+		t.Fatalf("expected only the kept listener delivered but got %+v, %v", res, err)
+	}
+	if err = d.Close(t.Context()); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+// test the listeners of event dict share one lane [passed]
+func Test_dispatch_dict_lane(t *testing.T) {
+	config.LoadDict(config.DICTKEY_EVENT)
+	eventDict := config.GetDict(config.DICTKEY_EVENT)
+	key := feature_listener + "dispatch"
+	eventDict.Record(key, &recordListener{seen: map[string][]int{}})
+	defer eventDict.Remove(key)
+	d := NewDispatcher(WithSource(dictSubscriptions), WithWorkers(2))
+	for range 10 {
+		if _, err := d.PublishAsync(NewObserableEvent(1, nil)).Wait(t.Context()); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	d.lanesMu.Lock()
+	_, shared := d.lanes[_dict_lane_id]
+	lanes := len(d.lanes)
+	d.lanesMu.Unlock()
+	if !shared || lanes != 1 {
+		t.Fatalf("expected the shared dict lane only but got %d lanes", lanes)
+	}
+	if err := d.Close(t.Context()); err != nil {
+		t.Fatal(err.Error())
+	}
+}
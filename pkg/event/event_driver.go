@@ -1,12 +1,16 @@
 package event
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/wendisx/puzzle/pkg/clog"
 	"github.com/wendisx/puzzle/pkg/config"
+	"github.com/wendisx/puzzle/pkg/palette"
 )
 
 /*
@@ -44,8 +48,13 @@ type (
 		Listen(e Event) error
 	}
 	EventMeta struct {
-		timeline time.Time
-		rarity   int // for some specific operations
+		timeline      time.Time
+		rarity        int    // for some specific operations
+		topic         string // for subscriptions matching
+		name          string
+		id            string
+		correlationId string
+		headers       Headers
 	}
 	// maybe Use of some meta information or more weird states
 	UnobserableEvent struct {
@@ -67,11 +76,19 @@ func NewObserableEvent(rarity int, record any) *ObserableEvent {
 		EventMeta: EventMeta{
 			timeline: time.Now(),
 			rarity:   rarity,
+			id:       uuid.NewString(),
 		},
 		record: record,
 	}
 }
 
+// NewTopicEvent return an observable event delivered to the subscriptions matching topic.
+func NewTopicEvent(topic string, rarity int, record any) *ObserableEvent {
+	e := NewObserableEvent(rarity, record)
+	e.topic = topic
+	return e
+}
+
 func NewUnobserableEvent() *UnobserableEvent {
 	return &UnobserableEvent{
 		EventMeta: EventMeta{
@@ -81,6 +98,11 @@ func NewUnobserableEvent() *UnobserableEvent {
 	}
 }
 
+// Topic return the topic of event, empty if not set.
+func (m EventMeta) Topic() string {
+	return m.topic
+}
+
 func (e ObserableEvent) Kind() uint {
 	return EVENT_KIND_OBSERABLE
 }
@@ -113,20 +135,50 @@ func (e UnobserableEvent) Record() any {
 	return nil
 }
 
+// Publish call all listeners subscribing the topic and wanting the event synchronously
+// by priority, and return the joined errors of them.
 func (p *ObserablePublisher) Publish(e Event) error {
+	var errs []error
+	topic := topicOf(e)
+	for _, s := range allSubscriptions() {
+		if !s.Match(topic) || !s.listener.Want(e) {
+			continue
+		}
+		if err := s.listener.Listen(e); err != nil {
+			errs = append(errs, fmt.Errorf("listener(%s): %w", s.id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// dictSubscriptions return all listeners recorded in the event dict with key prefix `listener:`.
+func dictSubscriptions() []*Subscription {
+	if !config.HasDict(config.DICTKEY_EVENT) {
+		return nil
+	}
 	eventDict := config.GetDict(config.DICTKEY_EVENT)
 	filter := func(k string) bool {
 		return strings.HasPrefix(k, feature_listener)
 	}
 	keys := eventDict.Keys(filter)
-	var listener EventListener
+	slices.Sort(keys)
+	subs := make([]*Subscription, 0, len(keys))
 	for _, k := range keys {
-		listener = eventDict.Find(k).Value().(EventListener)
-		if listener.Want(e) {
-			listener.Listen(e)
+		if !eventDict.Has(k) {
+			continue
+		}
+		listener, ok := eventDict.Find(k).Value().(EventListener)
+		if !ok {
+			clog.Warn(fmt.Sprintf("data(%s) from dict(%s) is not a listener", palette.Red(k), palette.Red(config.DICTKEY_EVENT)))
+			continue
 		}
+		subs = append(subs, &Subscription{
+			id:       k,
+			listener: listener,
+			dict:     true,
+		})
 	}
-	return nil
+	return subs
 }
 
 func (l *ObserableListener) Want(e Event) bool {
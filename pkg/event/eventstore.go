@@ -0,0 +1,255 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+	event.eventstore -- [submodule]
+EventStore is an append-only log of event streams, a stream usually holds the events
+of one aggregate like `order-42`. Every event of a stream has a version starting from 1,
+Append succeeds only if the stream is still at the expected version, otherwise it
+returns ErrConcurrency and the caller reloads the aggregate and retries. Every event
+also has a global position used by projections to read all streams in order. The
+positions are committed in order, an event never becomes visible after a greater
+position was read, so a projection reading after its checkpoint skips nothing; the
+SQL store serializes appends by a sequence row to keep it.
+
+	agg, err := event.LoadAggregate(ctx, store, "order-42", func(o *Order, se event.StoredEvent) error {
+		return o.Apply(se) // decode payload by se.Name
+	})
+	_, err = store.Append(ctx, "order-42", agg.Version, event.NewTypedEvent("order.paid", paid))
+
+Snapshots keep the state of an aggregate at a version, LoadAggregate starts from the
+latest snapshot and only applies the later events. Payload types registered by
+RegisterOutboxEvent are also used by StoredEvent.Event.
+*/
+
+const (
+	VERSION_ANY  int64 = -1 // append without concurrency check
+	VERSION_NONE int64 = 0  // the stream must not exist
+)
+
+var (
+	ErrConcurrency = errors.New("event stream version conflict")
+)
+
+type (
+	// StoredEvent is an event appended to a stream.
+	StoredEvent struct {
+		Position      int64  `db:"position" json:"position"`
+		Stream        string `db:"stream" json:"stream"`
+		Version       int64  `db:"version" json:"version"`
+		EventId       string `db:"event_id" json:"event_id"`
+		Name          string `db:"name" json:"name"`
+		Topic         string `db:"topic" json:"topic"`
+		CorrelationId string `db:"correlation_id" json:"correlation_id"`
+		Headers       string `db:"headers" json:"headers"`
+		Payload       string `db:"payload" json:"payload"`
+		CreatedAt     int64  `db:"created_at" json:"created_at"` // unix milli
+	}
+	// Snapshot is the state of a stream at version.
+	Snapshot struct {
+		Stream    string `db:"stream" json:"stream"`
+		Version   int64  `db:"version" json:"version"`
+		State     string `db:"state" json:"state"` // json
+		CreatedAt int64  `db:"created_at" json:"created_at"`
+	}
+	// EventStore is the append-only store of event streams.
+	EventStore interface {
+		// Append add events to stream if its version equals expected, return the new version.
+		Append(ctx context.Context, stream string, expected int64, events ...Event) (int64, error)
+		// Load return events of stream with version greater than after.
+		Load(ctx context.Context, stream string, after int64) ([]StoredEvent, error)
+		// ReadAll return at most limit events of all streams with position greater than after,
+		// the positions must be committed in order so no event appears below a read one.
+		ReadAll(ctx context.Context, after int64, limit int) ([]StoredEvent, error)
+		// SaveSnapshot replace the snapshot of stream.
+		SaveSnapshot(ctx context.Context, s Snapshot) error
+		// LoadSnapshot return the snapshot of stream, false if none.
+		LoadSnapshot(ctx context.Context, stream string) (Snapshot, bool, error)
+	}
+	// Aggregate is the state rebuilt from a stream.
+	Aggregate[S any] struct {
+		Stream  string
+		Version int64
+		State   S
+	}
+	// MemoryEventStore keep streams in memory, mainly for tests.
+	MemoryEventStore struct {
+		mu        sync.RWMutex
+		all       []StoredEvent
+		streams   map[string][]int // stream -> index in all
+		snapshots map[string]Snapshot
+		positions map[string]int64 // projection checkpoints
+	}
+)
+
+// NewMemoryEventStore return an empty memory store.
+func NewMemoryEventStore() *MemoryEventStore {
+	return &MemoryEventStore{
+		streams:   make(map[string][]int),
+		snapshots: make(map[string]Snapshot),
+		positions: make(map[string]int64),
+	}
+}
+
+// Event rebuild the event, the payload type is registered by RegisterOutboxEvent.
+func (se StoredEvent) Event() (Event, error) {
+	return decodeMessage(OutboxMessage{
+		EventId:       se.EventId,
+		Name:          se.Name,
+		Topic:         se.Topic,
+		CorrelationId: se.CorrelationId,
+		Headers:       se.Headers,
+		Payload:       se.Payload,
+		CreatedAt:     se.CreatedAt,
+	})
+}
+
+// Decode unmarshal the payload into v.
+func (se StoredEvent) Decode(v any) error {
+	return json.Unmarshal([]byte(se.Payload), v)
+}
+
+// LoadAggregate rebuild the state of stream from its latest snapshot and later events.
+func LoadAggregate[S any](ctx context.Context, store EventStore, stream string, apply func(state *S, se StoredEvent) error) (*Aggregate[S], error) {
+	agg := &Aggregate[S]{Stream: stream}
+	snap, found, err := store.LoadSnapshot(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if err = json.Unmarshal([]byte(snap.State), &agg.State); err != nil {
+			return nil, fmt.Errorf("decode snapshot(%s) fail for %w", stream, err)
+		}
+		agg.Version = snap.Version
+	}
+	events, err := store.Load(ctx, stream, agg.Version)
+	if err != nil {
+		return nil, err
+	}
+	for _, se := range events {
+		if err = apply(&agg.State, se); err != nil {
+			return nil, fmt.Errorf("apply event(%s@%d) fail for %w", stream, se.Version, err)
+		}
+		agg.Version = se.Version
+	}
+	return agg, nil
+}
+
+// SaveAggregateSnapshot save the state of aggregate at its version.
+func SaveAggregateSnapshot[S any](ctx context.Context, store EventStore, agg *Aggregate[S]) error {
+	raw, err := json.Marshal(agg.State)
+	if err != nil {
+		return err
+	}
+	return store.SaveSnapshot(ctx, Snapshot{
+		Stream:    agg.Stream,
+		Version:   agg.Version,
+		State:     string(raw),
+		CreatedAt: time.Now().UnixMilli(),
+	})
+}
+
+// storedEvents encode events as the versions after cur of stream.
+func storedEvents(stream string, cur int64, events []Event) ([]StoredEvent, error) {
+	now := time.Now()
+	stored := make([]StoredEvent, 0, len(events))
+	for i, e := range events {
+		m, err := encodeMessage(e, now, "")
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, StoredEvent{
+			Stream:        stream,
+			Version:       cur + int64(i) + 1,
+			EventId:       m.EventId,
+			Name:          m.Name,
+			Topic:         m.Topic,
+			CorrelationId: m.CorrelationId,
+			Headers:       m.Headers,
+			Payload:       m.Payload,
+			CreatedAt:     m.CreatedAt,
+		})
+	}
+	return stored, nil
+}
+
+func checkVersion(stream string, expected, cur int64) error {
+	if expected != VERSION_ANY && expected != cur {
+		return fmt.Errorf("%w: stream(%s) expected version %d but is %d", ErrConcurrency, stream, expected, cur)
+	}
+	return nil
+}
+
+func (ms *MemoryEventStore) Append(ctx context.Context, stream string, expected int64, events ...Event) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	cur := int64(len(ms.streams[stream]))
+	if err := checkVersion(stream, expected, cur); err != nil {
+		return cur, err
+	}
+	stored, err := storedEvents(stream, cur, events)
+	if err != nil {
+		return cur, err
+	}
+	for _, se := range stored {
+		se.Position = int64(len(ms.all)) + 1
+		ms.streams[stream] = append(ms.streams[stream], len(ms.all))
+		ms.all = append(ms.all, se)
+	}
+	return cur + int64(len(stored)), nil
+}
+
+func (ms *MemoryEventStore) Load(ctx context.Context, stream string, after int64) ([]StoredEvent, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	idx := ms.streams[stream]
+	events := make([]StoredEvent, 0, len(idx))
+	for _, i := range idx[min(max(after, 0), int64(len(idx))):] {
+		events = append(events, ms.all[i])
+	}
+	return events, nil
+}
+
+func (ms *MemoryEventStore) ReadAll(ctx context.Context, after int64, limit int) ([]StoredEvent, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	from := min(max(after, 0), int64(len(ms.all)))
+	to := min(from+int64(limit), int64(len(ms.all)))
+	return slices.Clone(ms.all[from:to]), nil
+}
+
+func (ms *MemoryEventStore) SaveSnapshot(ctx context.Context, s Snapshot) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.snapshots[s.Stream] = s
+	return nil
+}
+
+func (ms *MemoryEventStore) LoadSnapshot(ctx context.Context, stream string) (Snapshot, bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	s, found := ms.snapshots[stream]
+	return s, found, nil
+}
+
+func (ms *MemoryEventStore) Checkpoint(ctx context.Context, name string) (int64, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.positions[name], nil
+}
+
+func (ms *MemoryEventStore) SetCheckpoint(ctx context.Context, name string, pos int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.positions[name] = pos
+	return nil
+}
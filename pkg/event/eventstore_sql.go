@@ -0,0 +1,204 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/wendisx/puzzle/pkg/clog"
+	database "github.com/wendisx/puzzle/pkg/db"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+const (
+	_default_stream_table     = "event_stream"
+	_default_snapshot_table   = "event_snapshot"
+	_default_checkpoint_table = "event_checkpoint"
+	_sequence_suffix          = "_seq"
+	_stream_schema            = `create table if not exists %s (
+	position %s,
+	stream varchar(191) not null,
+	version bigint not null,
+	event_id varchar(64) not null,
+	name varchar(191) not null,
+	topic varchar(191) not null,
+	correlation_id varchar(191) not null,
+	headers text not null,
+	payload text not null,
+	created_at bigint not null,
+	unique (stream, version)
+)`
+	_snapshot_schema = `create table if not exists %s (
+	stream varchar(191) not null primary key,
+	version bigint not null,
+	state text not null,
+	created_at bigint not null
+)`
+	_checkpoint_schema = `create table if not exists %s (
+	name varchar(191) not null primary key,
+	position bigint not null
+)`
+	// the single row locked by every append
+	_sequence_schema = `create table if not exists %s (
+	id integer not null primary key,
+	appends bigint not null
+)`
+)
+
+type (
+	// SQLEventStore keep streams, snapshots and projection checkpoints in sql tables, call Migrate to create them.
+	// Appends of all streams are serialized by locking the row of sequence table in their
+	// transactions, otherwise a transaction taking a smaller position could commit after a
+	// greater one was read by ReadAll, and projections would skip it for ever.
+	SQLEventStore struct {
+		db              *sqlx.DB
+		dialect         string
+		table           string
+		snapshotTable   string
+		checkpointTable string
+		sequenceTable   string
+	}
+)
+
+// NewSQLEventStore return a sql store on db.
+func NewSQLEventStore(db *sqlx.DB) (*SQLEventStore, error) {
+	dialect, err := database.Dialect(db)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLEventStore{
+		db:              db,
+		dialect:         dialect,
+		table:           _default_stream_table,
+		snapshotTable:   _default_snapshot_table,
+		checkpointTable: _default_checkpoint_table,
+		sequenceTable:   _default_stream_table + _sequence_suffix,
+	}, nil
+}
+
+// Migrate create the stream, snapshot, checkpoint and sequence tables if not exist.
+func (es *SQLEventStore) Migrate(ctx context.Context) error {
+	pk := "integer primary key autoincrement"
+	switch es.dialect {
+	case database.DIALECT_MYSQL:
+		pk = "bigint not null auto_increment primary key"
+	case database.DIALECT_POSTGRES:
+		pk = "bigserial primary key"
+	}
+	stmts := []string{
+		fmt.Sprintf(_stream_schema, es.table, pk),
+		fmt.Sprintf(_snapshot_schema, es.snapshotTable),
+		fmt.Sprintf(_checkpoint_schema, es.checkpointTable),
+		fmt.Sprintf(_sequence_schema, es.sequenceTable),
+		fmt.Sprintf("insert into %s (id, appends) values (1, 0) on conflict (id) do nothing", es.sequenceTable),
+	}
+	if es.dialect == database.DIALECT_MYSQL {
+		stmts[len(stmts)-1] = fmt.Sprintf("insert ignore into %s (id, appends) values (1, 0)", es.sequenceTable)
+	}
+	for _, stmt := range stmts {
+		if _, err := es.db.ExecContext(ctx, stmt); err != nil {
+			clog.Error(fmt.Sprintf("migrate event store(%s) fail for %s", palette.Red(es.table), err.Error()))
+			return err
+		}
+	}
+	return nil
+}
+
+func (es *SQLEventStore) Append(ctx context.Context, stream string, expected int64, events ...Event) (int64, error) {
+	tx, err := es.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// held until commit, so the positions taken below are committed in order
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("update %s set appends = appends + 1 where id = 1", es.sequenceTable)); err != nil {
+		return 0, err
+	}
+	cur, err := es.version(ctx, tx, stream)
+	if err != nil {
+		return 0, err
+	}
+	if err = checkVersion(stream, expected, cur); err != nil {
+		return cur, err
+	}
+	stored, err := storedEvents(stream, cur, events)
+	if err != nil {
+		return cur, err
+	}
+	sqlStr := fmt.Sprintf(`insert into %s (stream, version, event_id, name, topic, correlation_id, headers, payload, created_at)
+		values (:stream, :version, :event_id, :name, :topic, :correlation_id, :headers, :payload, :created_at)`, es.table)
+	for _, se := range stored {
+		if _, err = tx.NamedExecContext(ctx, sqlStr, se); err != nil {
+			tx.Rollback()
+			// the unique (stream, version) is violated by a concurrent append
+			if now, verr := es.version(ctx, es.db, stream); verr == nil && now != cur {
+				return now, checkVersion(stream, cur, now)
+			}
+			return cur, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return cur, err
+	}
+	return cur + int64(len(stored)), nil
+}
+
+func (es *SQLEventStore) Load(ctx context.Context, stream string, after int64) ([]StoredEvent, error) {
+	var events []StoredEvent
+	sqlStr := es.db.Rebind(fmt.Sprintf("select * from %s where stream = ? and version > ? order by version", es.table))
+	err := es.db.SelectContext(ctx, &events, sqlStr, stream, after)
+	return events, err
+}
+
+func (es *SQLEventStore) ReadAll(ctx context.Context, after int64, limit int) ([]StoredEvent, error) {
+	var events []StoredEvent
+	sqlStr := es.db.Rebind(fmt.Sprintf("select * from %s where position > ? order by position limit ?", es.table))
+	err := es.db.SelectContext(ctx, &events, sqlStr, after, limit)
+	return events, err
+}
+
+func (es *SQLEventStore) SaveSnapshot(ctx context.Context, s Snapshot) error {
+	sqlStr := fmt.Sprintf(`insert into %s (stream, version, state, created_at) values (:stream, :version, :state, :created_at)
+		on conflict (stream) do update set version = excluded.version, state = excluded.state, created_at = excluded.created_at`, es.snapshotTable)
+	if es.dialect == database.DIALECT_MYSQL {
+		sqlStr = fmt.Sprintf(`insert into %s (stream, version, state, created_at) values (:stream, :version, :state, :created_at)
+		on duplicate key update version = values(version), state = values(state), created_at = values(created_at)`, es.snapshotTable)
+	}
+	_, err := es.db.NamedExecContext(ctx, sqlStr, s)
+	return err
+}
+
+func (es *SQLEventStore) LoadSnapshot(ctx context.Context, stream string) (Snapshot, bool, error) {
+	var s Snapshot
+	err := es.db.GetContext(ctx, &s, es.db.Rebind(fmt.Sprintf("select * from %s where stream = ?", es.snapshotTable)), stream)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, false, nil
+	}
+	return s, err == nil, err
+}
+
+func (es *SQLEventStore) Checkpoint(ctx context.Context, name string) (int64, error) {
+	var pos int64
+	err := es.db.GetContext(ctx, &pos, es.db.Rebind(fmt.Sprintf("select position from %s where name = ?", es.checkpointTable)), name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return pos, err
+}
+
+func (es *SQLEventStore) SetCheckpoint(ctx context.Context, name string, pos int64) error {
+	sqlStr := fmt.Sprintf("insert into %s (name, position) values (?, ?) on conflict (name) do update set position = excluded.position", es.checkpointTable)
+	if es.dialect == database.DIALECT_MYSQL {
+		sqlStr = fmt.Sprintf("insert into %s (name, position) values (?, ?) on duplicate key update position = values(position)", es.checkpointTable)
+	}
+	_, err := es.db.ExecContext(ctx, es.db.Rebind(sqlStr), name, pos)
+	return err
+}
+
+func (es *SQLEventStore) version(ctx context.Context, q sqlx.QueryerContext, stream string) (int64, error) {
+	var cur int64
+	err := sqlx.GetContext(ctx, q, &cur, es.db.Rebind(fmt.Sprintf("select coalesce(max(version), 0) from %s where stream = ?", es.table)), stream)
+	return cur, err
+}
@@ -0,0 +1,241 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/wendisx/puzzle/pkg/db/dbtest"
+)
+
+type account struct {
+	Balance int `json:"balance"`
+	Ops     int `json:"ops"`
+}
+
+type deposited struct {
+	Amount int `json:"amount"`
+}
+
+func applyAccount(a *account, se StoredEvent) error {
+	var d deposited
+	if err := se.Decode(&d); err != nil {
+		return err
+	}
+	a.Balance += d.Amount
+	a.Ops++
+	return nil
+}
+
+func test_sql_event_store(t *testing.T) (*SQLEventStore, *sqlx.DB) {
+	h := dbtest.New(t, dbtest.WithoutTx())
+	store, err := NewSQLEventStore(h.DB)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for range 2 {
+		if err = store.Migrate(t.Context()); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	return store, h.DB
+}
+
+func test_event_store(t *testing.T, store EventStore) {
+	ctx := t.Context()
+	dep := func(n int) Event { return NewTypedEvent("account.deposited", deposited{Amount: n}) }
+	v, err := store.Append(ctx, "account-1", VERSION_NONE, dep(10), dep(20))
+	if err != nil || v != 2 {
+		t.Fatalf("expected version 2 but got %d %v", v, err)
+	}
+	// stale writer loses
+	if _, err = store.Append(ctx, "account-1", 1, dep(1)); !errors.Is(err, ErrConcurrency) {
+		t.Fatalf("expected ErrConcurrency but got %v", err)
+	}
+	if _, err = store.Append(ctx, "account-1", VERSION_NONE, dep(1)); !errors.Is(err, ErrConcurrency) {
+		t.Fatalf("expected ErrConcurrency for existing stream but got %v", err)
+	}
+	if _, err = store.Append(ctx, "account-2", VERSION_ANY, dep(5)); err != nil {
+		t.Fatal(err.Error())
+	}
+	if v, err = store.Append(ctx, "account-1", 2, dep(30)); err != nil || v != 3 {
+		t.Fatalf("expected version 3 but got %d %v", v, err)
+	}
+	events, err := store.Load(ctx, "account-1", 1)
+	if err != nil || len(events) != 2 || events[0].Version != 2 || events[1].Version != 3 {
+		t.Fatalf("expected versions 2,3 but got %+v %v", events, err)
+	}
+	all, err := store.ReadAll(ctx, 0, 10)
+	if err != nil || len(all) != 4 || all[2].Stream != "account-2" {
+		t.Fatalf("expected 4 events in append order but got %+v %v", all, err)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Position <= all[i-1].Position {
+			t.Fatalf("expected increasing positions but got %+v", all)
+		}
+	}
+	if page, _ := store.ReadAll(ctx, all[1].Position, 1); len(page) != 1 || page[0].Position != all[2].Position {
+		t.Fatalf("expected the third event but got %+v", page)
+	}
+	// aggregate with snapshot only applies later events
+	agg, err := LoadAggregate(ctx, store, "account-1", applyAccount)
+	if err != nil || agg.Version != 3 || agg.State.Balance != 60 {
+		t.Fatalf("expected balance 60 at version 3 but got %+v %v", agg, err)
+	}
+	if err = SaveAggregateSnapshot(ctx, store, agg); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = store.Append(ctx, "account-1", agg.Version, dep(40)); err != nil {
+		t.Fatal(err.Error())
+	}
+	agg, err = LoadAggregate(ctx, store, "account-1", applyAccount)
+	if err != nil || agg.Version != 4 || agg.State.Balance != 100 || agg.State.Ops != 4 {
+		t.Fatalf("expected balance 100 at version 4 but got %+v %v", agg, err)
+	}
+	snap, found, err := store.LoadSnapshot(ctx, "account-1")
+	if err != nil || !found || snap.Version != 3 {
+		t.Fatalf("expected snapshot at version 3 but got %+v %v %v", snap, found, err)
+	}
+	if _, found, _ = store.LoadSnapshot(ctx, "account-9"); found {
+		t.Fatal("expected no snapshot")
+	}
+}
+
+// test memory event store with versions, conflicts and snapshots [passed]
+func Test_memory_event_store(t *testing.T) {
+	test_event_store(t, NewMemoryEventStore())
+}
+
+// test sql event store with versions, conflicts, snapshots and serialized appends [passed]
+func Test_sql_event_store(t *testing.T) {
+	store, db := test_sql_event_store(t)
+	test_event_store(t, store)
+	var seq []int64
+	if err := db.Select(&seq, "select appends from "+store.sequenceTable); err != nil || len(seq) != 1 || seq[0] == 0 {
+		t.Fatalf("expected the single sequence row locked by appends but got %v %v", seq, err)
+	}
+}
+
+// test stored event rebuilds the registered typed event [passed]
+func Test_stored_event_decode(t *testing.T) {
+	RegisterOutboxEvent[deposited]("account.deposited")
+	store := NewMemoryEventStore()
+	store.Append(t.Context(), "account-1", VERSION_NONE, NewTypedEvent("account.deposited", deposited{Amount: 7}, WithCorrelationID("req-1")))
+	events, _ := store.Load(t.Context(), "account-1", 0)
+	e, err := events[0].Event()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	te, ok := e.(*TypedEvent[deposited])
+	if !ok || te.Payload().Amount != 7 || te.CorrelationID() != "req-1" || te.ID() != events[0].EventId {
+		t.Fatalf("expected typed event but got %#v", e)
+	}
+}
+
+// test projection into a read model table with checkpoint, failure and rebuild [passed]
+func Test_projection_runner(t *testing.T) {
+	ctx := t.Context()
+	store, db := test_sql_event_store(t)
+	db.MustExec("create table account_view (stream varchar(64) primary key, balance integer not null)")
+	fail := false
+	handled := 0
+	runner := NewProjectionRunner(store, WithProjectionBatch(2))
+	p := Projection{
+		Name: "account_view",
+		Handle: func(ctx context.Context, se StoredEvent) error {
+			if fail && se.Version == 3 {
+				return errors.New("boom")
+			}
+			handled++
+			var d deposited
+			if err := se.Decode(&d); err != nil {
+				return err
+			}
+			_, err := db.ExecContext(ctx, `insert into account_view (stream, balance) values (?, ?)
+				on conflict (stream) do update set balance = balance + excluded.balance`, se.Stream, d.Amount)
+			return err
+		},
+		Reset: func(ctx context.Context) error {
+			_, err := db.ExecContext(ctx, "delete from account_view")
+			return err
+		},
+	}
+	if err := runner.Register(p); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := runner.Register(p); !errors.Is(err, ErrProjectionExists) {
+		t.Fatalf("expected ErrProjectionExists but got %v", err)
+	}
+	balance := func(stream string) (n int) {
+		db.Get(&n, "select balance from account_view where stream = ?", stream)
+		return n
+	}
+	for i := 1; i <= 3; i++ {
+		store.Append(ctx, "account-1", VERSION_ANY, NewTypedEvent("account.deposited", deposited{Amount: i * 10}))
+	}
+	store.Append(ctx, "account-2", VERSION_ANY, NewTypedEvent("account.deposited", deposited{Amount: 5}))
+	// a failed event stops the projection at the previous checkpoint
+	fail = true
+	if err := runner.CatchUp(ctx, "account_view"); err == nil {
+		t.Fatal("expected projection error")
+	}
+	if pos, _ := runner.Position(ctx, "account_view"); pos != 2 || balance("account-1") != 30 {
+		t.Fatalf("expected checkpoint 2 and balance 30 but got %d %d", pos, balance("account-1"))
+	}
+	fail = false
+	if err := runner.CatchUp(ctx, "account_view"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if balance("account-1") != 60 || balance("account-2") != 5 || handled != 4 {
+		t.Fatalf("expected balances 60 and 5 but got %d %d after %d", balance("account-1"), balance("account-2"), handled)
+	}
+	// nothing new
+	if n, err := runner.RunOnce(ctx); err != nil || n != 0 {
+		t.Fatalf("expected nothing to run but got %d %v", n, err)
+	}
+	// a new runner continues from the stored checkpoint
+	runner = NewProjectionRunner(store)
+	runner.Register(p)
+	store.Append(ctx, "account-2", VERSION_ANY, NewTypedEvent("account.deposited", deposited{Amount: 1}))
+	if n, err := runner.RunOnce(ctx); err != nil || n != 1 || balance("account-2") != 6 {
+		t.Fatalf("expected one new event but got %d %v %d", n, err, balance("account-2"))
+	}
+	if err := runner.Rebuild(ctx, "account_view"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if balance("account-1") != 60 || balance("account-2") != 6 || handled != 10 {
+		t.Fatalf("expected same balances after rebuild but got %d %d after %d", balance("account-1"), balance("account-2"), handled)
+	}
+	if err := runner.Rebuild(ctx, "missing"); !errors.Is(err, ErrProjectionNotFound) {
+		t.Fatalf("expected ErrProjectionNotFound but got %v", err)
+	}
+}
+
+// test projection runner goroutine [passed]
+func Test_projection_runner_start(t *testing.T) {
+	store := NewMemoryEventStore()
+	seen := make(chan int64, 8)
+	runner := NewProjectionRunner(store, WithProjectionInterval(10*time.Millisecond))
+	runner.Register(Projection{
+		Name: "seen",
+		Handle: func(ctx context.Context, se StoredEvent) error {
+			seen <- se.Position
+			return nil
+		},
+	})
+	store.Append(t.Context(), "s", VERSION_ANY, NewTypedEvent("x", 1), NewTypedEvent("x", 2))
+	if err := runner.Start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := runner.Start(); !errors.Is(err, ErrProjectionRunning) {
+		t.Fatalf("expected ErrProjectionRunning but got %v", err)
+	}
+	defer runner.Stop(context.Background())
+	for want := int64(1); want <= 2; want++ {
+		if got := <-seen; got != want {
+			t.Fatalf("expected position %d but got %d", want, got)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/palette"
+	"github.com/wendisx/puzzle/pkg/util"
+)
+
+/*
+	event.middleware -- [submodule]
+Middleware intercepts events like echo middleware does for requests:
+	publish -- UsePublish wraps the publishing of every event in the caller goroutine.
+	listen  -- UseListen wraps every call of a listener, including retries.
+The first registered middleware is the outermost one. The context passed by
+PublishContext reaches every middleware and listener implementing ContextListener.
+The event is never changed since it may be shared by publishers, the request id of ctx
+(see WithRequestID) is the correlation of an event without one, it reaches listeners
+by RequestID(ctx) and is stored by Outbox.Enqueue and Bridge as the correlation id:
+
+	d := event.DefaultDispatcher()
+	d.UsePublish(event.Recover(), event.Validate(nil))
+	d.UseListen(event.Logging(), event.Timing(nil))
+	d.PublishContext(c.Request().Context(), event.NewTypedEvent("user.created", user))
+*/
+
+type ctxKey uint8
+
+const (
+	_ctx_request_id ctxKey = iota
+	_ctx_listener_id
+	_ctx_bridge_origin
+)
+
+var (
+	ErrInvalidEvent = errors.New("invalid event payload")
+)
+
+type (
+	// Handler handle the event with context.
+	Handler func(ctx context.Context, e Event) error
+	// Middleware wrap the next handler.
+	Middleware func(next Handler) Handler
+	// ContextListener is a listener receiving the context of publisher.
+	ContextListener interface {
+		EventListener
+		ListenContext(ctx context.Context, e Event) error
+	}
+)
+
+// WithRequestID return a copy of ctx carrying the request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, _ctx_request_id, id)
+}
+
+// RequestID return the request id carried by ctx, empty if none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(_ctx_request_id).(string)
+	return id
+}
+
+// ListenerID return the id of subscription being called, only set in listen middleware and listeners.
+func ListenerID(ctx context.Context) string {
+	id, _ := ctx.Value(_ctx_listener_id).(string)
+	return id
+}
+
+// UsePublish add middleware around publishing.
+func (d *Dispatcher) UsePublish(mws ...Middleware) {
+	d.mwMu.Lock()
+	defer d.mwMu.Unlock()
+	d.pubMw.Store(appendMiddlewares(d.pubMw.Load(), mws))
+}
+
+// UseListen add middleware around every listener call.
+func (d *Dispatcher) UseListen(mws ...Middleware) {
+	d.mwMu.Lock()
+	defer d.mwMu.Unlock()
+	d.listenMw.Store(appendMiddlewares(d.listenMw.Load(), mws))
+}
+
+// appendMiddlewares return a copy of old with mws, the chains being called keep the old one.
+func appendMiddlewares(old *[]Middleware, mws []Middleware) *[]Middleware {
+	var next []Middleware
+	if old != nil {
+		next = slices.Clone(*old)
+	}
+	next = append(next, mws...)
+	return &next
+}
+
+func middlewares(p *atomic.Pointer[[]Middleware]) []Middleware {
+	if mws := p.Load(); mws != nil {
+		return *mws
+	}
+	return nil
+}
+
+// publish run h through the publish chain.
+func (d *Dispatcher) publish(ctx context.Context, e Event, h Handler) error {
+	if id := correlationOf(e); id != "" && RequestID(ctx) == "" {
+		ctx = WithRequestID(ctx, id)
+	}
+	h = chain(h, middlewares(&d.pubMw))
+	return h(ctx, e)
+}
+
+func chain(h Handler, mws []Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+func correlationOf(e Event) string {
+	if ce, ok := e.(interface{ CorrelationID() string }); ok {
+		return ce.CorrelationID()
+	}
+	return ""
+}
+
+// correlationIn return the correlation id of e, or the request id of ctx if e has none.
+func correlationIn(ctx context.Context, e Event) string {
+	if id := correlationOf(e); id != "" {
+		return id
+	}
+	return RequestID(ctx)
+}
+
+func nameOf(e Event) string {
+	if ne, ok := e.(interface{ Name() string }); ok && ne.Name() != "" {
+		return ne.Name()
+	}
+	if t := topicOf(e); t != "" {
+		return t
+	}
+	return reflect.TypeOf(e).String()
+}
+
+// Logging log every event with clog, failed ones as error.
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, e Event) error {
+			err := next(ctx, e)
+			where := ""
+			if id := ListenerID(ctx); id != "" {
+				where = fmt.Sprintf(" by listener(%s)", id)
+			}
+			if err != nil {
+				clog.Error(fmt.Sprintf("event(%s)%s request(%s) fail for %s", palette.Red(nameOf(e)), where, RequestID(ctx), err.Error()))
+			} else {
+				clog.Info(fmt.Sprintf("event(%s)%s request(%s) %s", palette.SkyBlue(nameOf(e)), where, RequestID(ctx), palette.Green("ok")))
+			}
+			return err
+		}
+	}
+}
+
+// Timing report the duration of handling, nil report logs it with clog.
+func Timing(report func(ctx context.Context, e Event, cost time.Duration, err error)) Middleware {
+	if report == nil {
+		report = func(ctx context.Context, e Event, cost time.Duration, err error) {
+			clog.Debug(fmt.Sprintf("event(%s) cost %s", palette.SkyBlue(nameOf(e)), cost))
+		}
+	}
+	return func(next Handler) Handler {
+		return func(ctx context.Context, e Event) error {
+			start := time.Now()
+			err := next(ctx, e)
+			report(ctx, e, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// Recover turn panic of the next handler into ErrListenerPanic.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, e Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("%w: %v", ErrListenerPanic, r)
+					clog.Error(fmt.Sprintf("event(%s) panic for %v", palette.Red(nameOf(e)), r))
+				}
+			}()
+			return next(ctx, e)
+		}
+	}
+}
+
+// Validate check struct payload (Record) of event by the `check` tags, invalid events are rejected
+// with ErrInvalidEvent. Nil v use a validator with default rules.
+func Validate(v util.Validator) Middleware {
+	if v == nil {
+		va := util.NewValidator(nil)
+		va.SetupDefaultRules()
+		v = va
+	}
+	return func(next Handler) Handler {
+		return func(ctx context.Context, e Event) error {
+			record := e.Record()
+			t := reflect.TypeOf(record)
+			if t != nil && t.Kind() == reflect.Pointer {
+				if reflect.ValueOf(record).IsNil() {
+					return next(ctx, e)
+				}
+				t = t.Elem()
+			}
+			if t == nil || t.Kind() != reflect.Struct {
+				return next(ctx, e)
+			}
+			if errs := v.Check(record); len(errs) > 0 {
+				return fmt.Errorf("%w: %s", ErrInvalidEvent, errs.Error())
+			}
+			return next(ctx, e)
+		}
+	}
+}
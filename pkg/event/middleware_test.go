@@ -0,0 +1,80 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type (
+	signUp struct {
+		Email string `check:"email"`
+	}
+	ctxListener struct {
+		requests []string
+	}
+)
+
+func (l *ctxListener) Want(e Event) bool {
+	return true
+}
+
+func (l *ctxListener) Listen(e Event) error {
+	return errors.New("context listener should not be called without context")
+}
+
+func (l *ctxListener) ListenContext(ctx context.Context, e Event) error {
+	l.requests = append(l.requests, RequestID(ctx)+"@"+ListenerID(ctx))
+	return nil
+}
+
+// test chain order, request id propagation and validation [passed]
+func Test_event_middleware(t *testing.T) {
+	b := NewBus()
+	l := &ctxListener{}
+	s := b.Subscribe("user.*", l)
+	d := NewDispatcher(WithSource(b.Subscriptions))
+	order := []string{}
+	mark := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, e Event) error {
+				order = append(order, name)
+				return next(ctx, e)
+			}
+		}
+	}
+	var cost time.Duration
+	d.UsePublish(mark("p1"), mark("p2"), Validate(nil))
+	d.UseListen(mark("l1"), Timing(func(ctx context.Context, e Event, c time.Duration, err error) { cost = c }), Logging(), Recover())
+	e := NewTypedEvent("user.signup", signUp{Email: "puzzle@example.com"})
+	if err := d.PublishContext(WithRequestID(t.Context(), "req-1"), e); err != nil {
+		t.Fatal(err.Error())
+	}
+	if want := "p1,p2,l1"; strings.Join(order, ",") != want {
+		t.Errorf("expected %s but got %v", want, order)
+	}
+	if e.CorrelationID() != "" || len(l.requests) != 1 || l.requests[0] != "req-1@"+s.ID() || cost <= 0 {
+		t.Errorf("unexpected propagation %s %v %s", e.CorrelationID(), l.requests, cost)
+	}
+	// the shared event is kept, the codec takes the correlation of ctx
+	data, err := JSONCodec{}.EncodeContext(WithRequestID(t.Context(), "req-1"), e)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if decoded, err := (JSONCodec{}).Decode(data); err != nil || correlationOf(decoded) != "req-1" {
+		t.Errorf("expected encoded correlation req-1 but got %v", err)
+	}
+	err = d.PublishContext(t.Context(), NewTypedEvent("user.signup", &signUp{Email: "invalid"}))
+	if !errors.Is(err, ErrInvalidEvent) || len(l.requests) != 1 {
+		t.Errorf("expected invalid event rejected but got %v", err)
+	}
+	// async listeners keep the request id after the publisher context is canceled
+	ctx, cancle := context.WithCancel(WithRequestID(t.Context(), "req-2"))
+	f := d.PublishAsyncContext(ctx, NewTypedEvent("user.signup", signUp{Email: "async@example.com"}))
+	cancle()
+	if res, err := f.Wait(t.Context()); err != nil || res.Err() != nil || l.requests[1] != "req-2@"+s.ID() {
+		t.Errorf("unexpected async result %v %v %v", err, res.Err(), l.requests)
+	}
+}
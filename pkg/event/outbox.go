@@ -0,0 +1,509 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/wendisx/puzzle/pkg/clog"
+	database "github.com/wendisx/puzzle/pkg/db"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+/*
+	event.outbox -- [submodule]
+Outbox makes publishing atomic with database writes. Enqueue writes the event into
+the outbox table within the caller's transaction, so the event exists if and only
+if the transaction commits:
+
+	tx := database.ToTx(db)
+	database.InsertWithName(...) // business writes
+	ob.Enqueue(ctx, tx, event.NewTypedEvent("user.created", user), event.WithIdempotencyKey(key))
+	tx.Commit()
+
+The relay goroutine started by Start reads due messages in order, rebuilds events by
+the names registered with RegisterOutboxEvent and delivers them with PublishSync of
+the dispatcher. A message is deleted only after all listeners succeed, so delivery
+is at-least-once and listeners should deduplicate by the event id (the idempotency
+key is also in the header `idempotency-key`). A failed message is retried with
+exponential backoff, and moved to the dead-letter table after max attempts.
+Enqueue ignores a message whose idempotency key was ever enqueued, the keys are
+kept in the key table after delivery, PurgeKeys drops the keys older than a retention.
+*/
+
+const (
+	OUTBOX_HEADER_IDEMPOTENCY = "idempotency-key"
+
+	_default_outbox_table    = "event_outbox"
+	_default_dead_table      = "event_dead_letter"
+	_default_outbox_batch    = 100
+	_default_outbox_interval = time.Second
+	_default_outbox_attempts = 5
+	_default_outbox_backoff  = time.Second
+	_default_outbox_max_wait = time.Minute
+	_max_outbox_error        = 1024
+	_outbox_key_suffix       = "_key"
+	_mysql_dup_key_name      = 1061 // ER_DUP_KEYNAME of create index
+	_outbox_schema           = `create table if not exists %s (
+	id %s,
+	event_id varchar(64) not null,
+	idem_key varchar(191) not null unique,
+	name varchar(191) not null,
+	topic varchar(191) not null,
+	correlation_id varchar(191) not null,
+	headers text not null,
+	payload text not null,
+	attempts integer not null default 0,
+	last_error varchar(1024) not null default '',
+	next_at bigint not null,
+	created_at bigint not null
+)`
+	_outbox_key_schema = `create table if not exists %s (
+	idem_key varchar(191) not null primary key,
+	created_at bigint not null
+)`
+)
+
+var (
+	ErrOutboxRunning = errors.New("event outbox relay already running")
+
+	// event name -> decoder of payload
+	_outbox_decoders sync.Map
+)
+
+type (
+	// OutboxMessage is the stored form of an event.
+	OutboxMessage struct {
+		Id            int64  `db:"id" json:"id"`
+		EventId       string `db:"event_id" json:"event_id"`
+		IdemKey       string `db:"idem_key" json:"idem_key"`
+		Name          string `db:"name" json:"name"`
+		Topic         string `db:"topic" json:"topic"`
+		CorrelationId string `db:"correlation_id" json:"correlation_id"`
+		Headers       string `db:"headers" json:"headers"`
+		Payload       string `db:"payload" json:"payload"`
+		Attempts      int    `db:"attempts" json:"attempts"`
+		LastError     string `db:"last_error" json:"last_error"`
+		NextAt        int64  `db:"next_at" json:"next_at"` // unix milli
+		CreatedAt     int64  `db:"created_at" json:"created_at"`
+	}
+	// Functional outbox configuration.
+	OutboxOption func(o *Outbox)
+	// Functional enqueue configuration.
+	EnqueueOption  func(eo *enqueueOptions)
+	enqueueOptions struct {
+		idemKey string
+		delay   time.Duration
+	}
+	// Outbox store events in sql tables and relay them to listeners.
+	Outbox struct {
+		db         *sqlx.DB
+		dialect    string
+		table      string
+		deadTable  string
+		keyTable   string
+		dispatcher *Dispatcher
+		batch      int
+		interval   time.Duration
+		attempts   int
+		backoff    time.Duration
+		maxWait    time.Duration
+		now        func() time.Time
+		mu         sync.Mutex
+		cancel     context.CancelFunc
+		done       chan struct{}
+	}
+	outboxDecoder func(m OutboxMessage, opts []EventOption) (Event, error)
+	// event carrying the metadata used by outbox
+	metaEvent interface {
+		Name() string
+		ID() string
+		Topic() string
+		CorrelationID() string
+		Headers() Headers
+		Timestamp() time.Time
+	}
+)
+
+// WithOutboxTable set the name of outbox table and dead-letter table, the key table is the outbox table with `_key`.
+func WithOutboxTable(table, deadTable string) OutboxOption {
+	return func(o *Outbox) {
+		o.table = table
+		o.deadTable = deadTable
+	}
+}
+
+// WithOutboxDispatcher set the dispatcher delivering relayed events, default DefaultDispatcher.
+func WithOutboxDispatcher(d *Dispatcher) OutboxOption {
+	return func(o *Outbox) {
+		o.dispatcher = d
+	}
+}
+
+// WithOutboxBatch set the max messages relayed in one round.
+func WithOutboxBatch(n int) OutboxOption {
+	return func(o *Outbox) {
+		o.batch = max(n, 1)
+	}
+}
+
+// WithOutboxInterval set the polling interval of relay.
+func WithOutboxInterval(d time.Duration) OutboxOption {
+	return func(o *Outbox) {
+		o.interval = d
+	}
+}
+
+// WithOutboxRetry set the max attempts and exponential backoff of failed messages.
+func WithOutboxRetry(attempts int, backoff, maxWait time.Duration) OutboxOption {
+	return func(o *Outbox) {
+		o.attempts = max(attempts, 1)
+		o.backoff = backoff
+		o.maxWait = maxWait
+	}
+}
+
+// WithIdempotencyKey set the key deduplicating the message, default the event id.
+func WithIdempotencyKey(key string) EnqueueOption {
+	return func(eo *enqueueOptions) {
+		eo.idemKey = key
+	}
+}
+
+// WithDelay make the message due after delay.
+func WithDelay(delay time.Duration) EnqueueOption {
+	return func(eo *enqueueOptions) {
+		eo.delay = delay
+	}
+}
+
+// RegisterOutboxEvent register the payload type of events named name,
+// relayed events are rebuilt as *TypedEvent[T]. Unregistered events are
+// rebuilt as *TypedEvent[json.RawMessage].
+func RegisterOutboxEvent[T any](name string) {
+	_outbox_decoders.Store(name, outboxDecoder(func(m OutboxMessage, opts []EventOption) (Event, error) {
+		var payload T
+		if err := json.Unmarshal([]byte(m.Payload), &payload); err != nil {
+			return nil, err
+		}
+		return NewTypedEvent(m.Name, payload, opts...), nil
+	}))
+}
+
+// NewOutbox return an outbox on db, call Migrate to create its tables.
+func NewOutbox(db *sqlx.DB, opts ...OutboxOption) (*Outbox, error) {
+	dialect, err := database.Dialect(db)
+	if err != nil {
+		return nil, err
+	}
+	o := &Outbox{
+		db:        db,
+		dialect:   dialect,
+		table:     _default_outbox_table,
+		deadTable: _default_dead_table,
+		batch:     _default_outbox_batch,
+		interval:  _default_outbox_interval,
+		attempts:  _default_outbox_attempts,
+		backoff:   _default_outbox_backoff,
+		maxWait:   _default_outbox_max_wait,
+		now:       time.Now,
+	}
+	for _, fn := range opts {
+		fn(o)
+	}
+	if o.dispatcher == nil {
+		o.dispatcher = DefaultDispatcher()
+	}
+	o.keyTable = o.table + _outbox_key_suffix
+	return o, nil
+}
+
+// Migrate create the outbox table, dead-letter table and key table if not exist.
+func (o *Outbox) Migrate(ctx context.Context) error {
+	pk, index := "integer primary key autoincrement", "create index if not exists"
+	switch o.dialect {
+	case database.DIALECT_MYSQL:
+		// mysql has no `create index if not exists`, the duplicate index error is ignored
+		pk, index = "bigint not null auto_increment primary key", "create index"
+	case database.DIALECT_POSTGRES:
+		pk = "bigserial primary key"
+	}
+	stmts := []string{
+		fmt.Sprintf(_outbox_schema, o.table, pk),
+		fmt.Sprintf(_outbox_schema, o.deadTable, pk),
+		fmt.Sprintf(_outbox_key_schema, o.keyTable),
+		fmt.Sprintf("%s %s_next_at on %s (next_at)", index, o.table, o.table),
+	}
+	for _, stmt := range stmts {
+		if _, err := o.db.ExecContext(ctx, stmt); err != nil {
+			var me *mysql.MySQLError
+			if errors.As(err, &me) && me.Number == _mysql_dup_key_name {
+				continue
+			}
+			clog.Error(fmt.Sprintf("migrate outbox(%s) fail for %s", palette.Red(o.table), err.Error()))
+			return err
+		}
+	}
+	return nil
+}
+
+// Enqueue write the event into the outbox within tx, return false if the idempotency key was
+// enqueued before, even if that message is already delivered.
+func (o *Outbox) Enqueue(ctx context.Context, tx *sqlx.Tx, e Event, opts ...EnqueueOption) (bool, error) {
+	m, err := o.message(ctx, e, opts)
+	if err != nil {
+		return false, err
+	}
+	insert, suffix := "insert into", " on conflict (idem_key) do nothing"
+	if o.dialect == database.DIALECT_MYSQL {
+		insert, suffix = "insert ignore into", ""
+	}
+	// the key outlives the message, so a delivered message is not enqueued again
+	res, err := tx.ExecContext(ctx, tx.Rebind(fmt.Sprintf("%s %s (idem_key, created_at) values (?, ?)%s", insert, o.keyTable, suffix)), m.IdemKey, o.now().UnixMilli())
+	if err == nil {
+		var n int64
+		if n, err = res.RowsAffected(); err == nil && n == 0 {
+			return false, nil
+		}
+	}
+	if err == nil {
+		sqlStr := fmt.Sprintf(`insert into %s (event_id, idem_key, name, topic, correlation_id, headers, payload, attempts, last_error, next_at, created_at)
+		values (:event_id, :idem_key, :name, :topic, :correlation_id, :headers, :payload, 0, '', :next_at, :created_at)`, o.table)
+		_, err = tx.NamedExecContext(ctx, sqlStr, m)
+	}
+	if err != nil {
+		clog.Error(fmt.Sprintf("enqueue event(%s) fail for %s", palette.Red(m.Name), err.Error()))
+		return false, err
+	}
+	return true, nil
+}
+
+// PurgeKeys delete the idempotency keys enqueued before, the keys of pending and dead messages
+// are kept, so a dead message can still be requeued.
+func (o *Outbox) PurgeKeys(ctx context.Context, before time.Time) (int64, error) {
+	sqlStr := o.db.Rebind(fmt.Sprintf("delete from %s where created_at < ? and idem_key not in (select idem_key from %s) and idem_key not in (select idem_key from %s)", o.keyTable, o.table, o.deadTable))
+	res, err := o.db.ExecContext(ctx, sqlStr, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Start run the relay goroutine until Stop.
+func (o *Outbox) Start() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.cancel != nil {
+		return ErrOutboxRunning
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	o.cancel, o.done = cancel, make(chan struct{})
+	go o.relay(ctx, o.done)
+	clog.Info(fmt.Sprintf("event outbox(%s) relay started", palette.SkyBlue(o.table)))
+	return nil
+}
+
+// Stop the relay goroutine and wait the current round or ctx done.
+func (o *Outbox) Stop(ctx context.Context) error {
+	o.mu.Lock()
+	cancel, done := o.cancel, o.done
+	o.cancel, o.done = nil, nil
+	o.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (o *Outbox) relay(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for {
+		// keep relaying without waiting while there are full batches.
+		for {
+			n, err := o.RelayOnce(ctx)
+			if err != nil && ctx.Err() == nil {
+				clog.Error(fmt.Sprintf("event outbox(%s) relay fail for %s", palette.Red(o.table), err.Error()))
+			}
+			if err != nil || n < o.batch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce deliver a batch of due messages and return the number of them.
+func (o *Outbox) RelayOnce(ctx context.Context) (int, error) {
+	var msgs []OutboxMessage
+	sqlStr := o.db.Rebind(fmt.Sprintf("select * from %s where next_at <= ? order by id limit ?", o.table))
+	if err := o.db.SelectContext(ctx, &msgs, sqlStr, o.now().UnixMilli(), o.batch); err != nil {
+		return 0, err
+	}
+	for _, m := range msgs {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		e, err := decodeMessage(m)
+		if err == nil {
+			err = o.dispatcher.PublishSyncContext(ctx, e).Err()
+		}
+		if err == nil {
+			err = o.delete(ctx, o.db, o.table, m.Id)
+		} else {
+			err = o.fail(ctx, m, err)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(msgs), nil
+}
+
+// DeadLetters return all messages in the dead-letter table.
+func (o *Outbox) DeadLetters(ctx context.Context) ([]OutboxMessage, error) {
+	var msgs []OutboxMessage
+	err := o.db.SelectContext(ctx, &msgs, fmt.Sprintf("select * from %s order by id", o.deadTable))
+	return msgs, err
+}
+
+// Requeue move the dead-letter message back to the outbox with attempts reset.
+func (o *Outbox) Requeue(ctx context.Context, id int64) error {
+	return o.move(ctx, o.deadTable, o.table, id, 0, "", o.now())
+}
+
+func (o *Outbox) fail(ctx context.Context, m OutboxMessage, cause error) error {
+	attempts := m.Attempts + 1
+	reason := truncate(cause.Error(), _max_outbox_error)
+	if attempts >= o.attempts {
+		clog.Warn(fmt.Sprintf("event(%s) dead after %d attempts for %s", palette.Red(m.EventId), attempts, reason))
+		return o.move(ctx, o.table, o.deadTable, m.Id, attempts, reason, o.now())
+	}
+	wait := o.backoff << (attempts - 1)
+	if wait > o.maxWait || wait < 0 {
+		wait = o.maxWait
+	}
+	sqlStr := o.db.Rebind(fmt.Sprintf("update %s set attempts = ?, last_error = ?, next_at = ? where id = ?", o.table))
+	_, err := o.db.ExecContext(ctx, sqlStr, attempts, reason, o.now().Add(wait).UnixMilli(), m.Id)
+	return err
+}
+
+// move copy the message from table to another one and delete it in a transaction.
+func (o *Outbox) move(ctx context.Context, from, to string, id int64, attempts int, reason string, next time.Time) error {
+	tx, err := o.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	sqlStr := tx.Rebind(fmt.Sprintf(`insert into %s (event_id, idem_key, name, topic, correlation_id, headers, payload, attempts, last_error, next_at, created_at)
+		select event_id, idem_key, name, topic, correlation_id, headers, payload, ?, ?, ?, created_at from %s where id = ?`, to, from))
+	if _, err = tx.ExecContext(ctx, sqlStr, attempts, reason, next.UnixMilli(), id); err != nil {
+		return err
+	}
+	if err = o.delete(ctx, tx, from, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (o *Outbox) delete(ctx context.Context, ext sqlx.ExtContext, table string, id int64) error {
+	_, err := ext.ExecContext(ctx, ext.Rebind(fmt.Sprintf("delete from %s where id = ?", table)), id)
+	return err
+}
+
+func (o *Outbox) message(ctx context.Context, e Event, opts []EnqueueOption) (OutboxMessage, error) {
+	eo := &enqueueOptions{}
+	for _, fn := range opts {
+		fn(eo)
+	}
+	now := o.now()
+	m, err := encodeMessage(e, now, eo.idemKey)
+	m.CorrelationId = correlationIn(ctx, e)
+	m.NextAt = now.Add(eo.delay).UnixMilli()
+	return m, err
+}
+
+// encodeMessage return the stored form of event, the idempotency key default the event id.
+func encodeMessage(e Event, now time.Time, idemKey string) (OutboxMessage, error) {
+	m := OutboxMessage{
+		NextAt:    now.UnixMilli(),
+		CreatedAt: now.UnixMilli(),
+	}
+	headers := Headers{}
+	if me, ok := e.(metaEvent); ok {
+		m.EventId, m.Name, m.Topic, m.CorrelationId = me.ID(), me.Name(), me.Topic(), me.CorrelationID()
+		headers = me.Headers()
+		if headers == nil {
+			headers = Headers{}
+		}
+		m.CreatedAt = me.Timestamp().UnixMilli()
+	}
+	if m.EventId == "" {
+		m.EventId = uuid.NewString()
+	}
+	m.IdemKey = idemKey
+	if m.IdemKey == "" {
+		m.IdemKey = m.EventId
+	}
+	headers[OUTBOX_HEADER_IDEMPOTENCY] = m.IdemKey
+	raw, err := json.Marshal(headers)
+	if err != nil {
+		return m, err
+	}
+	m.Headers = string(raw)
+	if raw, err = json.Marshal(e.Record()); err != nil {
+		return m, err
+	}
+	m.Payload = string(raw)
+	return m, nil
+}
+
+// decodeMessage rebuild the event from stored message.
+func decodeMessage(m OutboxMessage) (Event, error) {
+	var headers Headers
+	if err := json.Unmarshal([]byte(m.Headers), &headers); err != nil {
+		return nil, err
+	}
+	opts := []EventOption{
+		WithEventID(m.EventId),
+		WithTopic(m.Topic),
+		WithCorrelationID(m.CorrelationId),
+		WithTimestamp(time.UnixMilli(m.CreatedAt)),
+	}
+	for k, v := range headers {
+		opts = append(opts, WithHeader(k, v))
+	}
+	if decode, found := _outbox_decoders.Load(m.Name); found {
+		return decode.(outboxDecoder)(m, opts)
+	}
+	return NewTypedEvent(m.Name, json.RawMessage(m.Payload), opts...), nil
+}
+
+// truncate cut s to at most n bytes on a rune boundary.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
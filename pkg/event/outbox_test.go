@@ -0,0 +1,161 @@
+package event
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/wendisx/puzzle/pkg/db/dbtest"
+)
+
+type orderPaid struct {
+	OrderId uint64 `json:"order_id"`
+	Amount  int    `json:"amount"`
+}
+
+func test_outbox(t *testing.T, b *Bus, opts ...OutboxOption) (*Outbox, *dbtest.Harness) {
+	h := dbtest.New(t, dbtest.WithoutTx())
+	opts = append([]OutboxOption{WithOutboxDispatcher(NewDispatcher(WithSource(b.Subscriptions)))}, opts...)
+	ob, err := NewOutbox(h.DB, opts...)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = ob.Migrate(t.Context()); err != nil {
+		t.Fatal(err.Error())
+	}
+	// migrate twice is allowed
+	if err = ob.Migrate(t.Context()); err != nil {
+		t.Fatal(err.Error())
+	}
+	return ob, h
+}
+
+// test enqueue within tx, idempotency and relay [passed]
+func Test_outbox_relay(t *testing.T) {
+	RegisterOutboxEvent[orderPaid]("order.paid")
+	b := NewBus()
+	got := []*TypedEvent[orderPaid]{}
+	SubscribeTypedTo(b, "order.*", func(e *TypedEvent[orderPaid]) error {
+		got = append(got, e)
+		return nil
+	})
+	ob, h := test_outbox(t, b)
+	// rolled back transaction leaves nothing
+	tx := h.DB.MustBegin()
+	if _, err := ob.Enqueue(t.Context(), tx, NewTypedEvent("order.paid", orderPaid{OrderId: 1, Amount: 10})); err != nil {
+		t.Fatal(err.Error())
+	}
+	tx.Rollback()
+	tx = h.DB.MustBegin()
+	for i := range 2 {
+		ok, err := ob.Enqueue(t.Context(), tx, NewTypedEvent("order.paid", orderPaid{OrderId: 2, Amount: 20}, WithCorrelationID("req")), WithIdempotencyKey("order:2"))
+		if err != nil || ok != (i == 0) {
+			t.Fatalf("expected only first enqueue inserted but got %v %v", ok, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if n, err := ob.RelayOnce(t.Context()); err != nil || n != 1 {
+		t.Fatalf("expected 1 relayed but got %d %v", n, err)
+	}
+	if len(got) != 1 || got[0].Payload().Amount != 20 || got[0].CorrelationID() != "req" || got[0].Header(OUTBOX_HEADER_IDEMPOTENCY) != "order:2" {
+		t.Errorf("unexpected relayed events %+v", got)
+	}
+	if n := h.Count(_default_outbox_table); n != 0 {
+		t.Errorf("expected empty outbox but got %d", n)
+	}
+	// the key is kept after delivery until purged
+	enqueue := func() bool {
+		tx := h.DB.MustBegin()
+		defer tx.Commit()
+		ok, err := ob.Enqueue(t.Context(), tx, NewTypedEvent("order.paid", orderPaid{OrderId: 2}), WithIdempotencyKey("order:2"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return ok
+	}
+	if enqueue() {
+		t.Fatal("expected delivered key not enqueued again")
+	}
+	if n, err := ob.PurgeKeys(t.Context(), time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("expected 1 key purged but got %d %v", n, err)
+	}
+	if !enqueue() {
+		t.Fatal("expected purged key enqueued")
+	}
+}
+
+// test retry with backoff, dead letter and requeue [passed]
+func Test_outbox_dead_letter(t *testing.T) {
+	b := NewBus()
+	var calls atomic.Int32
+	fail := true
+	b.Subscribe("job.*", &funcListener{fn: func(e Event) error {
+		calls.Add(1)
+		if fail {
+			// longer than the kept error, in runes of 3 bytes
+			return errors.New("downstream unavailable: " + strings.Repeat("不可用", 200))
+		}
+		return nil
+	}})
+	ob, h := test_outbox(t, b, WithOutboxRetry(3, time.Minute, time.Hour))
+	now := time.Now()
+	ob.now = func() time.Time { return now }
+	tx := h.DB.MustBegin()
+	if _, err := ob.Enqueue(t.Context(), tx, NewTopicEvent("job.sync", 1, map[string]int{"n": 1}), WithIdempotencyKey("job:1")); err != nil {
+		t.Fatal(err.Error())
+	}
+	tx.Commit()
+	for i := range 3 {
+		if _, err := ob.RelayOnce(t.Context()); err != nil {
+			t.Fatal(err.Error())
+		}
+		// not due before backoff
+		if n, _ := ob.RelayOnce(t.Context()); n != 0 {
+			t.Fatalf("expected backoff after attempt %d", i+1)
+		}
+		now = now.Add(time.Duration(1<<i) * time.Minute)
+	}
+	dead, err := ob.DeadLetters(t.Context())
+	if err != nil || len(dead) != 1 || dead[0].Attempts != 3 || dead[0].LastError == "" {
+		t.Fatalf("expected 1 dead letter but got %+v %v", dead, err)
+	}
+	if reason := dead[0].LastError; len(reason) > _max_outbox_error || !utf8.ValidString(reason) {
+		t.Fatalf("expected error cut on a rune but got %d bytes", len(reason))
+	}
+	// the key of dead message is kept, so it is neither enqueued again nor breaks the requeue
+	if n, err := ob.PurgeKeys(t.Context(), now.Add(time.Hour)); err != nil || n != 0 {
+		t.Fatalf("expected no key purged but got %d %v", n, err)
+	}
+	tx = h.DB.MustBegin()
+	if ok, err := ob.Enqueue(t.Context(), tx, NewTopicEvent("job.sync", 1, map[string]int{"n": 1}), WithIdempotencyKey("job:1")); err != nil || ok {
+		t.Fatalf("expected dead key not enqueued again but got %v %v", ok, err)
+	}
+	tx.Commit()
+	fail = false
+	if err = ob.Requeue(t.Context(), dead[0].Id); err != nil {
+		t.Fatal(err.Error())
+	}
+	if n, err := ob.RelayOnce(t.Context()); err != nil || n != 1 || calls.Load() != 4 {
+		t.Errorf("expected requeued message delivered but got %d %v %d", n, err, calls.Load())
+	}
+	if n := h.Count(_default_dead_table) + h.Count(_default_outbox_table); n != 0 {
+		t.Errorf("expected empty tables but got %d", n)
+	}
+}
+
+type funcListener struct {
+	fn func(e Event) error
+}
+
+func (l *funcListener) Want(e Event) bool {
+	return true
+}
+
+func (l *funcListener) Listen(e Event) error {
+	return l.fn(e)
+}
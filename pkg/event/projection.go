@@ -0,0 +1,286 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+/*
+	event.projection -- [submodule]
+ProjectionRunner replays the events of an EventStore in global order into read models.
+Every projection has its own checkpoint, the position of the last handled event, so a
+restarted runner continues where it stopped and a new projection starts from zero:
+
+	runner := event.NewProjectionRunner(store)
+	runner.Register(event.Projection{
+		Name:   "order_summary",
+		Handle: func(ctx context.Context, se event.StoredEvent) error { ... upsert the row ... },
+		Reset:  func(ctx context.Context) error { ... truncate the table ... },
+	})
+	runner.Start()
+
+The checkpoint is saved after every handled event, a crash between the two may handle
+an event again, so handlers should be idempotent (upsert by the stream and version).
+A failed event stops its projection until the next round, other projections go on.
+Rebuild resets the read model and replays the projection from zero.
+*/
+
+const (
+	_default_projection_batch    = 100
+	_default_projection_interval = time.Second
+)
+
+var (
+	ErrProjectionExists   = errors.New("event projection already registered")
+	ErrProjectionNotFound = errors.New("event projection not found")
+	ErrProjectionRunning  = errors.New("event projection runner already running")
+)
+
+type (
+	// CheckpointStore keep the position of every projection, both memory and sql event store implement it.
+	CheckpointStore interface {
+		// Checkpoint return the position of projection, 0 if none.
+		Checkpoint(ctx context.Context, name string) (int64, error)
+		SetCheckpoint(ctx context.Context, name string, pos int64) error
+	}
+	// Projection build a read model from events.
+	Projection struct {
+		Name   string
+		Handle func(ctx context.Context, se StoredEvent) error
+		// Reset clear the read model before rebuild, nil for nothing to clear.
+		Reset func(ctx context.Context) error
+	}
+	// Functional projection runner configuration.
+	ProjectionOption func(pr *ProjectionRunner)
+	// ProjectionRunner feed events of the store to projections.
+	ProjectionRunner struct {
+		store       EventStore
+		checkpoints CheckpointStore
+		batch       int
+		interval    time.Duration
+		mu          sync.Mutex
+		projections []Projection
+		running     map[string]*sync.Mutex // serialize runs of one projection
+		cancel      context.CancelFunc
+		done        chan struct{}
+	}
+)
+
+// WithCheckpoints set where checkpoints are kept, default to the event store itself.
+func WithCheckpoints(cs CheckpointStore) ProjectionOption {
+	return func(pr *ProjectionRunner) {
+		pr.checkpoints = cs
+	}
+}
+
+// WithProjectionBatch set the max events read at once.
+func WithProjectionBatch(n int) ProjectionOption {
+	return func(pr *ProjectionRunner) {
+		pr.batch = max(n, 1)
+	}
+}
+
+// WithProjectionInterval set the polling interval of the runner.
+func WithProjectionInterval(d time.Duration) ProjectionOption {
+	return func(pr *ProjectionRunner) {
+		pr.interval = d
+	}
+}
+
+// NewProjectionRunner return a runner on store, a store not implementing CheckpointStore
+// needs WithCheckpoints otherwise checkpoints are kept in memory.
+func NewProjectionRunner(store EventStore, opts ...ProjectionOption) *ProjectionRunner {
+	pr := &ProjectionRunner{
+		store:    store,
+		batch:    _default_projection_batch,
+		interval: _default_projection_interval,
+		running:  make(map[string]*sync.Mutex),
+	}
+	for _, opt := range opts {
+		opt(pr)
+	}
+	if pr.checkpoints == nil {
+		if cs, ok := store.(CheckpointStore); ok {
+			pr.checkpoints = cs
+		} else {
+			pr.checkpoints = NewMemoryEventStore()
+		}
+	}
+	return pr
+}
+
+// Register add the projection, its name must be unique.
+func (pr *ProjectionRunner) Register(p Projection) error {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if _, found := pr.running[p.Name]; found {
+		return fmt.Errorf("%w: %s", ErrProjectionExists, p.Name)
+	}
+	pr.projections = append(pr.projections, p)
+	pr.running[p.Name] = &sync.Mutex{}
+	return nil
+}
+
+// Position return the checkpoint of projection.
+func (pr *ProjectionRunner) Position(ctx context.Context, name string) (int64, error) {
+	return pr.checkpoints.Checkpoint(ctx, name)
+}
+
+// RunOnce feed at most one batch to every projection, return the number of handled events.
+func (pr *ProjectionRunner) RunOnce(ctx context.Context) (int, error) {
+	pr.mu.Lock()
+	projections := append([]Projection(nil), pr.projections...)
+	pr.mu.Unlock()
+	total := 0
+	var errs []error
+	for _, p := range projections {
+		n, err := pr.run(ctx, p)
+		total += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return total, errors.Join(errs...)
+}
+
+// CatchUp feed the projection until it reaches the end of the store.
+func (pr *ProjectionRunner) CatchUp(ctx context.Context, name string) error {
+	p, err := pr.projection(name)
+	if err != nil {
+		return err
+	}
+	for {
+		n, err := pr.run(ctx, p)
+		if err != nil || n < pr.batch {
+			return err
+		}
+	}
+}
+
+// Rebuild reset the read model and checkpoint of projection, then replay all events.
+func (pr *ProjectionRunner) Rebuild(ctx context.Context, name string) error {
+	p, err := pr.projection(name)
+	if err != nil {
+		return err
+	}
+	lock := pr.lock(name)
+	lock.Lock()
+	if p.Reset != nil {
+		err = p.Reset(ctx)
+	}
+	if err == nil {
+		err = pr.checkpoints.SetCheckpoint(ctx, name, 0)
+	}
+	lock.Unlock()
+	if err != nil {
+		clog.Error(fmt.Sprintf("reset projection(%s) fail for %s", palette.Red(name), err.Error()))
+		return err
+	}
+	clog.Info(fmt.Sprintf("projection(%s) rebuilding from zero", palette.SkyBlue(name)))
+	return pr.CatchUp(ctx, name)
+}
+
+// Start run the runner goroutine until Stop.
+func (pr *ProjectionRunner) Start() error {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if pr.cancel != nil {
+		return ErrProjectionRunning
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pr.cancel, pr.done = cancel, make(chan struct{})
+	go pr.loop(ctx, pr.done)
+	clog.Info(fmt.Sprintf("event projection runner started with %s projections", palette.SkyBlue(len(pr.projections))))
+	return nil
+}
+
+// Stop the runner goroutine and wait the current round or ctx done.
+func (pr *ProjectionRunner) Stop(ctx context.Context) error {
+	pr.mu.Lock()
+	cancel, done := pr.cancel, pr.done
+	pr.cancel, pr.done = nil, nil
+	pr.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (pr *ProjectionRunner) loop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(pr.interval)
+	defer ticker.Stop()
+	for {
+		// keep running without waiting while some projection is behind.
+		for {
+			n, err := pr.RunOnce(ctx)
+			if err != nil && ctx.Err() == nil {
+				clog.Error(fmt.Sprintf("event projection run fail for %s", err.Error()))
+			}
+			if n == 0 || ctx.Err() != nil {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run feed one batch to p and save its checkpoint after every event.
+func (pr *ProjectionRunner) run(ctx context.Context, p Projection) (int, error) {
+	lock := pr.lock(p.Name)
+	lock.Lock()
+	defer lock.Unlock()
+	pos, err := pr.checkpoints.Checkpoint(ctx, p.Name)
+	if err != nil {
+		return 0, err
+	}
+	events, err := pr.store.ReadAll(ctx, pos, pr.batch)
+	if err != nil {
+		return 0, err
+	}
+	for i, se := range events {
+		if err = ctx.Err(); err != nil {
+			return i, err
+		}
+		if err = p.Handle(ctx, se); err != nil {
+			return i, fmt.Errorf("projection(%s) handle event(%s@%d) fail for %w", p.Name, se.Stream, se.Version, err)
+		}
+		if err = pr.checkpoints.SetCheckpoint(ctx, p.Name, se.Position); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
+
+func (pr *ProjectionRunner) projection(name string) (Projection, error) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	for _, p := range pr.projections {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Projection{}, fmt.Errorf("%w: %s", ErrProjectionNotFound, name)
+}
+
+func (pr *ProjectionRunner) lock(name string) *sync.Mutex {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return pr.running[name]
+}
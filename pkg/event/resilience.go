@@ -0,0 +1,356 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+/*
+	event.resilience -- [submodule]
+Every subscription of a bus is delivered with its retry policy and circuit breaker:
+	retry   -- a failed Listen is called again up to max attempts, waiting an exponential
+	           backoff with jitter between attempts (in the worker of the listener).
+	dead    -- the event failed after all attempts is put into the dead-letter sink of
+	           the bus, which can be inspected and replayed.
+	breaker -- after threshold consecutive failed deliveries the listener is suspended
+	           for cooldown, events arriving meanwhile go to the dead-letter sink
+	           directly. After cooldown the breaker is half open, a single delivery is
+	           let through as the probe and the others are still suspended, the probe
+	           closes the breaker if it succeeds or opens it again for another cooldown.
+Counters of delivered, failed, retried, dead-lettered and suspended events are kept
+per subscription and per bus.
+*/
+
+const (
+	_default_dead_letters      = 1024
+	_default_breaker_threshold = 10
+	_default_breaker_cooldown  = 30 * time.Second
+)
+
+var (
+	ErrListenerSuspended = errors.New("event listener suspended")
+)
+
+type (
+	// RetryPolicy decide how a failed listener is called again.
+	RetryPolicy struct {
+		MaxAttempts int           // total attempts, 1 means no retry
+		Backoff     time.Duration // wait before the second attempt
+		MaxBackoff  time.Duration // upper bound of wait, 0 means no bound
+		Jitter      float64       // [0, 1], wait is reduced by a random fraction up to jitter
+	}
+	// DeadLetter record an event which failed to be delivered to a listener.
+	DeadLetter struct {
+		Subscription *Subscription
+		Event        Event
+		Err          error
+		Attempts     int
+		At           time.Time
+	}
+	// DeadLetterSink receive dead letters of a bus.
+	DeadLetterSink interface {
+		Put(dl DeadLetter)
+	}
+	// MemoryDeadLetters keep the latest dead letters in memory.
+	MemoryDeadLetters struct {
+		mu   sync.Mutex
+		cap  int
+		list []DeadLetter
+	}
+	// Metrics is the snapshot of delivery counters.
+	Metrics struct {
+		Delivered    uint64 `json:"delivered"`
+		Failed       uint64 `json:"failed"`
+		Retried      uint64 `json:"retried"`
+		DeadLettered uint64 `json:"dead_lettered"`
+		Suspended    uint64 `json:"suspended"`
+	}
+	// Functional bus configuration.
+	BusOption func(b *Bus)
+	counters  struct {
+		delivered    atomic.Uint64
+		failed       atomic.Uint64
+		retried      atomic.Uint64
+		deadLettered atomic.Uint64
+		suspended    atomic.Uint64
+	}
+	breaker struct {
+		mu        sync.Mutex
+		threshold int
+		cooldown  time.Duration
+		failures  int
+		openUntil time.Time
+		tripped   bool // open or half open
+		probing   bool // the probe of half open is in flight
+	}
+)
+
+// NoRetry call the listener only once.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// WithRetry set the retry policy of subscription.
+func WithRetry(p RetryPolicy) SubscribeOption {
+	return func(s *Subscription) {
+		s.retry = p
+	}
+}
+
+// WithBreaker set the circuit breaker of subscription, threshold 0 disable it.
+func WithBreaker(threshold int, cooldown time.Duration) SubscribeOption {
+	return func(s *Subscription) {
+		s.breaker = &breaker{threshold: threshold, cooldown: cooldown}
+	}
+}
+
+// WithDefaultRetry set the retry policy of subscriptions without WithRetry.
+func WithDefaultRetry(p RetryPolicy) BusOption {
+	return func(b *Bus) {
+		b.retry = p
+	}
+}
+
+// WithDefaultBreaker set the circuit breaker of subscriptions without WithBreaker.
+func WithDefaultBreaker(threshold int, cooldown time.Duration) BusOption {
+	return func(b *Bus) {
+		b.threshold, b.cooldown = threshold, cooldown
+	}
+}
+
+// WithDeadLetters set the dead-letter sink of bus, nil drop dead letters.
+func WithDeadLetters(sink DeadLetterSink) BusOption {
+	return func(b *Bus) {
+		b.deadLetters = sink
+	}
+}
+
+// NewMemoryDeadLetters return a sink keeping the latest cap dead letters.
+func NewMemoryDeadLetters(cap int) *MemoryDeadLetters {
+	return &MemoryDeadLetters{cap: max(cap, 1)}
+}
+
+// Delay return the wait after the failed attempt (start from 1).
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	if p.Backoff <= 0 {
+		return 0
+	}
+	wait := p.Backoff
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if wait <= 0 || (p.MaxBackoff > 0 && wait >= p.MaxBackoff) {
+			wait = p.MaxBackoff
+			break
+		}
+	}
+	if p.Jitter > 0 {
+		wait -= time.Duration(rand.Float64() * min(p.Jitter, 1) * float64(wait))
+	}
+	return wait
+}
+
+func (m *MemoryDeadLetters) Put(dl DeadLetter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.list) >= m.cap {
+		m.list = m.list[1:]
+	}
+	m.list = append(m.list, dl)
+}
+
+// List return a copy of dead letters in arriving order.
+func (m *MemoryDeadLetters) List() []DeadLetter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.list)
+}
+
+// Len return the number of dead letters.
+func (m *MemoryDeadLetters) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.list)
+}
+
+// Replay deliver all dead letters to their listeners again (without retry and breaker),
+// the delivered ones are removed and the errors of others are returned.
+func (m *MemoryDeadLetters) Replay() error {
+	m.mu.Lock()
+	list := m.list
+	m.list = nil
+	m.mu.Unlock()
+	var errs []error
+	for _, dl := range list {
+		err := callListener(context.Background(), dl.Subscription, dl.Event)
+		if err == nil {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("listener(%s): %w", dl.Subscription.id, err))
+		dl.Err, dl.Attempts, dl.At = err, dl.Attempts+1, time.Now()
+		m.Put(dl)
+	}
+	return errors.Join(errs...)
+}
+
+// DeadLetters return the dead-letter sink of bus.
+func (b *Bus) DeadLetters() DeadLetterSink {
+	return b.deadLetters
+}
+
+// Metrics return the delivery counters of all subscriptions of bus.
+func (b *Bus) Metrics() Metrics {
+	return b.counters.snapshot()
+}
+
+// Metrics return the delivery counters of subscription.
+func (s *Subscription) Metrics() Metrics {
+	return s.counters.snapshot()
+}
+
+// Suspended report whether the breaker of subscription is open.
+func (s *Subscription) Suspended() bool {
+	if s.breaker == nil {
+		return false
+	}
+	s.breaker.mu.Lock()
+	defer s.breaker.mu.Unlock()
+	return time.Now().Before(s.breaker.openUntil)
+}
+
+// deliver call listener by h with the retry policy and circuit breaker of subscription.
+// Subscriptions not from a bus are called only once. The backoff gives up when ctx is done,
+// the event is dead-lettered with the attempts made and ctx.Err() is returned.
+func (s *Subscription) deliver(ctx context.Context, e Event, h Handler) error {
+	if s.bus == nil {
+		return h(ctx, e)
+	}
+	allowed, probe := true, false
+	if s.breaker != nil {
+		allowed, probe = s.breaker.allow()
+	}
+	if !allowed {
+		err := fmt.Errorf("%w: %s", ErrListenerSuspended, s.id)
+		s.count(func(c *counters) { c.suspended.Add(1) })
+		s.deadLetter(e, err, 0)
+		return err
+	}
+	attempts := max(s.retry.MaxAttempts, 1)
+	var err, cancelled error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = h(ctx, e); err == nil {
+			break
+		}
+		if attempt == attempts {
+			break
+		}
+		s.count(func(c *counters) { c.retried.Add(1) })
+		if cancelled = waitRetry(ctx, s.retry.Delay(attempt)); cancelled != nil {
+			attempts = attempt
+			break
+		}
+	}
+	if s.breaker != nil && s.breaker.done(err == nil, probe) {
+		clog.Warn(fmt.Sprintf("listener(%s) suspended for %s", palette.Red(s.id), s.breaker.cooldown))
+	}
+	if err == nil {
+		s.count(func(c *counters) { c.delivered.Add(1) })
+		return nil
+	}
+	s.count(func(c *counters) { c.failed.Add(1) })
+	s.deadLetter(e, err, attempts)
+	if cancelled != nil {
+		return cancelled
+	}
+	return err
+}
+
+// waitRetry wait d before the next attempt, ctx.Err() if ctx is done first.
+func waitRetry(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (s *Subscription) deadLetter(e Event, err error, attempts int) {
+	if s.bus.deadLetters == nil {
+		return
+	}
+	s.bus.deadLetters.Put(DeadLetter{Subscription: s, Event: e, Err: err, Attempts: attempts, At: time.Now()})
+	s.count(func(c *counters) { c.deadLettered.Add(1) })
+}
+
+// count update counters of both subscription and its bus.
+func (s *Subscription) count(fn func(c *counters)) {
+	fn(&s.counters)
+	fn(&s.bus.counters)
+}
+
+func (c *counters) snapshot() Metrics {
+	return Metrics{
+		Delivered:    c.delivered.Load(),
+		Failed:       c.failed.Load(),
+		Retried:      c.retried.Load(),
+		DeadLettered: c.deadLettered.Load(),
+		Suspended:    c.suspended.Load(),
+	}
+}
+
+// allow report whether the listener can be called, and whether the call is the single
+// probe of half open breaker after cooldown.
+func (b *breaker) allow() (bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 || !b.tripped {
+		return true, false
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+// done record a delivery and return true if the breaker is opened by it. While tripped
+// only the probe decides, the deliveries let through before opening are ignored.
+func (b *breaker) done(ok, probe bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 {
+		return false
+	}
+	if b.tripped {
+		if !probe {
+			return false
+		}
+		b.probing = false
+		if ok {
+			b.tripped, b.failures = false, 0
+			return false
+		}
+		b.openUntil = time.Now().Add(b.cooldown)
+		return true
+	}
+	if ok {
+		b.failures = 0
+		return false
+	}
+	b.failures += 1
+	if b.failures < b.threshold {
+		return false
+	}
+	b.tripped, b.failures = true, 0
+	b.openUntil = time.Now().Add(b.cooldown)
+	return true
+}
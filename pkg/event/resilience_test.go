@@ -0,0 +1,162 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// test backoff grows and is bounded [passed]
+func Test_retry_delay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i, w := range want {
+		if d := p.Delay(i + 1); d != w*time.Millisecond {
+			t.Errorf("attempt %d expected %s but got %s", i+1, w*time.Millisecond, d)
+		}
+	}
+	p.Jitter = 0.5
+	for i := range 100 {
+		if d := p.Delay(2); d < 10*time.Millisecond || d > 20*time.Millisecond {
+			t.Fatalf("round %d jitter out of range %s", i, d)
+		}
+	}
+}
+
+// test retry, dead letter, breaker and replay [passed]
+func Test_listener_resilience(t *testing.T) {
+	sink := NewMemoryDeadLetters(10)
+	b := NewBus(WithDeadLetters(sink), WithDefaultBreaker(2, time.Hour))
+	calls, fail := 0, 3
+	s := b.Subscribe("job.run", &funcListener{fn: func(e Event) error {
+		calls += 1
+		if calls <= fail {
+			return errors.New("flaky")
+		}
+		return nil
+	}}, WithRetry(RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}))
+	d := NewDispatcher(WithSource(b.Subscriptions))
+	// 1st: fail twice, dead letter
+	if err := d.Publish(NewTopicEvent("job.run", 1, nil)); err == nil {
+		t.Fatal("expected first delivery fail")
+	}
+	// 2nd: fail once then succeed
+	if err := d.Publish(NewTopicEvent("job.run", 2, nil)); err != nil {
+		t.Fatal(err.Error())
+	}
+	if m := s.Metrics(); m.Delivered != 1 || m.Failed != 1 || m.Retried != 2 || m.DeadLettered != 1 {
+		t.Errorf("unexpected metrics %+v", m)
+	}
+	// open the breaker by two failed deliveries
+	calls, fail = 0, 4
+	for range 2 {
+		d.Publish(NewTopicEvent("job.run", 3, nil))
+	}
+	if !s.Suspended() {
+		t.Fatal("expected listener suspended")
+	}
+	if err := d.Publish(NewTopicEvent("job.run", 4, nil)); !errors.Is(err, ErrListenerSuspended) || calls != 4 {
+		t.Errorf("expected suspended without calling but got %v after %d calls", err, calls)
+	}
+	if m := b.Metrics(); m.Suspended != 1 || m.DeadLettered != 4 {
+		t.Errorf("unexpected bus metrics %+v", m)
+	}
+	if err := sink.Replay(); err != nil || sink.Len() != 0 || calls != 8 {
+		t.Errorf("expected all dead letters replayed but got %v, %d left after %d calls", err, sink.Len(), calls)
+	}
+}
+
+// test half open breaker let a single probe through [passed]
+func Test_breaker_half_open(t *testing.T) {
+	b := NewBus(WithDefaultBreaker(1, 20*time.Millisecond))
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fail := true
+	s := b.Subscribe("job.run", &funcListener{fn: func(e Event) error {
+		calls.Add(1)
+		if fail {
+			return errors.New("broken")
+		}
+		<-release
+		return nil
+	}})
+	d := NewDispatcher(WithSource(b.Subscriptions))
+	d.Publish(NewTopicEvent("job.run", 1, nil))
+	if !s.Suspended() {
+		t.Fatal("expected listener suspended")
+	}
+	// the failed probe opens it again
+	time.Sleep(30 * time.Millisecond)
+	d.Publish(NewTopicEvent("job.run", 2, nil))
+	if !s.Suspended() || calls.Load() != 2 {
+		t.Fatalf("expected reopened by probe after %d calls", calls.Load())
+	}
+	time.Sleep(30 * time.Millisecond)
+	fail = false
+	probed := make(chan error)
+	go func() {
+		probed <- d.Publish(NewTopicEvent("job.run", 3, nil))
+	}()
+	for calls.Load() != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	// others are suspended while the probe is in flight
+	for range 5 {
+		if err := d.Publish(NewTopicEvent("job.run", 4, nil)); !errors.Is(err, ErrListenerSuspended) {
+			t.Fatalf("expected suspended during probe but got %v", err)
+		}
+	}
+	close(release)
+	if err := <-probed; err != nil || calls.Load() != 3 {
+		t.Fatalf("expected single probe succeed but got %v after %d calls", err, calls.Load())
+	}
+	if err := d.Publish(NewTopicEvent("job.run", 5, nil)); err != nil {
+		t.Fatalf("expected closed breaker but got %v", err)
+	}
+}
+
+// test the retry backoff gives up when ctx is done [passed]
+func Test_retry_cancel(t *testing.T) {
+	sink := NewMemoryDeadLetters(10)
+	b := NewBus(WithDeadLetters(sink))
+	var calls atomic.Int32
+	b.Subscribe("job.run", &funcListener{fn: func(e Event) error {
+		calls.Add(1)
+		return errors.New("flaky")
+	}}, WithRetry(RetryPolicy{MaxAttempts: 3, Backoff: time.Hour}))
+	d := NewDispatcher(WithSource(b.Subscriptions))
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if err := d.PublishSyncContext(ctx, NewTopicEvent("job.run", 1, nil)).Err(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded but got %v", err)
+	}
+	if time.Since(begin) > time.Second || calls.Load() != 1 {
+		t.Fatalf("expected backoff given up after 1 call but got %d calls in %s", calls.Load(), time.Since(begin))
+	}
+	if dl := sink.List(); len(dl) != 1 || dl[0].Attempts != 1 {
+		t.Fatalf("expected dead letter of 1 attempt but got %+v", dl)
+	}
+	// the queued delivery gives up when Close does
+	d = NewDispatcher(WithSource(b.Subscriptions))
+	if err := d.Fire(NewTopicEvent("job.run", 2, nil)); err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx, cancel = context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	if err := d.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected close interrupted but got %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		d.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected workers exit after close gave up")
+	}
+}
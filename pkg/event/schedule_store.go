@@ -0,0 +1,217 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/wendisx/puzzle/pkg/clog"
+	database "github.com/wendisx/puzzle/pkg/db"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+const (
+	_default_schedule_table = "event_schedule"
+	_default_job_table      = "event_job"
+	_default_redis_prefix   = "puzzle:schedule:"
+	_job_schema             = `create table if not exists %s (
+	name varchar(191) not null primary key,
+	last_run bigint not null
+)`
+
+	// renew the lock only if still held by the same token
+	_lua_lock_renew = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+	_lua_lock_free  = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+)
+
+type (
+	// SQLScheduleStore keep schedules in sql tables, call Migrate to create them.
+	SQLScheduleStore struct {
+		db       *sqlx.DB
+		dialect  string
+		table    string
+		jobTable string
+	}
+	// RedisScheduleStore keep schedules in redis with keys prefixed by prefix.
+	RedisScheduleStore struct {
+		rc     redis.Cmdable
+		prefix string
+	}
+	// RedisLocker is a Locker by `SET NX PX`, every instance has its own token.
+	RedisLocker struct {
+		rc    redis.Cmdable
+		token string
+	}
+)
+
+// NewSQLScheduleStore return a sql store on db.
+func NewSQLScheduleStore(db *sqlx.DB) (*SQLScheduleStore, error) {
+	dialect, err := database.Dialect(db)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLScheduleStore{
+		db:       db,
+		dialect:  dialect,
+		table:    _default_schedule_table,
+		jobTable: _default_job_table,
+	}, nil
+}
+
+// Migrate create the schedule table and job table if not exist.
+func (ss *SQLScheduleStore) Migrate(ctx context.Context) error {
+	pk := "integer primary key autoincrement"
+	switch ss.dialect {
+	case database.DIALECT_MYSQL:
+		pk = "bigint not null auto_increment primary key"
+	case database.DIALECT_POSTGRES:
+		pk = "bigserial primary key"
+	}
+	for _, stmt := range []string{fmt.Sprintf(_outbox_schema, ss.table, pk), fmt.Sprintf(_job_schema, ss.jobTable)} {
+		if _, err := ss.db.ExecContext(ctx, stmt); err != nil {
+			clog.Error(fmt.Sprintf("migrate schedule(%s) fail for %s", palette.Red(ss.table), err.Error()))
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *SQLScheduleStore) Save(ctx context.Context, m OutboxMessage) error {
+	_, err := ss.db.NamedExecContext(ctx, fmt.Sprintf(`insert into %s (event_id, idem_key, name, topic, correlation_id, headers, payload, attempts, last_error, next_at, created_at)
+		values (:event_id, :idem_key, :name, :topic, :correlation_id, :headers, :payload, 0, '', :next_at, :created_at)`, ss.table), m)
+	return err
+}
+
+func (ss *SQLScheduleStore) Due(ctx context.Context, now time.Time, limit int) ([]OutboxMessage, error) {
+	var msgs []OutboxMessage
+	sqlStr := ss.db.Rebind(fmt.Sprintf("select * from %s where next_at <= ? order by next_at, id limit ?", ss.table))
+	err := ss.db.SelectContext(ctx, &msgs, sqlStr, now.UnixMilli(), limit)
+	return msgs, err
+}
+
+func (ss *SQLScheduleStore) Remove(ctx context.Context, eventId string) (bool, error) {
+	res, err := ss.db.ExecContext(ctx, ss.db.Rebind(fmt.Sprintf("delete from %s where event_id = ?", ss.table)), eventId)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (ss *SQLScheduleStore) LastRun(ctx context.Context, job string) (time.Time, bool, error) {
+	var at int64
+	err := ss.db.GetContext(ctx, &at, ss.db.Rebind(fmt.Sprintf("select last_run from %s where name = ?", ss.jobTable)), job)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.UnixMilli(at), true, nil
+}
+
+func (ss *SQLScheduleStore) SetLastRun(ctx context.Context, job string, at time.Time) error {
+	sqlStr := fmt.Sprintf("insert into %s (name, last_run) values (?, ?) on conflict (name) do update set last_run = excluded.last_run", ss.jobTable)
+	if ss.dialect == database.DIALECT_MYSQL {
+		sqlStr = fmt.Sprintf("insert into %s (name, last_run) values (?, ?) on duplicate key update last_run = values(last_run)", ss.jobTable)
+	}
+	_, err := ss.db.ExecContext(ctx, ss.db.Rebind(sqlStr), job, at.UnixMilli())
+	return err
+}
+
+// NewRedisScheduleStore return a redis store, empty prefix use `puzzle:schedule:`.
+func NewRedisScheduleStore(rc redis.Cmdable, prefix string) *RedisScheduleStore {
+	if prefix == "" {
+		prefix = _default_redis_prefix
+	}
+	return &RedisScheduleStore{rc: rc, prefix: prefix}
+}
+
+func (rs *RedisScheduleStore) Save(ctx context.Context, m OutboxMessage) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = rs.rc.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, rs.prefix+"events", m.EventId, raw)
+		p.ZAdd(ctx, rs.prefix+"due", redis.Z{Score: float64(m.NextAt), Member: m.EventId})
+		return nil
+	})
+	return err
+}
+
+func (rs *RedisScheduleStore) Due(ctx context.Context, now time.Time, limit int) ([]OutboxMessage, error) {
+	ids, err := rs.rc.ZRangeByScore(ctx, rs.prefix+"due", &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   fmt.Sprint(now.UnixMilli()),
+		Count: int64(limit),
+	}).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	raws, err := rs.rc.HMGet(ctx, rs.prefix+"events", ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]OutboxMessage, 0, len(raws))
+	for i, raw := range raws {
+		str, ok := raw.(string)
+		if !ok {
+			// removed by others meanwhile
+			continue
+		}
+		var m OutboxMessage
+		if err = json.Unmarshal([]byte(str), &m); err != nil {
+			return nil, fmt.Errorf("decode scheduled event(%s) fail for %w", ids[i], err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+func (rs *RedisScheduleStore) Remove(ctx context.Context, eventId string) (bool, error) {
+	n, err := rs.rc.ZRem(ctx, rs.prefix+"due", eventId).Result()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	return true, rs.rc.HDel(ctx, rs.prefix+"events", eventId).Err()
+}
+
+func (rs *RedisScheduleStore) LastRun(ctx context.Context, job string) (time.Time, bool, error) {
+	at, err := rs.rc.HGet(ctx, rs.prefix+"jobs", job).Int64()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.UnixMilli(at), true, nil
+}
+
+func (rs *RedisScheduleStore) SetLastRun(ctx context.Context, job string, at time.Time) error {
+	return rs.rc.HSet(ctx, rs.prefix+"jobs", job, at.UnixMilli()).Err()
+}
+
+// NewRedisLocker return a locker with a random token.
+func NewRedisLocker(rc redis.Cmdable) *RedisLocker {
+	return &RedisLocker{rc: rc, token: uuid.NewString()}
+}
+
+func (rl *RedisLocker) Lock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	ok, err := rl.rc.SetNX(ctx, key, rl.token, ttl).Result()
+	if err != nil || ok {
+		return ok, err
+	}
+	n, err := rl.rc.Eval(ctx, _lua_lock_renew, []string{key}, rl.token, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (rl *RedisLocker) Unlock(ctx context.Context, key string) error {
+	return rl.rc.Eval(ctx, _lua_lock_free, []string{key}, rl.token).Err()
+}
@@ -0,0 +1,414 @@
+package event
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+/*
+	event.scheduler -- [submodule]
+Scheduler publishes events at a future time or on cron schedules:
+
+	s := event.DefaultScheduler()
+	s.PublishAfter(ctx, event.NewTypedEvent("order.expire", order), 30*time.Minute)
+	s.AddJob("cleanup", "@daily", func(ctx context.Context, at time.Time) event.Event {
+		return event.NewTypedEvent("user.cleanup", at)
+	})
+	s.Start()
+
+Delayed events and the last run of jobs are kept in a ScheduleStore, the memory
+store loses them on restart while the SQL and Redis stores survive it. When several
+instances share a store, WithLeaderLock makes only the instance holding the lock
+fire events. A due event is claimed (removed from the store) before publishing, so
+it is published at most once; use the Outbox for at-least-once delivery.
+A job missing several runs during downtime fires once when the scheduler is back.
+*/
+
+const (
+	_default_schedule_interval = time.Second
+	_default_schedule_batch    = 100
+	_default_leader_key        = "puzzle:scheduler:leader"
+)
+
+var (
+	ErrJobNotFound      = errors.New("scheduled job not found")
+	ErrJobExists        = errors.New("scheduled job already exists")
+	ErrSchedulerRunning = errors.New("event scheduler already running")
+
+	_default_scheduler *Scheduler
+	_scheduler_once    sync.Once
+)
+
+type (
+	// ScheduleStore persist delayed events and the last run of jobs.
+	ScheduleStore interface {
+		// Save store the message due at m.NextAt.
+		Save(ctx context.Context, m OutboxMessage) error
+		// Due return at most limit messages due before now, earliest first.
+		Due(ctx context.Context, now time.Time, limit int) ([]OutboxMessage, error)
+		// Remove delete the message and report whether this call removed it.
+		Remove(ctx context.Context, eventId string) (bool, error)
+		// LastRun return the last run of job, false if it never ran.
+		LastRun(ctx context.Context, job string) (time.Time, bool, error)
+		// SetLastRun record the last run of job.
+		SetLastRun(ctx context.Context, job string, at time.Time) error
+	}
+	// Locker elect the leader among instances.
+	Locker interface {
+		// Lock acquire or renew the lock for ttl, false if held by others.
+		Lock(ctx context.Context, key string, ttl time.Duration) (bool, error)
+		// Unlock release the lock if held.
+		Unlock(ctx context.Context, key string) error
+	}
+	// JobFunc build the event of a job run at time at.
+	JobFunc func(ctx context.Context, at time.Time) Event
+	// JobInfo describe a registered job.
+	JobInfo struct {
+		Name string    `json:"name"`
+		Spec string    `json:"spec"`
+		Last time.Time `json:"last"`
+		Next time.Time `json:"next"`
+	}
+	// Functional scheduler configuration.
+	SchedulerOption func(s *Scheduler)
+	// Scheduler fire delayed events and cron jobs.
+	Scheduler struct {
+		store      ScheduleStore
+		dispatcher *Dispatcher
+		locker     Locker
+		lockKey    string
+		interval   time.Duration
+		batch      int
+		now        func() time.Time
+		mu         sync.Mutex
+		jobs       map[string]*job
+		cancel     context.CancelFunc
+		done       chan struct{}
+	}
+	job struct {
+		name string
+		cron *Cron
+		fn   JobFunc
+	}
+	// MemoryScheduleStore keep schedules in memory.
+	MemoryScheduleStore struct {
+		mu     sync.Mutex
+		events map[string]OutboxMessage
+		runs   map[string]time.Time
+	}
+)
+
+// WithScheduleStore set the store of scheduler, default a memory store.
+func WithScheduleStore(store ScheduleStore) SchedulerOption {
+	return func(s *Scheduler) {
+		s.store = store
+	}
+}
+
+// WithScheduleDispatcher set the dispatcher publishing fired events, default DefaultDispatcher.
+func WithScheduleDispatcher(d *Dispatcher) SchedulerOption {
+	return func(s *Scheduler) {
+		s.dispatcher = d
+	}
+}
+
+// WithScheduleInterval set the polling interval of scheduler.
+func WithScheduleInterval(d time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.interval = d
+	}
+}
+
+// WithLeaderLock fire events only while holding the lock of key, empty key use the default one.
+func WithLeaderLock(locker Locker, key string) SchedulerOption {
+	return func(s *Scheduler) {
+		s.locker = locker
+		if key != "" {
+			s.lockKey = key
+		}
+	}
+}
+
+// NewMemoryScheduleStore return an empty memory store.
+func NewMemoryScheduleStore() *MemoryScheduleStore {
+	return &MemoryScheduleStore{
+		events: make(map[string]OutboxMessage),
+		runs:   make(map[string]time.Time),
+	}
+}
+
+// NewScheduler return a scheduler, call Start to run it.
+func NewScheduler(opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
+		lockKey:  _default_leader_key,
+		interval: _default_schedule_interval,
+		batch:    _default_schedule_batch,
+		now:      time.Now,
+		jobs:     make(map[string]*job),
+	}
+	for _, fn := range opts {
+		fn(s)
+	}
+	if s.store == nil {
+		s.store = NewMemoryScheduleStore()
+	}
+	if s.dispatcher == nil {
+		s.dispatcher = DefaultDispatcher()
+	}
+	return s
+}
+
+// DefaultScheduler return the single instance of Scheduler with a memory store.
+func DefaultScheduler() *Scheduler {
+	_scheduler_once.Do(func() {
+		_default_scheduler = NewScheduler()
+	})
+	return _default_scheduler
+}
+
+// PublishAt publish the event at time at.
+func (s *Scheduler) PublishAt(ctx context.Context, e Event, at time.Time) error {
+	m, err := encodeMessage(e, s.now(), "")
+	if err != nil {
+		return err
+	}
+	m.NextAt = at.UnixMilli()
+	return s.store.Save(ctx, m)
+}
+
+// PublishAfter publish the event after delay.
+func (s *Scheduler) PublishAfter(ctx context.Context, e Event, delay time.Duration) error {
+	return s.PublishAt(ctx, e, s.now().Add(delay))
+}
+
+// AddJob register a job firing the event built by fn on the cron spec.
+func (s *Scheduler) AddJob(name, spec string, fn JobFunc) error {
+	c, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.jobs[name]; found {
+		return fmt.Errorf("%w: %s", ErrJobExists, name)
+	}
+	s.jobs[name] = &job{name: name, cron: c, fn: fn}
+	return nil
+}
+
+// RemoveJob unregister the job.
+func (s *Scheduler) RemoveJob(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, found := s.jobs[name]
+	delete(s.jobs, name)
+	return found
+}
+
+// Jobs return the information of all jobs sorted by name.
+func (s *Scheduler) Jobs(ctx context.Context) ([]JobInfo, error) {
+	jobs := s.jobList()
+	infos := make([]JobInfo, 0, len(jobs))
+	for _, j := range jobs {
+		last, ran, err := s.store.LastRun(ctx, j.name)
+		if err != nil {
+			return nil, err
+		}
+		from := last
+		if !ran {
+			from = s.now()
+		}
+		infos = append(infos, JobInfo{Name: j.name, Spec: j.cron.String(), Last: last, Next: j.cron.Next(from)})
+	}
+	return infos, nil
+}
+
+// RunJob fire the job immediately without changing its schedule.
+func (s *Scheduler) RunJob(ctx context.Context, name string) error {
+	s.mu.Lock()
+	j, found := s.jobs[name]
+	s.mu.Unlock()
+	if !found {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, name)
+	}
+	return s.fire(ctx, j, s.now())
+}
+
+// Start run the scheduler goroutine until Stop.
+func (s *Scheduler) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return ErrSchedulerRunning
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel, s.done = cancel, make(chan struct{})
+	go s.run(ctx, s.done)
+	clog.Info(fmt.Sprintf("event scheduler started with [%s] jobs", palette.Green(len(s.jobs))))
+	return nil
+}
+
+// Stop the scheduler goroutine and release the leader lock.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if s.locker != nil {
+		return s.locker.Unlock(ctx, s.lockKey)
+	}
+	return nil
+}
+
+func (s *Scheduler) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Tick(ctx); err != nil && ctx.Err() == nil {
+			clog.Error(fmt.Sprintf("event scheduler tick fail for %s", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick fire all due events and jobs once, nothing is fired if the leader lock is held by others.
+func (s *Scheduler) Tick(ctx context.Context) error {
+	if s.locker != nil {
+		leader, err := s.locker.Lock(ctx, s.lockKey, 3*s.interval)
+		if err != nil || !leader {
+			return err
+		}
+	}
+	now := s.now()
+	var errs []error
+	msgs, err := s.store.Due(ctx, now, s.batch)
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		claimed, err := s.store.Remove(ctx, m.EventId)
+		if err != nil || !claimed {
+			errs = append(errs, err)
+			continue
+		}
+		e, err := decodeMessage(m)
+		if err == nil {
+			err = s.dispatcher.PublishContext(ctx, e)
+		}
+		errs = append(errs, err)
+	}
+	for _, j := range s.jobList() {
+		errs = append(errs, s.tickJob(ctx, j, now))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Scheduler) tickJob(ctx context.Context, j *job, now time.Time) error {
+	last, ran, err := s.store.LastRun(ctx, j.name)
+	if err != nil {
+		return err
+	}
+	if !ran {
+		// the schedule starts from the first sight of job
+		return s.store.SetLastRun(ctx, j.name, now)
+	}
+	if next := j.cron.Next(last); next.IsZero() || next.After(now) {
+		return nil
+	}
+	if err = s.store.SetLastRun(ctx, j.name, now); err != nil {
+		return err
+	}
+	return s.fire(ctx, j, now)
+}
+
+func (s *Scheduler) fire(ctx context.Context, j *job, at time.Time) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: job(%s) %v", ErrListenerPanic, j.name, r)
+		}
+	}()
+	e := j.fn(ctx, at)
+	if e == nil {
+		return nil
+	}
+	clog.Info(fmt.Sprintf("event scheduler fire job(%s)", palette.SkyBlue(j.name)))
+	return s.dispatcher.PublishContext(ctx, e)
+}
+
+func (s *Scheduler) jobList() []*job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := slices.Sorted(maps.Keys(s.jobs))
+	jobs := make([]*job, 0, len(names))
+	for _, name := range names {
+		jobs = append(jobs, s.jobs[name])
+	}
+	return jobs
+}
+
+func (ms *MemoryScheduleStore) Save(ctx context.Context, m OutboxMessage) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.events[m.EventId] = m
+	return nil
+}
+
+func (ms *MemoryScheduleStore) Due(ctx context.Context, now time.Time, limit int) ([]OutboxMessage, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	due := make([]OutboxMessage, 0)
+	for _, m := range ms.events {
+		if m.NextAt <= now.UnixMilli() {
+			due = append(due, m)
+		}
+	}
+	slices.SortFunc(due, func(a, b OutboxMessage) int {
+		return cmp.Compare(a.NextAt, b.NextAt)
+	})
+	return due[:min(len(due), limit)], nil
+}
+
+func (ms *MemoryScheduleStore) Remove(ctx context.Context, eventId string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	_, found := ms.events[eventId]
+	delete(ms.events, eventId)
+	return found, nil
+}
+
+func (ms *MemoryScheduleStore) LastRun(ctx context.Context, job string) (time.Time, bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	at, found := ms.runs[job]
+	return at, found, nil
+}
+
+func (ms *MemoryScheduleStore) SetLastRun(ctx context.Context, job string, at time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.runs[job] = at
+	return nil
+}
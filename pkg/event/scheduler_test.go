@@ -0,0 +1,118 @@
+package event
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/wendisx/puzzle/pkg/db/dbtest"
+)
+
+func test_scheduler_store(t *testing.T, store ScheduleStore) {
+	b := NewBus()
+	got := []string{}
+	b.Subscribe("**", &funcListener{fn: func(e Event) error {
+		got = append(got, topicOf(e))
+		return nil
+	}})
+	s := NewScheduler(WithScheduleStore(store), WithScheduleDispatcher(NewDispatcher(WithSource(b.Subscriptions))))
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	ctx := t.Context()
+	if err := s.PublishAfter(ctx, NewTypedEvent("order.expire", 1), time.Minute); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := s.AddJob("cleanup", "0 3 * * *", func(ctx context.Context, at time.Time) Event {
+		return NewTypedEvent("user.cleanup", at)
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	tick := func(d time.Duration) {
+		now = now.Add(d)
+		if err := s.Tick(ctx); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	tick(0)
+	tick(30 * time.Second)
+	if len(got) != 0 {
+		t.Fatalf("expected nothing fired but got %v", got)
+	}
+	tick(30 * time.Second)
+	tick(time.Second)
+	if len(got) != 1 || got[0] != "order.expire" {
+		t.Fatalf("expected delayed event fired once but got %v", got)
+	}
+	// missed runs fire once
+	tick(3 * 24 * time.Hour)
+	tick(time.Minute)
+	if len(got) != 2 || got[1] != "user.cleanup" {
+		t.Fatalf("expected job fired once but got %v", got)
+	}
+	jobs, err := s.Jobs(ctx)
+	if err != nil || len(jobs) != 1 || !jobs[0].Next.Equal(time.Date(2025, 1, 4, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected jobs %+v %v", jobs, err)
+	}
+	if err = s.RunJob(ctx, "cleanup"); err != nil || len(got) != 3 {
+		t.Errorf("expected job run manually but got %v %v", got, err)
+	}
+}
+
+// test delayed events and cron jobs on all stores [passed]
+func Test_scheduler(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		test_scheduler_store(t, NewMemoryScheduleStore())
+	})
+	t.Run("sql", func(t *testing.T) {
+		h := dbtest.New(t, dbtest.WithoutTx())
+		store, err := NewSQLScheduleStore(h.DB)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if err = store.Migrate(t.Context()); err != nil {
+			t.Fatal(err.Error())
+		}
+		test_scheduler_store(t, store)
+	})
+	t.Run("redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		test_scheduler_store(t, NewRedisScheduleStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), ""))
+	})
+}
+
+// test only the leader fires [passed]
+func Test_scheduler_leader(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	store := NewRedisScheduleStore(rc, "")
+	fired := 0
+	b := NewBus()
+	b.Subscribe("**", &funcListener{fn: func(e Event) error {
+		fired += 1
+		return nil
+	}})
+	d := NewDispatcher(WithSource(b.Subscriptions))
+	a := NewScheduler(WithScheduleStore(store), WithScheduleDispatcher(d), WithLeaderLock(NewRedisLocker(rc), ""))
+	c := NewScheduler(WithScheduleStore(store), WithScheduleDispatcher(d), WithLeaderLock(NewRedisLocker(rc), ""))
+	ctx := t.Context()
+	if err := a.PublishAt(ctx, NewTypedEvent("x", 1), time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err.Error())
+	}
+	// a hold the lock, c can't fire
+	if err := a.Tick(ctx); err != nil || fired != 1 {
+		t.Fatalf("expected leader fired but got %d %v", fired, err)
+	}
+	c.PublishAt(ctx, NewTypedEvent("y", 1), time.Now().Add(-time.Second))
+	if err := c.Tick(ctx); err != nil || fired != 1 {
+		t.Fatalf("expected follower not fired but got %d %v", fired, err)
+	}
+	// lock released, c become leader
+	if err := a.locker.Unlock(ctx, a.lockKey); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := c.Tick(ctx); err != nil || fired != 2 {
+		t.Errorf("expected new leader fired but got %d %v", fired, err)
+	}
+}
@@ -0,0 +1,333 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+/*
+	event.transport -- [submodule]
+Bridge fans events out across processes. Local events matching the bridged topics are
+encoded by the Codec and sent through the Transport, events received from other nodes
+are decoded and published to the local listeners by the dispatcher:
+
+	rt := event.NewRedisStreamTransport(rc, "puzzle:events", hostname)
+	bridge := event.NewBridge(rt)
+	bridge.Start("order.**", "user.*")
+	defer bridge.Stop(ctx)
+
+Every bridge has a node id, events sent by the node itself are skipped on receiving,
+and events received from others are never sent again, BridgeOrigin(ctx) returns the
+sender node inside listeners. Transports:
+1. RedisStreamTransport: at-least-once by consumer group, use a group per replica to fan
+out to all replicas, or a shared group to have each event handled by one of them.
+Messages of a crashed consumer are claimed after the idle time. In a shared group a
+consumer reading its own message hands it off to another consumer, which handles it
+on its next claim, the message is dropped only if the group has no other consumer.
+2. RedisPubSubTransport: at-most-once, only the subscribers online receive the event.
+3. MemoryTransport: in process, for tests.
+
+Listener failures of bridged events are handled by the retry and dead letters of the
+bus like local events, so a received message is acknowledged once published.
+Payload types registered by RegisterOutboxEvent are used by JSONCodec.
+*/
+
+const (
+	_default_transport_buffer = 64
+)
+
+var (
+	ErrBridgeRunning = errors.New("event bridge already running")
+	ErrOwnMessage    = errors.New("message sent by the node itself")
+)
+
+type (
+	// TransportMessage is the unit sent through a transport.
+	TransportMessage struct {
+		Id     string `json:"id,omitempty"` // assigned by transport
+		Origin string `json:"origin"`       // node id of sender
+		Data   []byte `json:"data"`
+	}
+	// Transport move messages between processes.
+	Transport interface {
+		Send(ctx context.Context, m TransportMessage) error
+		// Receive call handle for every message until ctx done, a message failed to
+		// handle is delivered again if the transport supports. handle returns ErrOwnMessage
+		// for the messages of the receiving node, a transport shared by the consumers of a
+		// group leaves them to another consumer, others drop them.
+		Receive(ctx context.Context, handle func(ctx context.Context, m TransportMessage) error) error
+	}
+	// Codec convert events to bytes and back.
+	Codec interface {
+		Encode(e Event) ([]byte, error)
+		Decode(data []byte) (Event, error)
+	}
+	// ContextCodec is a codec taking the correlation id of ctx, see RequestID.
+	ContextCodec interface {
+		Codec
+		EncodeContext(ctx context.Context, e Event) ([]byte, error)
+	}
+	// JSONCodec encode events as json with their metadata.
+	JSONCodec struct{}
+	// MemoryTransport deliver messages to all receivers in process.
+	MemoryTransport struct {
+		mu        sync.RWMutex
+		seq       atomic.Uint64
+		receivers map[uint64]memoryReceiver
+	}
+	memoryReceiver struct {
+		ch   chan TransportMessage
+		done chan struct{}
+	}
+	// Functional bridge configuration.
+	BridgeOption func(b *Bridge)
+	// Bridge connect the local bus to a transport.
+	Bridge struct {
+		transport  Transport
+		codec      Codec
+		dispatcher *Dispatcher
+		bus        *Bus
+		node       string
+		mu         sync.Mutex
+		subs       []*Subscription
+		cancel     context.CancelFunc
+		done       chan struct{}
+	}
+	// listener forwarding local events to transport
+	bridgeListener struct {
+		b *Bridge
+	}
+)
+
+// WithBridgeCodec set the codec, default JSONCodec.
+func WithBridgeCodec(c Codec) BridgeOption {
+	return func(b *Bridge) {
+		b.codec = c
+	}
+}
+
+// WithBridgeDispatcher set the dispatcher publishing received events, default DefaultDispatcher.
+func WithBridgeDispatcher(d *Dispatcher) BridgeOption {
+	return func(b *Bridge) {
+		b.dispatcher = d
+	}
+}
+
+// WithBridgeBus set the bus whose events are forwarded, default DefaultBus.
+func WithBridgeBus(bus *Bus) BridgeOption {
+	return func(b *Bridge) {
+		b.bus = bus
+	}
+}
+
+// WithBridgeNode set the node id, default a random uuid.
+func WithBridgeNode(node string) BridgeOption {
+	return func(b *Bridge) {
+		b.node = node
+	}
+}
+
+// BridgeOrigin return the node id sending the event, empty for local events.
+func BridgeOrigin(ctx context.Context) string {
+	origin, _ := ctx.Value(_ctx_bridge_origin).(string)
+	return origin
+}
+
+func (jc JSONCodec) Encode(e Event) ([]byte, error) {
+	return jc.EncodeContext(context.Background(), e)
+}
+
+func (JSONCodec) EncodeContext(ctx context.Context, e Event) ([]byte, error) {
+	m, err := encodeMessage(e, time.Now(), "")
+	if err != nil {
+		return nil, err
+	}
+	m.CorrelationId = correlationIn(ctx, e)
+	return json.Marshal(m)
+}
+
+func (JSONCodec) Decode(data []byte) (Event, error) {
+	var m OutboxMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return decodeMessage(m)
+}
+
+// NewMemoryTransport return a transport shared by the bridges in process.
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{receivers: make(map[uint64]memoryReceiver)}
+}
+
+func (mt *MemoryTransport) Send(ctx context.Context, m TransportMessage) error {
+	m.Id = strconv.FormatUint(mt.seq.Add(1), 10)
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	for _, r := range mt.receivers {
+		select {
+		case r.ch <- m:
+		case <-r.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (mt *MemoryTransport) Receive(ctx context.Context, handle func(ctx context.Context, m TransportMessage) error) error {
+	r := memoryReceiver{
+		ch:   make(chan TransportMessage, _default_transport_buffer),
+		done: make(chan struct{}),
+	}
+	id := mt.seq.Add(1)
+	mt.mu.Lock()
+	mt.receivers[id] = r
+	mt.mu.Unlock()
+	defer func() {
+		// release the senders blocked on this receiver
+		close(r.done)
+		mt.mu.Lock()
+		delete(mt.receivers, id)
+		mt.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m := <-r.ch:
+			if err := handle(ctx, m); err != nil && !errors.Is(err, ErrOwnMessage) && ctx.Err() == nil {
+				clog.Warn(fmt.Sprintf("memory transport drop message(%s) for %s", palette.Red(m.Id), err.Error()))
+			}
+		}
+	}
+}
+
+// NewBridge return a bridge on transport.
+func NewBridge(t Transport, opts ...BridgeOption) *Bridge {
+	b := &Bridge{
+		transport: t,
+		codec:     JSONCodec{},
+		node:      uuid.NewString(),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.dispatcher == nil {
+		b.dispatcher = DefaultDispatcher()
+	}
+	if b.bus == nil {
+		b.bus = DefaultBus()
+	}
+	return b
+}
+
+// Node return the node id of bridge.
+func (b *Bridge) Node() string {
+	return b.node
+}
+
+// Start forward local events matching topics, all events if none, and receive events of other nodes.
+func (b *Bridge) Start(topics ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cancel != nil {
+		return ErrBridgeRunning
+	}
+	if len(topics) == 0 {
+		topics = []string{_topic_wildcards}
+	}
+	for _, topic := range topics {
+		b.subs = append(b.subs, b.bus.Subscribe(topic, bridgeListener{b: b}))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel, b.done = cancel, make(chan struct{})
+	go func(done chan struct{}) {
+		defer close(done)
+		if err := b.transport.Receive(ctx, b.receive); err != nil && ctx.Err() == nil {
+			clog.Error(fmt.Sprintf("event bridge(%s) receive fail for %s", palette.Red(b.node), err.Error()))
+		}
+	}(b.done)
+	clog.Info(fmt.Sprintf("event bridge(%s) started", palette.SkyBlue(b.node)))
+	return nil
+}
+
+// Stop forwarding and receiving, wait the receiver or ctx done.
+func (b *Bridge) Stop(ctx context.Context) error {
+	b.mu.Lock()
+	cancel, done, subs := b.cancel, b.done, b.subs
+	b.cancel, b.done, b.subs = nil, nil, nil
+	b.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	for _, s := range subs {
+		s.Unsubscribe()
+	}
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Send encode the event and send it to other nodes.
+func (b *Bridge) Send(ctx context.Context, e Event) error {
+	var (
+		data []byte
+		err  error
+	)
+	if cc, ok := b.codec.(ContextCodec); ok {
+		data, err = cc.EncodeContext(ctx, e)
+	} else {
+		data, err = b.codec.Encode(e)
+	}
+	if err != nil {
+		return err
+	}
+	return b.transport.Send(ctx, TransportMessage{Origin: b.node, Data: data})
+}
+
+func (b *Bridge) receive(ctx context.Context, m TransportMessage) error {
+	if m.Origin == b.node {
+		return ErrOwnMessage
+	}
+	e, err := b.codec.Decode(m.Data)
+	if err != nil {
+		// never decodable, drop it
+		clog.Error(fmt.Sprintf("event bridge(%s) decode message(%s) from %s fail for %s", palette.Red(b.node), m.Id, m.Origin, err.Error()))
+		return nil
+	}
+	res := b.dispatcher.PublishSyncContext(context.WithValue(ctx, _ctx_bridge_origin, m.Origin), e)
+	if err = res.Err(); err != nil {
+		clog.Warn(fmt.Sprintf("event bridge(%s) deliver message(%s) from %s fail for %s", palette.Red(b.node), m.Id, m.Origin, err.Error()))
+	}
+	return ctx.Err()
+}
+
+func (l bridgeListener) Want(e Event) bool {
+	return true
+}
+
+func (l bridgeListener) Listen(e Event) error {
+	return l.ListenContext(context.Background(), e)
+}
+
+func (l bridgeListener) ListenContext(ctx context.Context, e Event) error {
+	if BridgeOrigin(ctx) != "" {
+		// received from other node
+		return nil
+	}
+	return l.b.Send(ctx, e)
+}
@@ -0,0 +1,284 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+const (
+	_default_stream_block      = time.Second
+	_default_stream_batch      = 64
+	_default_stream_claim_idle = time.Minute
+	_default_stream_max_len    = 100000
+	_stream_field_origin       = "origin"
+	_stream_field_data         = "data"
+)
+
+type (
+	// Functional redis stream transport configuration.
+	StreamOption func(rt *RedisStreamTransport)
+	// RedisStreamTransport send messages by `XADD` and receive them by a consumer group.
+	RedisStreamTransport struct {
+		rc        redis.Cmdable
+		stream    string
+		group     string
+		consumer  string
+		block     time.Duration
+		batch     int64
+		claimIdle time.Duration
+		maxLen    int64
+	}
+	// RedisPubSubTransport send messages by `PUBLISH` to a channel.
+	RedisPubSubTransport struct {
+		rc      redis.UniversalClient
+		channel string
+	}
+)
+
+// WithStreamConsumer set the consumer name in group, default the group name.
+func WithStreamConsumer(consumer string) StreamOption {
+	return func(rt *RedisStreamTransport) {
+		rt.consumer = consumer
+	}
+}
+
+// WithStreamBlock set the max blocking time of one read.
+func WithStreamBlock(d time.Duration) StreamOption {
+	return func(rt *RedisStreamTransport) {
+		rt.block = d
+	}
+}
+
+// WithStreamBatch set the max messages read at once.
+func WithStreamBatch(n int) StreamOption {
+	return func(rt *RedisStreamTransport) {
+		rt.batch = int64(max(n, 1))
+	}
+}
+
+// WithStreamClaim set the idle time after which pending messages of other consumers are claimed, 0 to disable.
+func WithStreamClaim(idle time.Duration) StreamOption {
+	return func(rt *RedisStreamTransport) {
+		rt.claimIdle = idle
+	}
+}
+
+// WithStreamMaxLen trim the stream to about n messages, 0 to keep all.
+func WithStreamMaxLen(n int64) StreamOption {
+	return func(rt *RedisStreamTransport) {
+		rt.maxLen = n
+	}
+}
+
+// NewRedisStreamTransport return a transport on stream read by group.
+func NewRedisStreamTransport(rc redis.Cmdable, stream, group string, opts ...StreamOption) *RedisStreamTransport {
+	rt := &RedisStreamTransport{
+		rc:        rc,
+		stream:    stream,
+		group:     group,
+		consumer:  group,
+		block:     _default_stream_block,
+		batch:     _default_stream_batch,
+		claimIdle: _default_stream_claim_idle,
+		maxLen:    _default_stream_max_len,
+	}
+	for _, opt := range opts {
+		opt(rt)
+	}
+	return rt
+}
+
+func (rt *RedisStreamTransport) Send(ctx context.Context, m TransportMessage) error {
+	return rt.rc.XAdd(ctx, &redis.XAddArgs{
+		Stream: rt.stream,
+		MaxLen: rt.maxLen,
+		Approx: true,
+		Values: map[string]any{_stream_field_origin: m.Origin, _stream_field_data: m.Data},
+	}).Err()
+}
+
+// Receive create the group if not exist, new groups start from the new messages.
+func (rt *RedisStreamTransport) Receive(ctx context.Context, handle func(ctx context.Context, m TransportMessage) error) error {
+	err := rt.rc.XGroupCreateMkStream(ctx, rt.stream, rt.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if rt.claimIdle > 0 && time.Since(lastClaim) >= rt.claimIdle {
+			lastClaim = time.Now()
+			if err = rt.claim(ctx, handle); err != nil && ctx.Err() == nil {
+				clog.Warn(fmt.Sprintf("redis stream(%s) claim fail for %s", palette.Red(rt.stream), err.Error()))
+			}
+		}
+		streams, err := rt.rc.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    rt.group,
+			Consumer: rt.consumer,
+			Streams:  []string{rt.stream, ">"},
+			Count:    rt.batch,
+			Block:    rt.block,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			clog.Error(fmt.Sprintf("redis stream(%s) read fail for %s", palette.Red(rt.stream), err.Error()))
+			select {
+			case <-ctx.Done():
+			case <-time.After(rt.block):
+			}
+			continue
+		}
+		for _, s := range streams {
+			rt.process(ctx, s.Messages, handle)
+		}
+	}
+	return nil
+}
+
+// claim handle the own pending messages, like the ones handed off by other consumers, then
+// take over the messages pending longer than the idle time.
+func (rt *RedisStreamTransport) claim(ctx context.Context, handle func(ctx context.Context, m TransportMessage) error) error {
+	streams, err := rt.rc.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    rt.group,
+		Consumer: rt.consumer,
+		Streams:  []string{rt.stream, "0"},
+		Count:    rt.batch,
+		Block:    -1,
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	for _, s := range streams {
+		rt.process(ctx, s.Messages, handle)
+	}
+	start := "0-0"
+	for {
+		msgs, next, err := rt.rc.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   rt.stream,
+			Group:    rt.group,
+			Consumer: rt.consumer,
+			MinIdle:  rt.claimIdle,
+			Start:    start,
+			Count:    rt.batch,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		rt.process(ctx, msgs, handle)
+		if next == "0-0" || len(msgs) == 0 {
+			return nil
+		}
+		start = next
+	}
+}
+
+// process handle the messages and ack the succeeded ones, the failed are left pending.
+func (rt *RedisStreamTransport) process(ctx context.Context, msgs []redis.XMessage, handle func(ctx context.Context, m TransportMessage) error) {
+	for _, msg := range msgs {
+		origin, _ := msg.Values[_stream_field_origin].(string)
+		data, _ := msg.Values[_stream_field_data].(string)
+		err := handle(ctx, TransportMessage{Id: msg.ID, Origin: origin, Data: []byte(data)})
+		if errors.Is(err, ErrOwnMessage) {
+			// another consumer of a shared group handles it, or nobody if the group is of this node only
+			handed, herr := rt.handOff(ctx, msg.ID)
+			if herr != nil && ctx.Err() == nil {
+				clog.Warn(fmt.Sprintf("redis stream(%s) hand off message(%s) fail for %s", palette.Red(rt.stream), msg.ID, herr.Error()))
+			}
+			if handed || herr != nil {
+				continue
+			}
+			err = nil
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				clog.Warn(fmt.Sprintf("redis stream(%s) message(%s) left pending for %s", palette.Red(rt.stream), msg.ID, err.Error()))
+			}
+			continue
+		}
+		if err := rt.rc.XAck(ctx, rt.stream, rt.group, msg.ID).Err(); err != nil && ctx.Err() == nil {
+			clog.Warn(fmt.Sprintf("redis stream(%s) ack message(%s) fail for %s", palette.Red(rt.stream), msg.ID, err.Error()))
+		}
+	}
+}
+
+// handOff move the pending message to the most recently active other consumer of group,
+// false if there is none.
+func (rt *RedisStreamTransport) handOff(ctx context.Context, id string) (bool, error) {
+	consumers, err := rt.rc.XInfoConsumers(ctx, rt.stream, rt.group).Result()
+	if err != nil {
+		return false, err
+	}
+	target := ""
+	var idle time.Duration
+	for _, c := range consumers {
+		if c.Name != rt.consumer && (target == "" || c.Idle < idle) {
+			target, idle = c.Name, c.Idle
+		}
+	}
+	if target == "" {
+		return false, nil
+	}
+	err = rt.rc.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   rt.stream,
+		Group:    rt.group,
+		Consumer: target,
+		Messages: []string{id},
+	}).Err()
+	return err == nil, err
+}
+
+// NewRedisPubSubTransport return a transport on channel.
+func NewRedisPubSubTransport(rc redis.UniversalClient, channel string) *RedisPubSubTransport {
+	return &RedisPubSubTransport{rc: rc, channel: channel}
+}
+
+func (pt *RedisPubSubTransport) Send(ctx context.Context, m TransportMessage) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return pt.rc.Publish(ctx, pt.channel, raw).Err()
+}
+
+func (pt *RedisPubSubTransport) Receive(ctx context.Context, handle func(ctx context.Context, m TransportMessage) error) error {
+	ps := pt.rc.Subscribe(ctx, pt.channel)
+	defer ps.Close()
+	// wait the subscription confirmed
+	if _, err := ps.Receive(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	ch := ps.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			var m TransportMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+				clog.Warn(fmt.Sprintf("redis channel(%s) drop invalid message for %s", palette.Red(pt.channel), err.Error()))
+				continue
+			}
+			if err := handle(ctx, m); err != nil && !errors.Is(err, ErrOwnMessage) && ctx.Err() == nil {
+				clog.Warn(fmt.Sprintf("redis channel(%s) drop message for %s", palette.Red(pt.channel), err.Error()))
+			}
+		}
+	}
+}
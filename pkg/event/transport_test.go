@@ -0,0 +1,237 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type originListener struct {
+	got chan string
+}
+
+func (l originListener) Want(e Event) bool {
+	return true
+}
+
+func (l originListener) Listen(e Event) error {
+	return l.ListenContext(context.Background(), e)
+}
+
+func (l originListener) ListenContext(ctx context.Context, e Event) error {
+	l.got <- BridgeOrigin(ctx) + "|" + e.(*TypedEvent[orderPaid]).Topic()
+	return nil
+}
+
+func test_bridge_node(t *testing.T, tr Transport, node string) (*Dispatcher, chan string) {
+	b := NewBus()
+	got := make(chan string, 8)
+	b.Subscribe("order.*", originListener{got: got})
+	d := NewDispatcher(WithSource(b.Subscriptions))
+	bridge := NewBridge(tr, WithBridgeBus(b), WithBridgeDispatcher(d), WithBridgeNode(node))
+	if err := bridge.Start("order.*"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := bridge.Start(); !errors.Is(err, ErrBridgeRunning) {
+		t.Fatalf("expected ErrBridgeRunning but got %v", err)
+	}
+	t.Cleanup(func() { bridge.Stop(context.Background()) })
+	return d, got
+}
+
+func test_receive(t *testing.T, got chan string, want string) {
+	t.Helper()
+	select {
+	case s := <-got:
+		if s != want {
+			t.Fatalf("expected %s but got %s", want, s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected %s but got nothing", want)
+	}
+}
+
+func test_nothing(t *testing.T, got chan string) {
+	t.Helper()
+	select {
+	case s := <-got:
+		t.Fatalf("expected nothing but got %s", s)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// test bridges fan out events between nodes without echo [passed]
+func Test_bridge_memory(t *testing.T) {
+	RegisterOutboxEvent[orderPaid]("order.paid")
+	tr := NewMemoryTransport()
+	da, gotA := test_bridge_node(t, tr, "a")
+	_, gotB := test_bridge_node(t, tr, "b")
+	_, gotC := test_bridge_node(t, tr, "c")
+	for {
+		tr.mu.RLock()
+		n := len(tr.receivers)
+		tr.mu.RUnlock()
+		if n == 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := da.PublishSync(NewTypedEvent("order.paid", orderPaid{OrderId: 1})).Err(); err != nil {
+		t.Fatal(err.Error())
+	}
+	test_receive(t, gotA, "|order.paid")
+	test_receive(t, gotB, "a|order.paid")
+	test_receive(t, gotC, "a|order.paid")
+	// neither echoed back to a nor forwarded again by b and c
+	test_nothing(t, gotA)
+	test_nothing(t, gotB)
+	// topics not bridged stay local
+	da.PublishSync(NewTypedEvent("user.created", orderPaid{}))
+	test_nothing(t, gotB)
+}
+
+// test bridges over redis streams with a group per node [passed]
+func Test_bridge_redis_stream(t *testing.T) {
+	RegisterOutboxEvent[orderPaid]("order.paid")
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	da, _ := test_bridge_node(t, NewRedisStreamTransport(rc, "events", "a", WithStreamBlock(10*time.Millisecond)), "a")
+	_, gotB := test_bridge_node(t, NewRedisStreamTransport(rc, "events", "b", WithStreamBlock(10*time.Millisecond)), "b")
+	// wait the groups created
+	for {
+		if groups, _ := rc.XInfoGroups(t.Context(), "events").Result(); len(groups) == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	da.PublishSync(NewTypedEvent("order.paid", orderPaid{OrderId: 2}))
+	test_receive(t, gotB, "a|order.paid")
+	test_nothing(t, gotB)
+}
+
+// test bridges sharing a group leave their own messages to the other consumer [passed]
+func Test_bridge_redis_stream_shared(t *testing.T) {
+	RegisterOutboxEvent[orderPaid]("order.paid")
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	opts := []StreamOption{WithStreamBlock(10 * time.Millisecond), WithStreamClaim(20 * time.Millisecond)}
+	da, gotA := test_bridge_node(t, NewRedisStreamTransport(rc, "events", "app", append(opts, WithStreamConsumer("a"))...), "a")
+	_, gotB := test_bridge_node(t, NewRedisStreamTransport(rc, "events", "app", append(opts, WithStreamConsumer("b"))...), "b")
+	for {
+		if groups, _ := rc.XInfoGroups(t.Context(), "events").Result(); len(groups) == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// miniredis adds consumers only when they read messages, unlike redis
+	for _, consumer := range []string{"a", "b"} {
+		if err := rc.XGroupCreateConsumer(t.Context(), "events", "app", consumer).Err(); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	for i := range 4 {
+		da.PublishSync(NewTypedEvent("order.paid", orderPaid{OrderId: uint64(i)}))
+		test_receive(t, gotA, "|order.paid")
+	}
+	// whichever consumer reads them, every one reaches b
+	for range 4 {
+		test_receive(t, gotB, "a|order.paid")
+	}
+	test_nothing(t, gotA)
+	test_nothing(t, gotB)
+	for deadline := time.Now().Add(time.Second); ; {
+		pending, err := rc.XPending(t.Context(), "events", "app").Result()
+		if err == nil && pending.Count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected nothing pending but got %+v %v", pending, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// test failed messages stay pending and are claimed by another consumer [passed]
+func Test_redis_stream_claim(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	opts := []StreamOption{WithStreamBlock(10 * time.Millisecond), WithStreamClaim(20 * time.Millisecond)}
+	sender := NewRedisStreamTransport(rc, "jobs", "workers")
+	first := NewRedisStreamTransport(rc, "jobs", "workers", append(opts, WithStreamConsumer("first"))...)
+	second := NewRedisStreamTransport(rc, "jobs", "workers", append(opts, WithStreamConsumer("second"))...)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	var failed atomic.Int32
+	firstCtx, crash := context.WithCancel(ctx)
+	go first.Receive(firstCtx, func(ctx context.Context, m TransportMessage) error {
+		failed.Add(1)
+		return errors.New("crashed")
+	})
+	for len(mr.Keys()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := sender.Send(ctx, TransportMessage{Origin: "x", Data: []byte("job-1")}); err != nil {
+		t.Fatal(err.Error())
+	}
+	for failed.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	crash()
+	got := make(chan string, 4)
+	go second.Receive(ctx, func(ctx context.Context, m TransportMessage) error {
+		got <- m.Origin + "|" + string(m.Data)
+		return nil
+	})
+	test_receive(t, got, "x|job-1")
+	// acked after handled
+	for deadline := time.Now().Add(time.Second); ; {
+		pending, err := rc.XPending(ctx, "jobs", "workers").Result()
+		if err == nil && pending.Count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected nothing pending but got %+v %v", pending, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	test_nothing(t, got)
+}
+
+// test bridges over redis pub/sub [passed]
+func Test_bridge_redis_pubsub(t *testing.T) {
+	RegisterOutboxEvent[orderPaid]("order.paid")
+	mr := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	da, gotA := test_bridge_node(t, NewRedisPubSubTransport(rc, "events"), "a")
+	_, gotB := test_bridge_node(t, NewRedisPubSubTransport(rc, "events"), "b")
+	for mr.PubSubNumSub("events")["events"] < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	da.PublishSync(NewTypedEvent("order.paid", orderPaid{OrderId: 3}))
+	test_receive(t, gotA, "|order.paid")
+	test_receive(t, gotB, "a|order.paid")
+	test_nothing(t, gotA)
+}
+
+// test json codec keeps the metadata [passed]
+func Test_json_codec(t *testing.T) {
+	RegisterOutboxEvent[orderPaid]("order.paid")
+	e := NewTypedEvent("order.paid", orderPaid{OrderId: 4, Amount: 9}, WithCorrelationID("req"), WithHeader("tenant", "t1"))
+	data, err := JSONCodec{}.Encode(e)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	got, err := JSONCodec{}.Decode(data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	te := got.(*TypedEvent[orderPaid])
+	if te.ID() != e.ID() || te.Payload() != e.Payload() || te.CorrelationID() != "req" || te.Header("tenant") != "t1" {
+		t.Fatalf("expected same event but got %#v", te)
+	}
+}
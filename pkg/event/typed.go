@@ -0,0 +1,179 @@
+package event
+
+import (
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+	event.typed -- [submodule]
+TypedEvent carries a payload of concrete type, listeners subscribed by
+SubscribeTyped receive it without any type assertion:
+
+	type UserCreated struct{ Id uint64 }
+
+	event.SubscribeTyped("user.created", func(e *event.TypedEvent[UserCreated]) error {
+		clog.Info(fmt.Sprintf("user(%d) created by request(%s)", e.Payload().Id, e.CorrelationID()))
+		return nil
+	})
+	event.DefaultDispatcher().Publish(event.NewTypedEvent("user.created", UserCreated{Id: 1},
+		event.WithCorrelationID(c.Response().Header().Get(echo.HeaderXRequestID))))
+
+The name of typed event is also its topic unless WithTopic is given.
+*/
+
+type (
+	// Headers hold the metadata of event.
+	Headers map[string]string
+	// Functional event metadata configuration.
+	EventOption func(m *EventMeta)
+	// TypedEvent is an observable event with a payload of type T.
+	TypedEvent[T any] struct {
+		EventMeta
+		payload T
+	}
+	// TypedHandler receive the typed event.
+	TypedHandler[T any]  func(e *TypedEvent[T]) error
+	typedListener[T any] struct {
+		fn TypedHandler[T]
+	}
+)
+
+// WithEventID replace the generated uuid of event.
+func WithEventID(id string) EventOption {
+	return func(m *EventMeta) {
+		m.id = id
+	}
+}
+
+// WithCorrelationID set the id correlating the event to its cause, such as a request id.
+func WithCorrelationID(id string) EventOption {
+	return func(m *EventMeta) {
+		m.correlationId = id
+	}
+}
+
+// WithHeader set a metadata header of event.
+func WithHeader(key, value string) EventOption {
+	return func(m *EventMeta) {
+		if m.headers == nil {
+			m.headers = make(Headers)
+		}
+		m.headers[key] = value
+	}
+}
+
+// WithTopic set the topic of event instead of its name.
+func WithTopic(topic string) EventOption {
+	return func(m *EventMeta) {
+		m.topic = topic
+	}
+}
+
+// WithRarity set the rarity of event.
+func WithRarity(rarity int) EventOption {
+	return func(m *EventMeta) {
+		m.rarity = rarity
+	}
+}
+
+// WithTimestamp replace the creating time of event.
+func WithTimestamp(ts time.Time) EventOption {
+	return func(m *EventMeta) {
+		m.timeline = ts
+	}
+}
+
+// NewTypedEvent return a typed event with a generated uuid and current timestamp.
+func NewTypedEvent[T any](name string, payload T, opts ...EventOption) *TypedEvent[T] {
+	e := &TypedEvent[T]{
+		EventMeta: EventMeta{
+			timeline: time.Now(),
+			name:     name,
+			id:       uuid.NewString(),
+			topic:    name,
+		},
+		payload: payload,
+	}
+	for _, fn := range opts {
+		fn(&e.EventMeta)
+	}
+	return e
+}
+
+// SubscribeTyped register fn to the default bus for typed events with payload T matching topic.
+func SubscribeTyped[T any](topic string, fn TypedHandler[T], opts ...SubscribeOption) *Subscription {
+	return SubscribeTypedTo(DefaultBus(), topic, fn, opts...)
+}
+
+// SubscribeTypedTo register fn to bus for typed events with payload T matching topic.
+func SubscribeTypedTo[T any](b *Bus, topic string, fn TypedHandler[T], opts ...SubscribeOption) *Subscription {
+	return b.Subscribe(topic, typedListener[T]{fn: fn}, opts...)
+}
+
+// Name return the name of event.
+func (m EventMeta) Name() string {
+	return m.name
+}
+
+// ID return the unique id of event.
+func (m EventMeta) ID() string {
+	return m.id
+}
+
+// CorrelationID return the id correlating the event to its cause.
+func (m EventMeta) CorrelationID() string {
+	return m.correlationId
+}
+
+// Timestamp return the creating time of event.
+func (m EventMeta) Timestamp() time.Time {
+	return m.timeline
+}
+
+// Header return the metadata header of event.
+func (m EventMeta) Header(key string) string {
+	return m.headers[key]
+}
+
+// Headers return a copy of all metadata headers of event.
+func (m EventMeta) Headers() Headers {
+	return maps.Clone(m.headers)
+}
+
+func (e *TypedEvent[T]) Kind() uint {
+	return EVENT_KIND_OBSERABLE
+}
+
+func (e *TypedEvent[T]) Timeline() string {
+	return e.timeline.Format(event_timeline)
+}
+
+func (e *TypedEvent[T]) Rarity() int {
+	return e.rarity
+}
+
+func (e *TypedEvent[T]) Record() any {
+	return e.payload
+}
+
+// Payload return the payload of event.
+func (e *TypedEvent[T]) Payload() T {
+	return e.payload
+}
+
+func (e *TypedEvent[T]) String() string {
+	return fmt.Sprintf("%s(%s)", e.name, e.id)
+}
+
+func (l typedListener[T]) Want(e Event) bool {
+	_, ok := e.(*TypedEvent[T])
+	return ok
+}
+
+func (l typedListener[T]) Listen(e Event) error {
+	return l.fn(e.(*TypedEvent[T]))
+}
@@ -0,0 +1,37 @@
+package event
+
+import (
+	"testing"
+)
+
+type userCreated struct {
+	Id   uint64
+	Name string
+}
+
+// test typed listener only receive its payload type [passed]
+func Test_typed_event(t *testing.T) {
+	b := NewBus()
+	got := []userCreated{}
+	SubscribeTypedTo(b, "user.*", func(e *TypedEvent[userCreated]) error {
+		if e.CorrelationID() != "req-1" || e.Header("source") != "test" {
+			t.Errorf("unexpected metadata %s %v", e.CorrelationID(), e.Headers())
+		}
+		got = append(got, e.Payload())
+		return nil
+	})
+	d := NewDispatcher(WithSource(b.Subscriptions))
+	e := NewTypedEvent("user.created", userCreated{Id: 1, Name: "puzzle"}, WithCorrelationID("req-1"), WithHeader("source", "test"))
+	if e.ID() == "" || e.Timestamp().IsZero() || e.Topic() != "user.created" {
+		t.Fatalf("unexpected event %s %s %s", e.ID(), e.Timestamp(), e.Topic())
+	}
+	if err := d.Publish(e); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := d.Publish(NewTypedEvent("user.deleted", "not a user")); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(got) != 1 || got[0].Id != 1 {
+		t.Errorf("expected 1 typed payload but got %v", got)
+	}
+}
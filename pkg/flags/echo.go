@@ -0,0 +1,179 @@
+package flags
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/router"
+	"github.com/wendisx/puzzle/pkg/util"
+)
+
+const (
+	_echo_flags_path = "/flags"
+	_echo_flag_path  = "/flags/:name"
+
+	// set by the jwt middleware
+	_ctx_claims_key  = "claims"
+	_ctx_user_id_key = "userId"
+	_ctx_name_key    = "name"
+)
+
+// Gate return a middleware answering 404 if the flag of name is disabled for the user by the
+// default flags, taken per request so a later SetDefault applies to the routes built before.
+func Gate(name string) echo.MiddlewareFunc {
+	return gate(name, Default)
+}
+
+// Gate return a middleware answering 404 if the flag of name is disabled for the user, so
+// the gated route looks like not exists.
+func (f *Flags) Gate(name string) echo.MiddlewareFunc {
+	return gate(name, func() *Flags { return f })
+}
+
+func gate(name string, flagsOf func() *Flags) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !flagsOf().Enabled(name, UserFrom(c)) {
+				return echo.ErrNotFound
+			}
+			return next(c)
+		}
+	}
+}
+
+// UserFrom return the user of request, by the claims the jwt middleware set or the
+// bearer token if not authenticated yet, anonymous without both.
+func UserFrom(c echo.Context) User {
+	if jc, ok := c.Get(_ctx_claims_key).(*util.JwtClaim); ok {
+		return UserOf(jc)
+	}
+	if id, ok := c.Get(_ctx_user_id_key).([]byte); ok {
+		name, _ := c.Get(_ctx_name_key).(string)
+		return NewUser(string(id), name)
+	}
+	tokenStr, found := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+	if !found {
+		tokenStr = c.QueryParam("access_token")
+	}
+	if tokenStr == "" {
+		return User{}
+	}
+	jc, err := util.ParseToken(tokenStr)
+	if err != nil {
+		return User{}
+	}
+	return UserOf(jc)
+}
+
+// NewEchoFlagPeer return the admin peer of f guarded by authorize, like the jwt middleware,
+// it runs before the other pre handlers and panics if nil, as the peer changes flags at runtime:
+//
+//	GET    /flags              list all flags with their sources
+//	GET    /flags/:name        the flag and whether it is enabled for the caller
+//	PUT    /flags/:name        replace the flag by the json body
+//	PATCH  /flags/:name        change the fields in the json body, like {"enabled":false}
+//	DELETE /flags/:name        remove the runtime change, back to config and env
+func NewEchoFlagPeer(f *Flags, authorize echo.MiddlewareFunc, pre ...echo.MiddlewareFunc) router.EchoPeer {
+	if authorize == nil {
+		clog.Panic("the flag peer needs an authorizer")
+	}
+	pre = append([]echo.MiddlewareFunc{authorize}, pre...)
+	ep := router.EchoPeer{}
+	ep.ToEndpoint(router.Endpoint[echo.HandlerFunc, echo.MiddlewareFunc]{
+		Method:      http.MethodGet,
+		Path:        _echo_flags_path,
+		Handler:     f.serveList,
+		PreHandlers: pre,
+	})
+	ep.ToEndpoint(router.Endpoint[echo.HandlerFunc, echo.MiddlewareFunc]{
+		Method:      http.MethodGet,
+		Path:        _echo_flag_path,
+		Handler:     f.serveGet,
+		PreHandlers: pre,
+	})
+	ep.ToEndpoint(router.Endpoint[echo.HandlerFunc, echo.MiddlewareFunc]{
+		Method:      http.MethodPut,
+		Path:        _echo_flag_path,
+		Handler:     f.servePut,
+		PreHandlers: pre,
+	})
+	ep.ToEndpoint(router.Endpoint[echo.HandlerFunc, echo.MiddlewareFunc]{
+		Method:      http.MethodPatch,
+		Path:        _echo_flag_path,
+		Handler:     f.servePatch,
+		PreHandlers: pre,
+	})
+	ep.ToEndpoint(router.Endpoint[echo.HandlerFunc, echo.MiddlewareFunc]{
+		Method:      http.MethodDelete,
+		Path:        _echo_flag_path,
+		Handler:     f.serveDelete,
+		PreHandlers: pre,
+	})
+	return ep
+}
+
+func (f *Flags) serveList(c echo.Context) error {
+	return c.JSON(http.StatusOK, f.List())
+}
+
+func (f *Flags) serveGet(c echo.Context) error {
+	name := c.Param("name")
+	fs, found := f.Lookup(name)
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, ErrFlagNotFound.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"flag":   fs,
+		"active": f.Enabled(name, UserFrom(c)),
+	})
+}
+
+func (f *Flags) servePut(c echo.Context) error {
+	var fs FlagState
+	if err := json.NewDecoder(c.Request().Body).Decode(&fs.FlagConfig); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return f.save(c, fs)
+}
+
+func (f *Flags) servePatch(c echo.Context) error {
+	fs, _ := f.Lookup(c.Param("name"))
+	// the fields not in body are kept, decoded into copies so the loaded flag is not changed
+	if fs.Rollout != nil {
+		fs.Rollout = Rollout(*fs.Rollout)
+	}
+	fs.Users, fs.Rules = slices.Clone(fs.Users), slices.Clone(fs.Rules)
+	if err := json.NewDecoder(c.Request().Body).Decode(&fs.FlagConfig); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return f.save(c, fs)
+}
+
+func (f *Flags) save(c echo.Context, fs FlagState) error {
+	name := c.Param("name")
+	err := f.Set(c.Request().Context(), name, fs.FlagConfig)
+	if errors.Is(err, ErrFlagInvalid) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	fs, _ = f.Lookup(name)
+	return c.JSON(http.StatusOK, fs)
+}
+
+func (f *Flags) serveDelete(c echo.Context) error {
+	err := f.Reset(c.Request().Context(), c.Param("name"))
+	if errors.Is(err, ErrFlagNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,400 @@
+// Package flags gate the endpoints and code paths by feature flags.
+package flags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/config"
+	"github.com/wendisx/puzzle/pkg/palette"
+	"github.com/wendisx/puzzle/pkg/util"
+)
+
+/*
+	flags -- [submodule]
+Feature flags read from the sources in order, a later source replaces the whole flag
+of an earlier one:
+1. ConfigSource, the `flags` section of config.
+2. EnvSource, variables like PUZZLE_FLAG_NEW_CHECKOUT=true, 25% or a json flag.
+3. the store, a MemoryStore by default or a RedisStore or SQLStore shared by all
+instances, the admin peer writes the runtime changes into it.
+The names are case and separator insensitive, newCheckout, new-checkout and
+NEW_CHECKOUT are the same flag. A disabled flag is off for everyone, an enabled one is
+on for a user if:
+1. the user is in users, or matches one of rules by its jwt claims.
+2. rollout is not set or 100, or the user falls in the rollout percent by the hash of
+flag and user, so one user gets the same result every time, rollout 0 enables it for
+nobody else.
+Unknown flags are disabled. Gate return 404 for the gated routes, as if they not exist,
+and the admin peer requires an authorizer guarding it:
+
+	route.ToPeer(flags.NewEchoFlagPeer(flags.Default(), middleware.EchoMiddleware{}.SimpleJwtAuth()))
+	ep.ToEndpoint(router.Endpoint[echo.HandlerFunc, echo.MiddlewareFunc]{
+		Method:      http.MethodPost,
+		Path:        "/checkout",
+		Handler:     checkout,
+		PreHandlers: []echo.MiddlewareFunc{flags.Gate("newCheckout")},
+	})
+*/
+
+const (
+	SOURCE_CONFIG = "config"
+	SOURCE_ENV    = "env"
+	SOURCE_MEMORY = "memory"
+	SOURCE_REDIS  = "redis"
+	SOURCE_SQL    = "sql"
+
+	CLAIM_ID   = "id"
+	CLAIM_NAME = "name"
+	CLAIM_SUB  = "sub"
+	CLAIM_ISS  = "iss"
+	CLAIM_AUD  = "aud"
+	CLAIM_JTI  = "jti"
+
+	_rollout_all = 100
+)
+
+var (
+	_default_flags    *Flags
+	_default_flags_mu sync.Mutex
+	_default_unwatch  func()
+
+	ErrFlagNotFound = errors.New("flag not found")
+	ErrFlagInvalid  = errors.New("invalid flag")
+)
+
+type (
+	// Functional flags configuration.
+	FlagsOption func(f *Flags)
+	// Flags evaluate the flags merged from its sources.
+	Flags struct {
+		mu       sync.RWMutex
+		sources  []Source
+		store    Store
+		interval time.Duration
+		flags    map[string]FlagState
+		stop     chan struct{}
+		done     chan struct{}
+	}
+	// FlagState is a flag and the source it comes from.
+	FlagState struct {
+		Name   string `json:"name"`
+		Source string `json:"source"`
+		config.FlagConfig
+	}
+	// User is who the flags are evaluated for, empty for the anonymous.
+	User struct {
+		Id     string
+		Name   string
+		Claims map[string][]string // by CLAIM_*
+	}
+)
+
+// WithSource append a source, it replaces the flags of sources before.
+func WithSource(s Source) FlagsOption {
+	return func(f *Flags) {
+		f.sources = append(f.sources, s)
+	}
+}
+
+// WithStore set the store of runtime changes, the last source, default a MemoryStore.
+func WithStore(s Store) FlagsOption {
+	return func(f *Flags) {
+		f.store = s
+	}
+}
+
+// WithRefresh set the interval Start reload the sources, like for the changes of other instances.
+func WithRefresh(d time.Duration) FlagsOption {
+	return func(f *Flags) {
+		f.interval = d
+	}
+}
+
+// New return the flags of sources, load them by Refresh.
+func New(opts ...FlagsOption) *Flags {
+	f := &Flags{flags: make(map[string]FlagState)}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.store == nil {
+		f.store = NewMemoryStore()
+	}
+	return f
+}
+
+// Default return the flags of config, env with prefix PUZZLE_FLAG and memory, loaded on first call
+// and reloaded whenever the config in dict is loaded or reloaded.
+func Default() *Flags {
+	_default_flags_mu.Lock()
+	defer _default_flags_mu.Unlock()
+	if _default_flags == nil {
+		f := New(WithSource(NewConfigSource(nil)), WithSource(NewEnvSource("")))
+		if err := f.Refresh(context.Background()); err != nil {
+			clog.Warn(fmt.Sprintf("load default flags fail for %s", palette.Red(err.Error())))
+		}
+		_default_flags, _default_unwatch = f, watchConfig(f)
+	}
+	return _default_flags
+}
+
+// SetDefault replace the default flags, like with a redis store, they are reloaded with the config too.
+func SetDefault(f *Flags) {
+	_default_flags_mu.Lock()
+	defer _default_flags_mu.Unlock()
+	if _default_unwatch != nil {
+		_default_unwatch()
+		_default_unwatch = nil
+	}
+	_default_flags = f
+	if f != nil {
+		_default_unwatch = watchConfig(f)
+	}
+}
+
+// Enabled tell whether the flag of name is enabled for u by the default flags.
+func Enabled(name string, u User) bool {
+	return Default().Enabled(name, u)
+}
+
+// Refresh reload all sources, the flags are kept if any source fails.
+func (f *Flags) Refresh(ctx context.Context) error {
+	merged := make(map[string]FlagState)
+	for _, s := range append(slices.Clone(f.sources), f.store) {
+		loaded, err := s.Load(ctx)
+		if err != nil {
+			return fmt.Errorf("load flags of %s fail for %w", s.Name(), err)
+		}
+		for name, fc := range loaded {
+			key := flagKey(name)
+			merged[key] = FlagState{Name: key, Source: s.Name(), FlagConfig: fc}
+		}
+	}
+	f.mu.Lock()
+	f.flags = merged
+	f.mu.Unlock()
+	return nil
+}
+
+// Start reload the sources every refresh interval until Stop, nothing without the interval.
+func (f *Flags) Start() {
+	if f.interval <= 0 || f.stop != nil {
+		return
+	}
+	f.stop, f.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(f.done)
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-f.stop:
+				return
+			case <-ticker.C:
+				if err := f.Refresh(context.Background()); err != nil {
+					clog.Warn(fmt.Sprintf("refresh flags fail for %s", palette.Red(err.Error())))
+				}
+			}
+		}
+	}()
+}
+
+// Stop stop the refresh started by Start.
+func (f *Flags) Stop() {
+	if f.stop == nil {
+		return
+	}
+	close(f.stop)
+	<-f.done
+	f.stop, f.done = nil, nil
+}
+
+// watchConfig refresh f when the config in dict changes, return the function to stop watching.
+func watchConfig(f *Flags) func() {
+	// the config loaded later is recorded into the same dict
+	config.LoadDict(config.DICTKEY_CONFIG)
+	configDict := config.GetDict(config.DICTKEY_CONFIG)
+	return configDict.Watch(func(ev config.DictEvent[any]) {
+		if ev.Key != config.DATAKEY_CONFIG {
+			return
+		}
+		if err := f.Refresh(context.Background()); err != nil {
+			clog.Warn(fmt.Sprintf("refresh flags for the config fail for %s", palette.Red(err.Error())))
+		}
+	})
+}
+
+// Lookup return the flag of name.
+func (f *Flags) Lookup(name string) (FlagState, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	fs, found := f.flags[flagKey(name)]
+	return fs, found
+}
+
+// List return all flags sorted by name.
+func (f *Flags) List() []FlagState {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	states := slices.Collect(maps.Values(f.flags))
+	slices.SortFunc(states, func(a, b FlagState) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return states
+}
+
+// Enabled tell whether the flag of name is enabled for u.
+func (f *Flags) Enabled(name string, u User) bool {
+	fs, found := f.Lookup(name)
+	if !found {
+		return false
+	}
+	return evaluate(fs.Name, &fs.FlagConfig, u)
+}
+
+// Set save the flag of name into the store, it replaces the one of other sources.
+func (f *Flags) Set(ctx context.Context, name string, fc config.FlagConfig) error {
+	if err := checkFlag(name, fc); err != nil {
+		return err
+	}
+	if err := f.store.Save(ctx, flagKey(name), fc); err != nil {
+		return err
+	}
+	clog.Info(fmt.Sprintf("set flag(%s) enabled=%t rollout=%d", palette.SkyBlue(flagKey(name)), fc.Enabled, rollout(&fc)))
+	return f.Refresh(ctx)
+}
+
+// Toggle enable or disable the flag of name, the other fields are kept.
+func (f *Flags) Toggle(ctx context.Context, name string, enabled bool) error {
+	fs, _ := f.Lookup(name)
+	fs.Enabled = enabled
+	return f.Set(ctx, name, fs.FlagConfig)
+}
+
+// Reset remove the flag of name from the store, the one of other sources takes effect again.
+func (f *Flags) Reset(ctx context.Context, name string) error {
+	removed, err := f.store.Delete(ctx, flagKey(name))
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("%w: %s in %s", ErrFlagNotFound, name, f.store.Name())
+	}
+	clog.Info(fmt.Sprintf("reset flag(%s)", palette.SkyBlue(flagKey(name))))
+	return f.Refresh(ctx)
+}
+
+// NewUser return the user of id and name, like in code paths without jwt.
+func NewUser(id, name string) User {
+	u := User{Id: id, Name: name, Claims: make(map[string][]string)}
+	u.addClaim(CLAIM_ID, id)
+	u.addClaim(CLAIM_NAME, name)
+	return u
+}
+
+// UserOf return the user of jwt claims.
+func UserOf(jc *util.JwtClaim) User {
+	u := NewUser(string(jc.ExternId), jc.Name)
+	u.addClaim(CLAIM_SUB, jc.Subject)
+	u.addClaim(CLAIM_ISS, jc.Issuer)
+	u.addClaim(CLAIM_JTI, jc.ID)
+	u.addClaim(CLAIM_AUD, jc.Audience...)
+	return u
+}
+
+func (u *User) addClaim(claim string, values ...string) {
+	for _, v := range values {
+		if v != "" {
+			u.Claims[claim] = append(u.Claims[claim], v)
+		}
+	}
+}
+
+// key return what the rollout hashes, empty for the anonymous.
+func (u User) key() string {
+	if u.Id != "" {
+		return u.Id
+	}
+	return u.Name
+}
+
+func evaluate(name string, fc *config.FlagConfig, u User) bool {
+	// disabled is the kill switch, even for the targeted users
+	if !fc.Enabled {
+		return false
+	}
+	if targeted(fc, u) {
+		return true
+	}
+	percent := rollout(fc)
+	if percent >= _rollout_all {
+		return true
+	}
+	key := u.key()
+	if percent <= 0 || key == "" {
+		return false
+	}
+	return bucket(name, key) < percent
+}
+
+// rollout return the percent of fc, 100 if not set.
+func rollout(fc *config.FlagConfig) int {
+	if fc.Rollout == nil {
+		return _rollout_all
+	}
+	return *fc.Rollout
+}
+
+// Rollout return the pointer to percent, for the rollout of FlagConfig.
+func Rollout(percent int) *int {
+	return &percent
+}
+
+func targeted(fc *config.FlagConfig, u User) bool {
+	if (u.Id != "" && slices.Contains(fc.Users, u.Id)) || (u.Name != "" && slices.Contains(fc.Users, u.Name)) {
+		return true
+	}
+	for _, r := range fc.Rules {
+		if slices.ContainsFunc(u.Claims[r.Claim], func(v string) bool {
+			return slices.Contains(r.Values, v)
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket return the stable position 0-99 of user key in the rollout of flag name.
+func bucket(name, key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + key))
+	return int(h.Sum32() % _rollout_all)
+}
+
+// flagKey return the normalized name, like new_checkout of newCheckout.
+func flagKey(name string) string {
+	return strings.ToLower(util.UpperSnake(name))
+}
+
+func checkFlag(name string, fc config.FlagConfig) error {
+	if flagKey(name) == "" {
+		return fmt.Errorf("%w: empty name", ErrFlagInvalid)
+	}
+	if fc.Rollout != nil && (*fc.Rollout < 0 || *fc.Rollout > _rollout_all) {
+		return fmt.Errorf("%w: rollout %d of %s is out of 0-100", ErrFlagInvalid, *fc.Rollout, name)
+	}
+	for _, r := range fc.Rules {
+		if r.Claim == "" {
+			return fmt.Errorf("%w: rule of %s without claim", ErrFlagInvalid, name)
+		}
+	}
+	return nil
+}
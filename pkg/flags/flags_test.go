@@ -0,0 +1,293 @@
+package flags
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/wendisx/puzzle/pkg/config"
+	"github.com/wendisx/puzzle/pkg/db/dbtest"
+	"github.com/wendisx/puzzle/pkg/router"
+	"github.com/wendisx/puzzle/pkg/util"
+)
+
+func test_flags(t *testing.T, opts ...FlagsOption) *Flags {
+	t.Helper()
+	c := &config.Config{FlagsConfig: map[string]*config.FlagConfig{
+		"newCheckout": {Enabled: true, Rollout: Rollout(30)},
+		"beta":        {Enabled: true, Rollout: Rollout(0), Users: []string{"alice"}, Rules: []config.FlagRule{{Claim: CLAIM_ISS, Values: []string{"partner"}}}},
+		"darkMode":    {Enabled: false},
+		"freeze":      {Enabled: true, Rollout: Rollout(0), Users: []string{"ops"}},
+	}}
+	f := New(append([]FlagsOption{WithSource(NewConfigSource(c)), WithSource(NewEnvSource("TEST_FLAG"))}, opts...)...)
+	if err := f.Refresh(t.Context()); err != nil {
+		t.Fatal(err.Error())
+	}
+	return f
+}
+
+// test targeting, rules and stable rollout [passed]
+func Test_evaluate(t *testing.T) {
+	f := test_flags(t)
+	if !f.Enabled("beta", NewUser("", "alice")) || f.Enabled("beta", NewUser("7", "bob")) {
+		t.Fatal("expected beta only for alice")
+	}
+	if !f.Enabled("beta", UserOf(&util.JwtClaim{RegisteredClaims: jwt.RegisteredClaims{Issuer: "partner"}})) {
+		t.Fatal("expected beta for the claim iss=partner")
+	}
+	if f.Enabled("dark-mode", NewUser("1", "")) || f.Enabled("unknown", NewUser("1", "")) {
+		t.Fatal("expected disabled and unknown flags off")
+	}
+	if f.Enabled("NEW_CHECKOUT", User{}) {
+		t.Fatal("expected rollout off for anonymous")
+	}
+	if f.Enabled("freeze", NewUser("1", "")) || !f.Enabled("freeze", NewUser("", "ops")) {
+		t.Fatal("expected rollout 0 only for the targeted users")
+	}
+	if err := f.Toggle(t.Context(), "freeze", false); err != nil || f.Enabled("freeze", NewUser("", "ops")) {
+		t.Fatalf("expected disabled freeze off for the targeted users but got %v", err)
+	}
+	on := 0
+	for i := range 1000 {
+		u := NewUser(strconv.Itoa(i), "")
+		enabled := f.Enabled("newCheckout", u)
+		if enabled != f.Enabled("new-checkout", u) {
+			t.Fatal("expected stable rollout")
+		}
+		if enabled {
+			on++
+		}
+	}
+	if on < 200 || on > 400 {
+		t.Fatalf("expected about 30%% of users but got %d/1000", on)
+	}
+}
+
+// test env and store replace config [passed]
+func Test_flag_sources(t *testing.T) {
+	t.Setenv("TEST_FLAG_DARK_MODE", "on")
+	t.Setenv("TEST_FLAG_BETA", "bad")
+	t.Setenv("TEST_FLAG_NEW_CHECKOUT", "100%")
+	f := test_flags(t)
+	if fs, _ := f.Lookup("darkMode"); !fs.Enabled || fs.Source != SOURCE_ENV {
+		t.Fatalf("expected darkMode of env but got %+v", fs)
+	}
+	if fs, _ := f.Lookup("beta"); fs.Source != SOURCE_CONFIG {
+		t.Fatalf("expected bad env value skipped but got %+v", fs)
+	}
+	if !f.Enabled("newCheckout", User{}) {
+		t.Fatal("expected rollout 100% for all")
+	}
+	ctx := t.Context()
+	if err := f.Toggle(ctx, "darkMode", false); err != nil {
+		t.Fatal(err.Error())
+	}
+	if fs, _ := f.Lookup("darkMode"); fs.Enabled || fs.Source != SOURCE_MEMORY {
+		t.Fatalf("expected darkMode of memory but got %+v", fs)
+	}
+	if err := f.Reset(ctx, "darkMode"); err != nil || !f.Enabled("darkMode", User{}) {
+		t.Fatalf("expected darkMode of env again but got %v", err)
+	}
+	if err := f.Set(ctx, "beta", config.FlagConfig{Rollout: Rollout(101)}); err == nil {
+		t.Fatal("expected invalid rollout")
+	}
+	if len(f.List()) != 4 {
+		t.Fatalf("unexpected flags %+v", f.List())
+	}
+}
+
+// test flags shared by redis and sql stores [passed]
+func Test_flag_stores(t *testing.T) {
+	test_store := func(t *testing.T, newStore func() Store) {
+		a, b := test_flags(t, WithStore(newStore())), test_flags(t, WithStore(newStore()))
+		ctx := context.Background()
+		if err := a.Set(ctx, "darkMode", config.FlagConfig{Enabled: true, Users: []string{"x"}}); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := a.Set(ctx, "darkMode", config.FlagConfig{Enabled: true}); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := b.Refresh(ctx); err != nil || !b.Enabled("darkMode", User{}) {
+			t.Fatalf("expected darkMode shared but got %v", err)
+		}
+		if err := b.Reset(ctx, "dark_mode"); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := a.Refresh(ctx); err != nil || a.Enabled("darkMode", User{}) {
+			t.Fatalf("expected darkMode reset but got %v", err)
+		}
+	}
+	t.Run("redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		test_store(t, func() Store {
+			return NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "")
+		})
+	})
+	t.Run("sql", func(t *testing.T) {
+		h := dbtest.New(t, dbtest.WithoutTx())
+		test_store(t, func() Store {
+			store, err := NewSQLStore(h.DB)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if err = store.Migrate(t.Context()); err != nil {
+				t.Fatal(err.Error())
+			}
+			return store
+		})
+	})
+}
+
+// test_authorize accept the bearer token only, like the jwt middleware.
+func test_authorize(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tokenStr, found := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+		if !found {
+			return echo.ErrUnauthorized
+		}
+		if _, err := util.ParseToken(tokenStr); err != nil {
+			return echo.ErrUnauthorized
+		}
+		return next(c)
+	}
+}
+
+// test gated routes and the admin peer [passed]
+func Test_flag_echo(t *testing.T) {
+	f := test_flags(t)
+	e := echo.New()
+	ep := NewEchoFlagPeer(f, test_authorize)
+	ep.ToEndpoint(router.Endpoint[echo.HandlerFunc, echo.MiddlewareFunc]{
+		Method: http.MethodGet,
+		Path:   "/beta",
+		Handler: func(c echo.Context) error {
+			return c.String(http.StatusOK, "beta")
+		},
+		PreHandlers: []echo.MiddlewareFunc{f.Gate("beta")},
+	})
+	ep.Parse(router.NewEchoPack(router.Pack{}, e.Group("")))
+	do := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	alice, err := util.GenToken(util.JwtCustomClaims{Name: "alice", ExternId: []byte("1")})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if rec := do(http.MethodGet, "/beta", "", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for anonymous but got %d", rec.Code)
+	}
+	if rec := do(http.MethodPatch, "/flags/beta", `{"enabled":true}`, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token but got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/beta", "", alice); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for alice but got %d", rec.Code)
+	}
+	if rec := do(http.MethodPatch, "/flags/beta", `{"rollout":100}`, alice); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"source":"memory"`) {
+		t.Fatalf("unexpected patch %d %s", rec.Code, rec.Body.String())
+	}
+	if fs, _ := f.Lookup("beta"); !fs.Enabled || len(fs.Users) != 1 {
+		t.Fatalf("expected patch keep the users but got %+v", fs)
+	}
+	if rec := do(http.MethodGet, "/beta", "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 after rollout 100 but got %d", rec.Code)
+	}
+	if rec := do(http.MethodPatch, "/flags/beta", `{"enabled":false}`, alice); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected patch %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/beta", "", alice); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for alice after disabled but got %d", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/flags/beta", `{"rollout":-1}`, alice); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid flag but got %d", rec.Code)
+	}
+	if rec := do(http.MethodPatch, "/flags/new-checkout", `{"rollout":0}`, alice); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected patch %d %s", rec.Code, rec.Body.String())
+	}
+	if fs, _ := f.Lookup("newCheckout"); *fs.Rollout != 0 || f.Enabled("newCheckout", NewUser("1", "")) {
+		t.Fatalf("expected rollout 0 for nobody but got %+v", fs)
+	}
+	if rec := do(http.MethodGet, "/flags/beta", "", alice); !strings.Contains(rec.Body.String(), `"active":false`) {
+		t.Fatalf("unexpected get %s", rec.Body.String())
+	}
+	if rec := do(http.MethodDelete, "/flags/beta", "", alice); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 but got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/flags/beta", "", alice); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for nothing to reset but got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/flags", "", alice); !strings.Contains(rec.Body.String(), `"name":"new_checkout"`) {
+		t.Fatalf("unexpected list %s", rec.Body.String())
+	}
+}
+
+// test the default gate take the flags set after it is built [passed]
+func Test_flag_gate_default(t *testing.T) {
+	old := Default()
+	t.Cleanup(func() { SetDefault(old) })
+	h := Gate("darkMode")(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		if err := h(c); err != nil {
+			return err.(*echo.HTTPError).Code
+		}
+		return rec.Code
+	}
+	if code := serve(); code != http.StatusNotFound {
+		t.Fatalf("expected 404 but got %d", code)
+	}
+	f := test_flags(t)
+	if err := f.Toggle(t.Context(), "darkMode", true); err != nil {
+		t.Fatal(err.Error())
+	}
+	SetDefault(f)
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("expected 200 after SetDefault but got %d", code)
+	}
+}
+
+// test the default flags follow the config loaded and reloaded after the first call [passed]
+func Test_flag_default_reload(t *testing.T) {
+	old := Default()
+	t.Cleanup(func() { SetDefault(old) })
+	SetDefault(nil)
+	config.LoadDict(config.DICTKEY_CONFIG)
+	configDict := config.GetDict(config.DICTKEY_CONFIG)
+	if c, found := configDict.Get(config.DATAKEY_CONFIG); found {
+		t.Cleanup(func() { configDict.Record(config.DATAKEY_CONFIG, c) })
+	} else {
+		t.Cleanup(func() { configDict.Remove(config.DATAKEY_CONFIG) })
+	}
+	configDict.Remove(config.DATAKEY_CONFIG)
+	if Enabled("reloaded", User{}) {
+		t.Fatal("expected unknown flag off before the config")
+	}
+	configDict.Record(config.DATAKEY_CONFIG, &config.Config{FlagsConfig: map[string]*config.FlagConfig{
+		"reloaded": {Enabled: true},
+	}})
+	if !Enabled("reloaded", User{}) {
+		t.Fatal("expected flag on after the config loaded")
+	}
+	configDict.Record(config.DATAKEY_CONFIG, &config.Config{FlagsConfig: map[string]*config.FlagConfig{
+		"reloaded": {Enabled: false},
+	}})
+	if Enabled("reloaded", User{}) {
+		t.Fatal("expected flag off after the config reloaded")
+	}
+}
@@ -0,0 +1,290 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/config"
+	database "github.com/wendisx/puzzle/pkg/db"
+	"github.com/wendisx/puzzle/pkg/palette"
+)
+
+const (
+	_default_env_prefix = "PUZZLE_FLAG"
+	_default_flag_table = "feature_flag"
+	_default_flag_key   = "puzzle:flags"
+	_flag_schema        = `create table if not exists %s (
+	name varchar(191) not null primary key,
+	spec text not null,
+	updated_at bigint not null
+)`
+)
+
+type (
+	// Source load the flags by name.
+	Source interface {
+		Name() string
+		Load(ctx context.Context) (map[string]config.FlagConfig, error)
+	}
+	// Store is a source the runtime changes are saved into.
+	Store interface {
+		Source
+		Save(ctx context.Context, name string, fc config.FlagConfig) error
+		Delete(ctx context.Context, name string) (bool, error)
+	}
+	// ConfigSource load the flags section of config.
+	ConfigSource struct {
+		c *config.Config
+	}
+	// EnvSource load the variables with prefix, like PUZZLE_FLAG_NEW_CHECKOUT.
+	EnvSource struct {
+		prefix string
+	}
+	// MemoryStore keep the flags in memory of this instance only.
+	MemoryStore struct {
+		mu    sync.RWMutex
+		flags map[string]config.FlagConfig
+	}
+	// RedisStore keep the flags as json in a redis hash, shared by all instances.
+	RedisStore struct {
+		rc  redis.Cmdable
+		key string
+	}
+	// SQLStore keep the flags as json in a sql table, call Migrate to create it.
+	SQLStore struct {
+		db      *sqlx.DB
+		dialect string
+		table   string
+	}
+	flagRow struct {
+		Name string `db:"name"`
+		Spec string `db:"spec"`
+	}
+)
+
+// NewConfigSource return the source of flags in c, nil for the config in dict when loading.
+func NewConfigSource(c *config.Config) *ConfigSource {
+	return &ConfigSource{c: c}
+}
+
+func (cs *ConfigSource) Name() string {
+	return SOURCE_CONFIG
+}
+
+func (cs *ConfigSource) Load(ctx context.Context) (map[string]config.FlagConfig, error) {
+	c := cs.c
+	if c == nil {
+		// the config is reloadable, so take the current one
+		dc, found := config.Get[*config.Config](config.DICTKEY_CONFIG, config.DATAKEY_CONFIG)
+		if !found {
+			return nil, nil
+		}
+		c = dc
+	}
+	loaded := make(map[string]config.FlagConfig, len(c.FlagsConfig))
+	for name, fc := range c.FlagsConfig {
+		if fc != nil {
+			loaded[name] = *fc
+		}
+	}
+	return loaded, nil
+}
+
+// NewEnvSource return the source of variables with prefix, empty prefix use PUZZLE_FLAG.
+// The value is a bool like true or off, a rollout like 25% or a flag in json.
+func NewEnvSource(prefix string) *EnvSource {
+	if prefix == "" {
+		prefix = _default_env_prefix
+	}
+	return &EnvSource{prefix: strings.TrimSuffix(prefix, "_") + "_"}
+}
+
+func (es *EnvSource) Name() string {
+	return SOURCE_ENV
+}
+
+func (es *EnvSource) Load(ctx context.Context) (map[string]config.FlagConfig, error) {
+	loaded := make(map[string]config.FlagConfig)
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		name, found := strings.CutPrefix(k, es.prefix)
+		if !found || name == "" {
+			continue
+		}
+		fc, err := parseEnvFlag(name, v)
+		if err != nil {
+			clog.Warn(fmt.Sprintf("skip env flag(%s) for %s", palette.Red(k), err.Error()))
+			continue
+		}
+		loaded[name] = fc
+	}
+	return loaded, nil
+}
+
+func parseEnvFlag(name, v string) (config.FlagConfig, error) {
+	var fc config.FlagConfig
+	v = strings.TrimSpace(v)
+	switch {
+	case strings.HasPrefix(v, "{"):
+		if err := json.Unmarshal([]byte(v), &fc); err != nil {
+			return fc, fmt.Errorf("%w: %s", ErrFlagInvalid, err.Error())
+		}
+	case strings.HasSuffix(v, "%"):
+		n, err := strconv.Atoi(strings.TrimSuffix(v, "%"))
+		if err != nil {
+			return fc, fmt.Errorf("%w: rollout %s", ErrFlagInvalid, v)
+		}
+		fc.Enabled, fc.Rollout = true, &n
+	case strings.EqualFold(v, "on"):
+		fc.Enabled = true
+	case strings.EqualFold(v, "off"):
+	default:
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fc, fmt.Errorf("%w: %s", ErrFlagInvalid, v)
+		}
+		fc.Enabled = enabled
+	}
+	return fc, checkFlag(name, fc)
+}
+
+// NewMemoryStore return an empty memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{flags: make(map[string]config.FlagConfig)}
+}
+
+func (ms *MemoryStore) Name() string {
+	return SOURCE_MEMORY
+}
+
+func (ms *MemoryStore) Load(ctx context.Context) (map[string]config.FlagConfig, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return maps.Clone(ms.flags), nil
+}
+
+func (ms *MemoryStore) Save(ctx context.Context, name string, fc config.FlagConfig) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.flags[name] = fc
+	return nil
+}
+
+func (ms *MemoryStore) Delete(ctx context.Context, name string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	_, found := ms.flags[name]
+	delete(ms.flags, name)
+	return found, nil
+}
+
+// NewRedisStore return a redis store in the hash of key, empty key use `puzzle:flags`.
+func NewRedisStore(rc redis.Cmdable, key string) *RedisStore {
+	if key == "" {
+		key = _default_flag_key
+	}
+	return &RedisStore{rc: rc, key: key}
+}
+
+func (rs *RedisStore) Name() string {
+	return SOURCE_REDIS
+}
+
+func (rs *RedisStore) Load(ctx context.Context) (map[string]config.FlagConfig, error) {
+	raws, err := rs.rc.HGetAll(ctx, rs.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]config.FlagConfig, len(raws))
+	for name, raw := range raws {
+		var fc config.FlagConfig
+		if err = json.Unmarshal([]byte(raw), &fc); err != nil {
+			return nil, fmt.Errorf("decode flag(%s) fail for %w", name, err)
+		}
+		loaded[name] = fc
+	}
+	return loaded, nil
+}
+
+func (rs *RedisStore) Save(ctx context.Context, name string, fc config.FlagConfig) error {
+	raw, err := json.Marshal(fc)
+	if err != nil {
+		return err
+	}
+	return rs.rc.HSet(ctx, rs.key, name, raw).Err()
+}
+
+func (rs *RedisStore) Delete(ctx context.Context, name string) (bool, error) {
+	n, err := rs.rc.HDel(ctx, rs.key, name).Result()
+	return n > 0, err
+}
+
+// NewSQLStore return a sql store on db in table `feature_flag`.
+func NewSQLStore(db *sqlx.DB) (*SQLStore, error) {
+	dialect, err := database.Dialect(db)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db, dialect: dialect, table: _default_flag_table}, nil
+}
+
+// Migrate create the flag table if not exists.
+func (ss *SQLStore) Migrate(ctx context.Context) error {
+	if _, err := ss.db.ExecContext(ctx, fmt.Sprintf(_flag_schema, ss.table)); err != nil {
+		clog.Error(fmt.Sprintf("migrate flags(%s) fail for %s", palette.Red(ss.table), err.Error()))
+		return err
+	}
+	return nil
+}
+
+func (ss *SQLStore) Name() string {
+	return SOURCE_SQL
+}
+
+func (ss *SQLStore) Load(ctx context.Context) (map[string]config.FlagConfig, error) {
+	var rows []flagRow
+	if err := ss.db.SelectContext(ctx, &rows, fmt.Sprintf("select name, spec from %s", ss.table)); err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]config.FlagConfig, len(rows))
+	for _, row := range rows {
+		var fc config.FlagConfig
+		if err := json.Unmarshal([]byte(row.Spec), &fc); err != nil {
+			return nil, fmt.Errorf("decode flag(%s) fail for %w", row.Name, err)
+		}
+		loaded[row.Name] = fc
+	}
+	return loaded, nil
+}
+
+func (ss *SQLStore) Save(ctx context.Context, name string, fc config.FlagConfig) error {
+	raw, err := json.Marshal(fc)
+	if err != nil {
+		return err
+	}
+	sqlStr := fmt.Sprintf("insert into %s (name, spec, updated_at) values (?, ?, ?) on conflict (name) do update set spec = excluded.spec, updated_at = excluded.updated_at", ss.table)
+	if ss.dialect == database.DIALECT_MYSQL {
+		sqlStr = fmt.Sprintf("insert into %s (name, spec, updated_at) values (?, ?, ?) on duplicate key update spec = values(spec), updated_at = values(updated_at)", ss.table)
+	}
+	_, err = ss.db.ExecContext(ctx, ss.db.Rebind(sqlStr), name, string(raw), time.Now().UnixMilli())
+	return err
+}
+
+func (ss *SQLStore) Delete(ctx context.Context, name string) (bool, error) {
+	res, err := ss.db.ExecContext(ctx, ss.db.Rebind(fmt.Sprintf("delete from %s where name = ?", ss.table)), name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -7,17 +7,43 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/flags"
 	"github.com/wendisx/puzzle/pkg/server"
 	"github.com/wendisx/puzzle/pkg/util"
 )
 
-/*  auth middleware for echo */
+const (
+	_query_access_token = "access_token"
+)
+
+/*  auth middleware for echo, the token only comes from the header `Authorization` */
 func (m EchoMiddleware) SimpleJwtAuth() echo.MiddlewareFunc {
+	return jwtAuth(func(c echo.Context) string {
+		return c.Request().Header.Get("Authorization")
+	})
+}
+
+/*
+auth middleware for the event stream endpoints only, like router.NewEchoEventPeer, the
+EventSource and WebSocket of browsers can not set headers so the token may also come
+from the query `access_token`. Never use it for other routes, urls with tokens end up
+in access logs and browser history.
+*/
+func (m EchoMiddleware) StreamJwtAuth() echo.MiddlewareFunc {
+	return jwtAuth(func(c echo.Context) string {
+		tokenStr := c.Request().Header.Get("Authorization")
+		if token := c.QueryParam(_query_access_token); tokenStr == "" && token != "" {
+			tokenStr = "Bearer " + token
+		}
+		return tokenStr
+	})
+}
+
+func jwtAuth(tokenOf func(c echo.Context) string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			res := server.NewEchoResponder(c)
-			tokenStr := c.Request().Header.Get("Authorization")
-			clog.Debug(tokenStr)
+			tokenStr := tokenOf(c)
 			if tokenStr == "" || !strings.HasPrefix(tokenStr, "Bearer ") {
 				return res.Error(http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
 			}
@@ -30,6 +56,7 @@ func (m EchoMiddleware) SimpleJwtAuth() echo.MiddlewareFunc {
 			clog.Info(fmt.Sprintf("context.user{id=%s,name=%s}", string(jwtClaim.ExternId), jwtClaim.Name))
 			c.Set("userId", jwtClaim.ExternId)
 			c.Set("name", jwtClaim.Name)
+			c.Set("claims", jwtClaim)
 			return next(c)
 		}
 	}
@@ -55,3 +82,8 @@ func (m EchoMiddleware) ParseAndCheckBody(enable bool, s interface{}) echo.Middl
 		}
 	}
 }
+
+/* feature flag middleware for echo, 404 if the flag is disabled for the user */
+func (m EchoMiddleware) FeatureGate(name string) echo.MiddlewareFunc {
+	return flags.Gate(name)
+}
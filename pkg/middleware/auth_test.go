@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wendisx/puzzle/pkg/util"
+)
+
+// test the query token only accepted by the stream auth [passed]
+func Test_jwt_auth_query(t *testing.T) {
+	token, err := util.GenToken(util.JwtCustomClaims{Name: "alice", ExternId: []byte("1")})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	e := echo.New()
+	ok := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+	e.GET("/api", ok, EchoMiddleware{}.SimpleJwtAuth())
+	e.GET("/events", ok, EchoMiddleware{}.StreamJwtAuth())
+	do := func(target, header string) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if header != "" {
+			req.Header.Set("Authorization", "Bearer "+header)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := do("/api?access_token="+token, ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected query token rejected by SimpleJwtAuth but got %d", code)
+	}
+	if code := do("/api", token); code != http.StatusOK {
+		t.Fatalf("expected header token accepted but got %d", code)
+	}
+	if code := do("/events?access_token="+token, ""); code != http.StatusOK {
+		t.Fatalf("expected query token accepted by StreamJwtAuth but got %d", code)
+	}
+}
@@ -1,7 +1,8 @@
 package middleware
 
 const (
-	DEFAULT_RESPONDER_KEY = "responder"
+	DEFAULT_RESPONDER_KEY  = "responder"
+	DEFAULT_REQUEST_ID_KEY = "requestId"
 )
 
 type (
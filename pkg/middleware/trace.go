@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/wendisx/puzzle/pkg/event"
+)
+
+/* request id middleware for echo */
+// RequestID take the request id from header `X-Request-Id` or generate one, echo it in the
+// response header and carry it in the request context, so events published by
+// event.PublishContext(c.Request().Context(), e) are correlated to the request.
+func (m EchoMiddleware) RequestID() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			id := req.Header.Get(echo.HeaderXRequestID)
+			if id == "" {
+				id = uuid.NewString()
+			}
+			c.Response().Header().Set(echo.HeaderXRequestID, id)
+			c.SetRequest(req.WithContext(event.WithRequestID(req.Context(), id)))
+			c.Set(DEFAULT_REQUEST_ID_KEY, id)
+			return next(c)
+		}
+	}
+}
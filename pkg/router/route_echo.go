@@ -8,6 +8,7 @@ import (
 	"github.com/labstack/echo/v4"
 	echoSwagger "github.com/swaggo/echo-swagger"
 	"github.com/wendisx/puzzle/pkg/clog"
+	database "github.com/wendisx/puzzle/pkg/db"
 	"github.com/wendisx/puzzle/pkg/palette"
 )
 
@@ -15,8 +16,10 @@ const (
 	_default_echo_gateway = ""
 
 	// default peers or routes path
-	_echo_swagger_path = "/swagger/*"
-	_echo_check_path   = "/ping"
+	_echo_swagger_path   = "/swagger/*"
+	_echo_check_path     = "/ping"
+	_echo_liveness_path  = "/healthz"
+	_echo_readiness_path = "/readyz"
 )
 
 var (
@@ -174,6 +177,9 @@ func NewEchoSwagPeer() EchoPeer {
 	return ep
 }
 
+// NewEchoCheckPeer return the peer with /ping, /healthz and /readyz.
+// Liveness only reports the process is serving, readiness aggregates all
+// health checks registered by the database init functions.
 func NewEchoCheckPeer() EchoPeer {
 	ep := EchoPeer{}
 	ep.ToEndpoint(Endpoint[echo.HandlerFunc, echo.MiddlewareFunc]{
@@ -183,5 +189,25 @@ func NewEchoCheckPeer() EchoPeer {
 			return c.String(http.StatusOK, "pong")
 		},
 	})
+	ep.ToEndpoint(Endpoint[echo.HandlerFunc, echo.MiddlewareFunc]{
+		Method: http.MethodGet,
+		Path:   _echo_liveness_path,
+		Handler: func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]any{
+				"status": database.HEALTH_STATUS_UP,
+			})
+		},
+	})
+	ep.ToEndpoint(Endpoint[echo.HandlerFunc, echo.MiddlewareFunc]{
+		Method: http.MethodGet,
+		Path:   _echo_readiness_path,
+		Handler: func(c echo.Context) error {
+			report := database.CheckHealth(c.Request().Context())
+			if report.Status == database.HEALTH_STATUS_DOWN {
+				return c.JSON(http.StatusServiceUnavailable, report)
+			}
+			return c.JSON(http.StatusOK, report)
+		},
+	})
 	return ep
 }
@@ -0,0 +1,457 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/event"
+	"github.com/wendisx/puzzle/pkg/palette"
+	"golang.org/x/net/websocket"
+)
+
+/*
+	router.event -- [submodule]
+EventHub relays the events of a bus to browsers by `/events` (Server-Sent Events) and
+`/ws` (WebSocket). The peer takes the pre handlers such as the jwt middleware, the
+`userId` they set in the context decides which user events the client receives.
+Browsers can not set headers for both, so StreamJwtAuth also takes the query
+`access_token`, keep it to this peer:
+
+	hub := router.NewEventHub(router.WithHubTopics("order.**", "notice.*"))
+	route.ToPeer(router.NewEchoEventPeer(hub, middleware.EchoMiddleware{}.StreamJwtAuth()))
+
+	// only delivered to the user, events without the header go to everyone
+	d.Publish(event.NewTypedEvent("order.paid", paid, event.WithHeader(router.EVENT_HEADER_USER, userId)))
+
+Clients choose topics by the query `topic=order.*&topic=notice.*` (default all topics
+of hub). Every event gets an increasing id, the latest ones are kept in a bounded
+replay buffer, and a client reconnecting with the header `Last-Event-ID` (or the query
+`last_event_id`) receives the missed events still in the buffer. Each connection has
+its own send buffer, a client too slow to drain it is disconnected so it can resume
+by its last event id instead of blocking others. Idle connections get heartbeats,
+a comment line for SSE and `{"type":"heartbeat"}` for WebSocket. The hub subscribes
+to the bus once and matches its topics itself, so an event of overlapping topics like
+`order.*` and `order.**` is sent once. WebSocket accepts the same origin by default,
+other origins need WithHubOrigins, or WithHubAnyOrigin to accept all of them.
+*/
+
+const (
+	EVENT_HEADER_USER = "user-id"
+
+	_echo_sse_path = "/events"
+	_echo_ws_path  = "/ws"
+
+	_default_hub_buffer    = 64
+	_default_hub_replay    = 256
+	_default_hub_heartbeat = 15 * time.Second
+	_hub_user_key          = "userId"
+	_hub_topic_query       = "topic"
+	_hub_last_id_header    = "Last-Event-ID"
+	_hub_last_id_query     = "last_event_id"
+	_hub_type_event        = "event"
+	_hub_type_heartbeat    = "heartbeat"
+)
+
+type (
+	// Functional event hub configuration.
+	EventHubOption func(h *EventHub)
+	// EventHub fan out events of bus to the connected clients.
+	EventHub struct {
+		bus       *event.Bus
+		topics    []string
+		buffer    int
+		replay    int
+		heartbeat time.Duration
+		origins   []string
+		anyOrigin bool
+		mu        sync.Mutex
+		seq       uint64
+		ring      []*hubMessage // latest events, oldest first
+		clients   map[*hubClient]struct{}
+		sub       *event.Subscription
+	}
+	// hubMessage is the json sent to clients
+	hubMessage struct {
+		Id        uint64          `json:"id,omitempty"`
+		Type      string          `json:"type"`
+		Topic     string          `json:"topic,omitempty"`
+		Name      string          `json:"name,omitempty"`
+		EventId   string          `json:"event_id,omitempty"`
+		Timestamp int64           `json:"timestamp,omitempty"` // unix milli
+		Data      json.RawMessage `json:"data,omitempty"`
+		user      string
+	}
+	hubClient struct {
+		user   string
+		topics []string
+		ch     chan *hubMessage
+		kicked chan struct{} // closed when too slow or hub closed
+	}
+	// listener of hub on bus
+	hubListener struct {
+		h *EventHub
+	}
+	// event carrying the metadata sent to clients
+	hubEvent interface {
+		Name() string
+		ID() string
+		Topic() string
+		Header(key string) string
+		Timestamp() time.Time
+	}
+)
+
+// WithHubBus set the bus relayed, default event.DefaultBus().
+func WithHubBus(b *event.Bus) EventHubOption {
+	return func(h *EventHub) {
+		h.bus = b
+	}
+}
+
+// WithHubTopics set the topic patterns relayed, default all.
+func WithHubTopics(topics ...string) EventHubOption {
+	return func(h *EventHub) {
+		h.topics = topics
+	}
+}
+
+// WithHubBuffer set the send buffer of each connection.
+func WithHubBuffer(n int) EventHubOption {
+	return func(h *EventHub) {
+		h.buffer = max(n, 1)
+	}
+}
+
+// WithHubReplay set the number of latest events kept for resuming, 0 to disable.
+func WithHubReplay(n int) EventHubOption {
+	return func(h *EventHub) {
+		h.replay = max(n, 0)
+	}
+}
+
+// WithHubHeartbeat set the heartbeat interval.
+func WithHubHeartbeat(d time.Duration) EventHubOption {
+	return func(h *EventHub) {
+		h.heartbeat = d
+	}
+}
+
+// WithHubOrigins set the hosts allowed as websocket origin besides the host of hub.
+func WithHubOrigins(hosts ...string) EventHubOption {
+	return func(h *EventHub) {
+		h.origins = hosts
+	}
+}
+
+// WithHubAnyOrigin accept websocket of all origins, any page holding a token can connect.
+func WithHubAnyOrigin() EventHubOption {
+	return func(h *EventHub) {
+		h.anyOrigin = true
+	}
+}
+
+// NewEventHub return a hub subscribed to the bus.
+func NewEventHub(opts ...EventHubOption) *EventHub {
+	h := &EventHub{
+		topics:    []string{"**"},
+		buffer:    _default_hub_buffer,
+		replay:    _default_hub_replay,
+		heartbeat: _default_hub_heartbeat,
+		clients:   make(map[*hubClient]struct{}),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.bus == nil {
+		h.bus = event.DefaultBus()
+	}
+	// one subscription, the overlapping topics of hub are matched by hubListener
+	h.sub = h.bus.Subscribe("**", hubListener{h: h})
+	return h
+}
+
+// NewEchoEventPeer return the peer with /events and /ws of hub, pre handlers apply to both.
+func NewEchoEventPeer(hub *EventHub, pre ...echo.MiddlewareFunc) EchoPeer {
+	ep := EchoPeer{}
+	ep.ToEndpoint(Endpoint[echo.HandlerFunc, echo.MiddlewareFunc]{
+		Method:      http.MethodGet,
+		Path:        _echo_sse_path,
+		Handler:     hub.serveSSE,
+		PreHandlers: pre,
+	})
+	ep.ToEndpoint(Endpoint[echo.HandlerFunc, echo.MiddlewareFunc]{
+		Method:      http.MethodGet,
+		Path:        _echo_ws_path,
+		Handler:     hub.serveWS,
+		PreHandlers: pre,
+	})
+	return ep
+}
+
+// Clients return the number of connected clients.
+func (h *EventHub) Clients() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// Close unsubscribe the hub and disconnect all clients.
+func (h *EventHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sub != nil {
+		h.sub.Unsubscribe()
+		h.sub = nil
+	}
+	for c := range h.clients {
+		h.kick(c)
+	}
+}
+
+func (h *EventHub) serveSSE(c echo.Context) error {
+	client := h.newClient(c)
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	backlog := h.attach(client, lastEventId(c))
+	defer h.detach(client)
+	write := func(m *hubMessage) error {
+		raw, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", m.Id, m.Topic, raw)
+		return err
+	}
+	for _, m := range backlog {
+		if err := write(m); err != nil {
+			return nil
+		}
+	}
+	res.Flush()
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-client.kicked:
+			return nil
+		case m := <-client.ch:
+			err = write(m)
+		case <-ticker.C:
+			_, err = fmt.Fprint(res, ": heartbeat\n\n")
+		}
+		if err != nil {
+			return nil
+		}
+		res.Flush()
+	}
+}
+
+func (h *EventHub) serveWS(c echo.Context) error {
+	client := h.newClient(c)
+	lastId := lastEventId(c)
+	websocket.Server{
+		Handshake: h.checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			backlog := h.attach(client, lastId)
+			defer h.detach(client)
+			for _, m := range backlog {
+				if websocket.JSON.Send(ws, m) != nil {
+					return
+				}
+			}
+			// clients only send close frames, read to notice the disconnection
+			gone := make(chan struct{})
+			go func() {
+				defer close(gone)
+				var discard string
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			}()
+			ticker := time.NewTicker(h.heartbeat)
+			defer ticker.Stop()
+			for {
+				var err error
+				select {
+				case <-gone:
+					return
+				case <-client.kicked:
+					return
+				case m := <-client.ch:
+					err = websocket.JSON.Send(ws, m)
+				case <-ticker.C:
+					err = websocket.JSON.Send(ws, &hubMessage{Type: _hub_type_heartbeat})
+				}
+				if err != nil {
+					return
+				}
+			}
+		},
+	}.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// checkOrigin accept clients without origin, like not browsers, of the same host as the request
+// and of the allowed origin hosts.
+func (h *EventHub) checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get(echo.HeaderOrigin)
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	allowed := h.anyOrigin || strings.EqualFold(u.Host, r.Host) || slices.ContainsFunc(h.origins, func(host string) bool {
+		return strings.EqualFold(host, u.Host)
+	})
+	if !allowed {
+		return fmt.Errorf("origin %s not allowed", origin)
+	}
+	config.Origin = u
+	return nil
+}
+
+func (h *EventHub) newClient(c echo.Context) *hubClient {
+	var topics []string
+	for _, t := range c.QueryParams()[_hub_topic_query] {
+		for p := range strings.SplitSeq(t, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				topics = append(topics, p)
+			}
+		}
+	}
+	return &hubClient{
+		user:   userOf(c),
+		topics: topics,
+		ch:     make(chan *hubMessage, h.buffer),
+		kicked: make(chan struct{}),
+	}
+}
+
+// attach register the client and return the missed events after lastId.
+func (h *EventHub) attach(c *hubClient, lastId uint64) []*hubMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+	var backlog []*hubMessage
+	if lastId == 0 {
+		return backlog
+	}
+	for _, m := range h.ring {
+		if m.Id > lastId && c.want(m) {
+			backlog = append(backlog, m)
+		}
+	}
+	return backlog
+}
+
+func (h *EventHub) detach(c *hubClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+}
+
+// broadcast keep the message for replay and send it to the clients without blocking.
+func (h *EventHub) broadcast(m *hubMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	m.Id = h.seq
+	if h.replay > 0 {
+		if len(h.ring) == h.replay {
+			h.ring = h.ring[1:]
+		}
+		h.ring = append(h.ring, m)
+	}
+	for c := range h.clients {
+		if !c.want(m) {
+			continue
+		}
+		select {
+		case c.ch <- m:
+		default:
+			clog.Warn(fmt.Sprintf("event client(%s) too slow, disconnected at event %s", palette.Red(c.user), palette.Red(m.Id)))
+			h.kick(c)
+		}
+	}
+}
+
+// kick disconnect the client, require h.mu held.
+func (h *EventHub) kick(c *hubClient) {
+	delete(h.clients, c)
+	close(c.kicked)
+}
+
+func (c *hubClient) want(m *hubMessage) bool {
+	if m.user != "" && m.user != c.user {
+		return false
+	}
+	if len(c.topics) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(c.topics, func(p string) bool {
+		return event.MatchTopic(p, m.Topic)
+	})
+}
+
+// Want match the topics of hub.
+func (l hubListener) Want(e event.Event) bool {
+	var topic string
+	if te, ok := e.(interface{ Topic() string }); ok {
+		topic = te.Topic()
+	}
+	return slices.ContainsFunc(l.h.topics, func(p string) bool {
+		return event.MatchTopic(p, topic)
+	})
+}
+
+func (l hubListener) Listen(e event.Event) error {
+	data, err := json.Marshal(e.Record())
+	if err != nil {
+		return err
+	}
+	m := &hubMessage{Type: _hub_type_event, Data: data}
+	if he, ok := e.(hubEvent); ok {
+		m.Topic, m.Name, m.EventId = he.Topic(), he.Name(), he.ID()
+		m.Timestamp = he.Timestamp().UnixMilli()
+		m.user = he.Header(EVENT_HEADER_USER)
+	}
+	l.h.broadcast(m)
+	return nil
+}
+
+func userOf(c echo.Context) string {
+	switch id := c.Get(_hub_user_key).(type) {
+	case string:
+		return id
+	case []byte:
+		return string(id)
+	}
+	return ""
+}
+
+func lastEventId(c echo.Context) uint64 {
+	str := c.Request().Header.Get(_hub_last_id_header)
+	if str == "" {
+		str = c.QueryParam(_hub_last_id_query)
+	}
+	id, _ := strconv.ParseUint(str, 10, 64)
+	return id
+}
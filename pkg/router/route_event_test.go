@@ -0,0 +1,221 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wendisx/puzzle/pkg/event"
+	"golang.org/x/net/websocket"
+)
+
+type notice struct {
+	Text string `json:"text"`
+}
+
+func test_event_server(t *testing.T, opts ...EventHubOption) (*EventHub, *event.Dispatcher, *httptest.Server) {
+	b := event.NewBus()
+	hub := NewEventHub(append([]EventHubOption{WithHubBus(b)}, opts...)...)
+	t.Cleanup(hub.Close)
+	e := echo.New()
+	// stands for the jwt middleware
+	auth := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if user := c.QueryParam("user"); user != "" {
+				c.Set("userId", []byte(user))
+			}
+			return next(c)
+		}
+	}
+	NewEchoEventPeer(hub, auth).Parse(NewEchoPack(Pack{}, e.Group("")))
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return hub, event.NewDispatcher(event.WithSource(b.Subscriptions)), srv
+}
+
+func test_wait_clients(t *testing.T, hub *EventHub, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); hub.Clients() != n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d clients but got %d", n, hub.Clients())
+		}
+	}
+}
+
+// read the next sse event, skipping heartbeats
+func test_sse_next(t *testing.T, r *bufio.Reader) (id string, m hubMessage) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if v, ok := strings.CutPrefix(line, "id: "); ok {
+			id = v
+		}
+		if v, ok := strings.CutPrefix(line, "data: "); ok {
+			if err = json.Unmarshal([]byte(v), &m); err != nil {
+				t.Fatal(err.Error())
+			}
+			return id, m
+		}
+	}
+}
+
+func test_sse_open(t *testing.T, url string, lastId string) *bufio.Reader {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, url, nil)
+	if lastId != "" {
+		req.Header.Set("Last-Event-ID", lastId)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if ct := res.Header.Get(echo.HeaderContentType); ct != "text/event-stream" {
+		t.Fatalf("expected event stream but got %s", ct)
+	}
+	return bufio.NewReader(res.Body)
+}
+
+// test sse filters topics and users and resumes by last event id [passed]
+func Test_event_peer_sse(t *testing.T) {
+	hub, d, srv := test_event_server(t)
+	alice := test_sse_open(t, srv.URL+"/events?topic=order.*&user=alice", "")
+	bob := test_sse_open(t, srv.URL+"/events?user=bob", "")
+	test_wait_clients(t, hub, 2)
+	d.PublishSync(event.NewTypedEvent("order.paid", notice{Text: "to bob"}, event.WithHeader(EVENT_HEADER_USER, "bob")))
+	d.PublishSync(event.NewTypedEvent("notice.new", notice{Text: "all"}))
+	d.PublishSync(event.NewTypedEvent("order.paid", notice{Text: "to alice"}, event.WithHeader(EVENT_HEADER_USER, "alice")))
+	if id, m := test_sse_next(t, alice); id != "3" || m.Topic != "order.paid" || string(m.Data) != `{"text":"to alice"}` {
+		t.Fatalf("expected event 3 for alice but got %s %+v", id, m)
+	}
+	if id, m := test_sse_next(t, bob); id != "1" || string(m.Data) != `{"text":"to bob"}` {
+		t.Fatalf("expected event 1 for bob but got %s %+v", id, m)
+	}
+	if id, m := test_sse_next(t, bob); id != "2" || m.Name != "notice.new" || m.Type != _hub_type_event || m.EventId == "" {
+		t.Fatalf("expected event 2 for bob but got %s %+v", id, m)
+	}
+	// bob reconnects after event 1 and gets the missed ones in the replay buffer
+	again := test_sse_open(t, srv.URL+"/events?user=bob", "1")
+	if id, _ := test_sse_next(t, again); id != "2" {
+		t.Fatalf("expected replayed event 2 but got %s", id)
+	}
+	d.PublishSync(event.NewTypedEvent("notice.new", notice{Text: "live"}))
+	if id, _ := test_sse_next(t, again); id != "4" {
+		t.Fatalf("expected live event 4 but got %s", id)
+	}
+}
+
+// test sse heartbeat [passed]
+func Test_event_peer_heartbeat(t *testing.T) {
+	_, _, srv := test_event_server(t, WithHubHeartbeat(10*time.Millisecond))
+	r := test_sse_open(t, srv.URL+"/events", "")
+	line, err := r.ReadString('\n')
+	if err != nil || line != ": heartbeat\n" {
+		t.Fatalf("expected heartbeat but got %q %v", line, err)
+	}
+}
+
+// test websocket relays events with replay [passed]
+func Test_event_peer_ws(t *testing.T) {
+	hub, d, srv := test_event_server(t, WithHubOrigins("example.com"))
+	d.PublishSync(event.NewTypedEvent("order.paid", notice{Text: "missed"}))
+	wsUrl := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?topic=order.**"
+	if _, err := websocket.Dial(wsUrl, "", "http://evil.com"); err == nil {
+		t.Fatal("expected origin rejected")
+	}
+	same, err := websocket.Dial(wsUrl, "", srv.URL)
+	if err != nil {
+		t.Fatalf("expected the same origin accepted but got %v", err)
+	}
+	same.Close()
+	test_wait_clients(t, hub, 0)
+	ws, err := websocket.Dial(wsUrl, "", "http://example.com")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer ws.Close()
+	test_wait_clients(t, hub, 1)
+	d.PublishSync(event.NewTypedEvent("user.created", notice{Text: "skipped"}))
+	d.PublishSync(event.NewTypedEvent("order.item.added", notice{Text: "live"}))
+	var m hubMessage
+	if err = websocket.JSON.Receive(ws, &m); err != nil || m.Id != 3 || m.Topic != "order.item.added" {
+		t.Fatalf("expected event 3 but got %+v %v", m, err)
+	}
+	ws.Close()
+	test_wait_clients(t, hub, 0)
+	// resume
+	ws, err = websocket.Dial(wsUrl+"&last_event_id=1", "", "http://example.com")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer ws.Close()
+	if err = websocket.JSON.Receive(ws, &m); err != nil || m.Id != 3 {
+		t.Fatalf("expected replayed event 3 but got %+v %v", m, err)
+	}
+}
+
+// test slow clients are disconnected and the replay buffer is bounded [passed]
+func Test_event_hub_backpressure(t *testing.T) {
+	b := event.NewBus()
+	hub := NewEventHub(WithHubBus(b), WithHubBuffer(1), WithHubReplay(2))
+	defer hub.Close()
+	d := event.NewDispatcher(event.WithSource(b.Subscriptions))
+	slow := &hubClient{ch: make(chan *hubMessage, 1), kicked: make(chan struct{})}
+	hub.attach(slow, 0)
+	for range 3 {
+		d.PublishSync(event.NewTypedEvent("notice.new", notice{}))
+	}
+	select {
+	case <-slow.kicked:
+	default:
+		t.Fatal("expected slow client kicked")
+	}
+	if hub.Clients() != 0 {
+		t.Fatalf("expected no clients but got %d", hub.Clients())
+	}
+	backlog := hub.attach(&hubClient{ch: make(chan *hubMessage, 1), kicked: make(chan struct{})}, 1)
+	if len(backlog) != 2 || backlog[0].Id != 2 || backlog[1].Id != 3 {
+		t.Fatalf("expected events 2,3 but got %+v", backlog)
+	}
+}
+
+// test cross origins are rejected by default and accepted by WithHubAnyOrigin [passed]
+func Test_event_peer_origin(t *testing.T) {
+	_, _, srv := test_event_server(t)
+	wsUrl := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	if _, err := websocket.Dial(wsUrl, "", "http://evil.com"); err == nil {
+		t.Fatal("expected cross origin rejected by default")
+	}
+	_, _, srv = test_event_server(t, WithHubAnyOrigin())
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", "", "http://evil.com")
+	if err != nil {
+		t.Fatalf("expected any origin accepted but got %v", err)
+	}
+	ws.Close()
+}
+
+// test an event of overlapping topics is relayed once [passed]
+func Test_event_hub_overlap(t *testing.T) {
+	b := event.NewBus()
+	hub := NewEventHub(WithHubBus(b), WithHubTopics("order.*", "order.**", "**"))
+	defer hub.Close()
+	d := event.NewDispatcher(event.WithSource(b.Subscriptions))
+	c := &hubClient{ch: make(chan *hubMessage, 4), kicked: make(chan struct{})}
+	hub.attach(c, 0)
+	d.PublishSync(event.NewTypedEvent("order.paid", notice{}))
+	if len(c.ch) != 1 {
+		t.Fatalf("expected the event once but got %d", len(c.ch))
+	}
+	if m := <-c.ch; m.Id != 1 {
+		t.Fatalf("expected event 1 but got %d", m.Id)
+	}
+}
@@ -2,15 +2,19 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/config"
 	"github.com/wendisx/puzzle/pkg/palette"
 )
 
@@ -21,6 +25,10 @@ const (
 	_handler_echo = "Echo"
 )
 
+var (
+	ErrServerStopping = errors.New("web server is stopping")
+)
+
 type (
 	// WebServer is the basic abstraction for web server.
 	// P represents the route pack.
@@ -30,31 +38,115 @@ type (
 		Start()             // starting server
 		Stop()              // stopping server
 	}
+	// Reconfigurable is a web server applying the changed server config while running,
+	// like on a config reload.
+	Reconfigurable interface {
+		Reconfigure(sc config.ServerConfig) error
+	}
 	webServer[H http.Handler] struct {
-		h    H
-		s    *http.Server
-		quit chan os.Signal
-		exit chan struct{}
+		h        H
+		mu       sync.Mutex // guards s, ln, grace and stopping after start
+		s        *http.Server
+		ln       net.Listener   // listened for s by Reconfigure, if its address changed
+		grace    time.Duration  // shutdown grace period
+		stopping bool           // no more Reconfigure
+		hooks    []func() error // run after shutdown, like closing databases
+		quit     chan os.Signal
+		exit     chan struct{}
 	}
 )
 
 func (ws *webServer[H]) startServer() {
-	go ws.stopServer(_default_quit_delay)
-	clog.Info(fmt.Sprintf("web server listen %s", palette.Put(palette.RGB_SKYBLUE, palette.RGB_DEFAULT).Add(color.Underline).Sprint(ws.s.Addr)))
-	if err := ws.s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		clog.Error(fmt.Sprintf("web server start fail for %s", err.Error()))
-		close(ws.exit)
+	go ws.stopServer()
+	ws.mu.Lock()
+	s := ws.s
+	ws.mu.Unlock()
+	for {
+		if err := ws.serve(s); err != nil && err != http.ErrServerClosed {
+			clog.Error(fmt.Sprintf("web server start fail for %s", err.Error()))
+			close(ws.exit)
+			break
+		}
+		// closed for Reconfigure, serve the new one
+		ws.mu.Lock()
+		next := ws.s
+		ws.mu.Unlock()
+		if next == s {
+			break
+		}
+		s = next
 	}
 	<-ws.exit
 	clog.Info("web server exit")
 }
 
-func (ws *webServer[H]) stopServer(delay time.Duration) {
+// serve s on the listener of Reconfigure, or listen its address.
+func (ws *webServer[H]) serve(s *http.Server) error {
+	ws.mu.Lock()
+	ln := ws.ln
+	ws.ln = nil
+	ws.mu.Unlock()
+	clog.Info(fmt.Sprintf("web server listen %s", palette.Put(palette.RGB_SKYBLUE, palette.RGB_DEFAULT).Add(color.Underline).Sprint(s.Addr)))
+	if ln == nil {
+		return s.ListenAndServe()
+	}
+	return s.Serve(ln)
+}
+
+// Reconfigure apply sc by a new http.Server, the old one stops accepting and finishes its
+// requests in its grace period. A new address is listened first, so the old server is kept
+// if it is not available.
+func (ws *webServer[H]) Reconfigure(sc config.ServerConfig) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.stopping {
+		return ErrServerStopping
+	}
+	old, grace := ws.s, ws.grace
+	next := &http.Server{Handler: old.Handler}
+	nextGrace := configure(next, sc)
+	if sameServer(old, next) && grace == nextGrace {
+		// like only the jwt secret changed
+		return nil
+	}
+	if next.Addr != old.Addr {
+		ln, err := net.Listen("tcp", next.Addr)
+		if err != nil {
+			return err
+		}
+		if ws.ln != nil {
+			ws.ln.Close()
+		}
+		ws.ln = ln
+	}
+	ws.s, ws.grace = next, nextGrace
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), grace)
+		defer cancel()
+		if err := old.Shutdown(ctx); err != nil {
+			clog.Warn(fmt.Sprintf("web server(%s) replaced without finishing requests after %s", palette.Red(old.Addr), palette.Red(grace)))
+		}
+	}()
+	clog.Info(fmt.Sprintf("web server reconfigured on %s", palette.SkyBlue(next.Addr)))
+	return nil
+}
+
+func (ws *webServer[H]) stopServer() {
 	signal.Notify(ws.quit, syscall.SIGTERM, os.Interrupt, syscall.SIGQUIT)
 	<-ws.quit
+	ws.mu.Lock()
+	ws.stopping = true
+	s, delay := ws.s, ws.grace
+	if ws.ln != nil {
+		ws.ln.Close()
+		ws.ln = nil
+	}
+	ws.mu.Unlock()
 	ctx, cancle := context.WithTimeout(context.Background(), delay)
 	defer cancle()
-	if err := ws.s.Shutdown(ctx); err != nil {
+	err := s.Shutdown(ctx)
+	ws.runHooks()
+	if err != nil {
 		clog.Error(fmt.Sprintf("web server shutdown fail after %s", palette.Red(delay)))
 		os.Exit(1)
 	}
@@ -62,6 +154,41 @@ func (ws *webServer[H]) stopServer(delay time.Duration) {
 	close(ws.exit)
 }
 
+// runHooks run the shutdown hooks in reverse order of adding.
+func (ws *webServer[H]) runHooks() {
+	for i := len(ws.hooks) - 1; i >= 0; i-- {
+		if err := ws.hooks[i](); err != nil {
+			clog.Error(fmt.Sprintf("web server shutdown hook fail for %s", err.Error()))
+		}
+	}
+}
+
+// configure apply the server config to the http.Server before start.
+func (ws *webServer[H]) configure(sc config.ServerConfig) {
+	ws.grace = configure(ws.s, sc)
+}
+
+// sameServer report whether a and b are configured the same.
+func sameServer(a, b *http.Server) bool {
+	return a.Addr == b.Addr && a.ReadTimeout == b.ReadTimeout && a.ReadHeaderTimeout == b.ReadHeaderTimeout &&
+		a.WriteTimeout == b.WriteTimeout && a.IdleTimeout == b.IdleTimeout && a.MaxHeaderBytes == b.MaxHeaderBytes
+}
+
+// configure apply the server config to s and return the shutdown grace period.
+func configure(s *http.Server, sc config.ServerConfig) time.Duration {
+	s.Addr = sc.ListenAddr()
+	s.ReadTimeout = time.Duration(sc.ReadTimeout) * time.Second
+	s.ReadHeaderTimeout = time.Duration(sc.ReadHeaderTimeout) * time.Second
+	s.WriteTimeout = time.Duration(sc.WriteTimeout) * time.Second
+	s.IdleTimeout = time.Duration(sc.IdleTimeout) * time.Second
+	s.MaxHeaderBytes = sc.HeaderBytes()
+	if sc.ShutdownTimeout > 0 {
+		return time.Duration(sc.ShutdownTimeout) * time.Second
+	}
+	return _default_quit_delay
+}
+
+// InitWebServer return the web server of handler, configured by the server config in the config dict if loaded.
 func InitWebServer(handler string) WebServer {
 	clog.Info(fmt.Sprintf("web server with handler type(%s)", palette.Green(handler)))
 	switch handler {
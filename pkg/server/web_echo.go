@@ -9,6 +9,8 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/config"
+	database "github.com/wendisx/puzzle/pkg/db"
 	"github.com/wendisx/puzzle/pkg/errors"
 	"github.com/wendisx/puzzle/pkg/router"
 )
@@ -31,7 +33,9 @@ type (
 	}
 )
 
-func InitEchoServer() *EchoServer {
+// InitEchoServer return the echo server configured by the server config in the config dict,
+// or by the defaults if no config is loaded, opts like WithServerConfig are applied at last.
+func InitEchoServer(opts ...EchoServerOption) *EchoServer {
 	e := echo.New()
 	// some default config for echo instance
 	e.HTTPErrorHandler = _default_error_handler
@@ -55,18 +59,38 @@ func InitEchoServer() *EchoServer {
 	}))
 	es := &EchoServer{
 		webServer: webServer[*echo.Echo]{
-			h:    e,
-			quit: make(chan os.Signal, 1),
-			exit: make(chan struct{}),
+			h:     e,
+			quit:  make(chan os.Signal, 1),
+			exit:  make(chan struct{}),
+			grace: _default_quit_delay,
+			hooks: []func() error{database.CloseAll},
 			s: &http.Server{
 				Addr:    _default_addr,
 				Handler: e,
 			},
 		},
 	}
+	if c, ok := config.Get[*config.Config](config.DICTKEY_CONFIG, config.DATAKEY_CONFIG); ok {
+		es.configure(c.ServerConfig)
+	}
+	es.SetupEchoServer(opts...)
 	return es
 }
 
+// WithShutdownHook add fn to run after the server shutdown, the databases are closed by default.
+func WithShutdownHook(fn func() error) EchoServerOption {
+	return func(es *EchoServer) {
+		es.hooks = append(es.hooks, fn)
+	}
+}
+
+// WithServerConfig configure the http.Server by sc instead of the loaded config.
+func WithServerConfig(sc config.ServerConfig) EchoServerOption {
+	return func(es *EchoServer) {
+		es.configure(sc)
+	}
+}
+
 func (es *EchoServer) SetupEchoServer(opts ...EchoServerOption) {
 	for _, fn := range opts {
 		fn(es)
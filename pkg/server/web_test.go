@@ -3,9 +3,11 @@ package server
 import (
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	_ "github.com/wendisx/puzzle/docs"
+	"github.com/wendisx/puzzle/pkg/config"
 	"github.com/wendisx/puzzle/pkg/router"
 )
 
@@ -68,3 +70,45 @@ func Test_many_route(t *testing.T) {
 	s.WithPeer(router.NewEchoCheckPeer())
 	s.Start()
 }
+
+// test http server configured by server config [passed]
+func Test_server_config(t *testing.T) {
+	s := InitEchoServer(WithServerConfig(config.ServerConfig{
+		Host:              "0.0.0.0",
+		Port:              8080,
+		ReadTimeout:       5,
+		ReadHeaderTimeout: 2,
+		WriteTimeout:      10,
+		IdleTimeout:       60,
+		ShutdownTimeout:   3,
+		MaxHeaderBytes:    []int{1, 16},
+	}))
+	hs := s.s
+	if hs.Addr != "0.0.0.0:8080" || hs.ReadTimeout != 5*time.Second || hs.ReadHeaderTimeout != 2*time.Second ||
+		hs.WriteTimeout != 10*time.Second || hs.IdleTimeout != time.Minute || hs.MaxHeaderBytes != 1<<16 || s.grace != 3*time.Second {
+		t.Fatalf("unexpected http server %+v with grace %s", hs, s.grace)
+	}
+	s = InitEchoServer(WithServerConfig(config.ServerConfig{Addr: ":9000", Host: "0.0.0.0", Port: 8080}))
+	if s.s.Addr != ":9000" || s.grace != _default_quit_delay {
+		t.Fatalf("expected addr :9000 but got %s", s.s.Addr)
+	}
+}
+
+// test shutdown hooks run in reverse order after stop [passed]
+func Test_shutdown_hook(t *testing.T) {
+	var order []int
+	s := InitEchoServer(
+		WithServerConfig(config.ServerConfig{Host: "127.0.0.1", Port: 0}),
+		WithShutdownHook(func() error { order = append(order, 1); return nil }),
+		WithShutdownHook(func() error { order = append(order, 2); return nil }),
+	)
+	s.WithPeer(router.NewEchoCheckPeer())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.Stop()
+	}()
+	s.Start()
+	if len(order) != 2 || order[0] != 2 || order[1] != 1 {
+		t.Fatalf("expected hooks in reverse order but got %v", order)
+	}
+}
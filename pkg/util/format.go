@@ -0,0 +1,396 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"github.com/wendisx/puzzle/pkg/clog"
+	"github.com/wendisx/puzzle/pkg/palette"
+	"go.yaml.in/yaml/v3"
+)
+
+/*
+	util.format -- [submodule]
+ParseFile, ParseFS and ParseBytes decode YAML, JSON, TOML and dotenv into the same dest.
+The format is detected by the extension (.yaml/.yml, .json, .toml, .env), or by the
+content for the others, like `.env.local` and `config`.
+1. YAML, JSON and TOML are decoded by the yaml tags of dest, or the json tags if dest
+only has json ones, so `[server]` and `"server": {}` fill the same fields as `server:`.
+2. dotenv keys are the field paths in upper snake case, like SERVER_READ_TIMEOUT for
+server.readTimeout, slices are separated by comma. A map[string]string dest gets all.
+*/
+
+const (
+	FORMAT_YAML   = "yaml"
+	FORMAT_JSON   = "json"
+	FORMAT_TOML   = "toml"
+	FORMAT_DOTENV = "dotenv"
+)
+
+var (
+	ErrFormat = errors.New("unsupported format")
+	ErrValue  = errors.New("invalid value")
+
+	_toml_table_pattern  = regexp.MustCompile(`(?m)^\s*\[[\w.\-" ]+\]\s*$`)
+	_toml_assign_pattern = regexp.MustCompile(`(?m)^\s*[\w\-"]+\s+=\s+\S`)
+	_dotenv_line_pattern = regexp.MustCompile(`^(export\s+)?[A-Za-z_][A-Za-z0-9_]*=`)
+)
+
+// DetectFormat return the format of file by the extension of name, or by data if unknown.
+func DetectFormat(name string, data []byte) string {
+	base := strings.ToLower(filepath.Base(name))
+	switch ext := filepath.Ext(base); {
+	case ext == ".yaml" || ext == ".yml":
+		return FORMAT_YAML
+	case ext == ".json":
+		return FORMAT_JSON
+	case ext == ".toml":
+		return FORMAT_TOML
+	case ext == ".env" || strings.HasPrefix(base, ".env"):
+		return FORMAT_DOTENV
+	}
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[' && json.Valid(trimmed)):
+		return FORMAT_JSON
+	case _toml_table_pattern.Match(trimmed) || _toml_assign_pattern.Match(trimmed):
+		return FORMAT_TOML
+	case isDotenv(trimmed):
+		return FORMAT_DOTENV
+	default:
+		return FORMAT_YAML
+	}
+}
+
+func isDotenv(data []byte) bool {
+	found := false
+	for line := range strings.SplitSeq(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !_dotenv_line_pattern.MatchString(line) {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// ParseFile decode the file into dest by its format.
+func ParseFile(path string, dest any) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return err
+	}
+	if err = ParseBytes(data, DetectFormat(absPath, data), dest); err != nil {
+		return fmt.Errorf("parse %s fail for %w", absPath, err)
+	}
+	clog.Info(fmt.Sprintf("parse %s successfully", palette.SkyBlue(absPath)))
+	return nil
+}
+
+// ParseFS decode the file of fsys into dest by its format, like the default config in embed.FS.
+func ParseFS(fsys fs.FS, name string, dest any) error {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	if err = ParseBytes(data, DetectFormat(name, data), dest); err != nil {
+		return fmt.Errorf("parse %s fail for %w", name, err)
+	}
+	clog.Info(fmt.Sprintf("parse %s successfully", palette.SkyBlue(name)))
+	return nil
+}
+
+// ParseBytes decode data of the format into dest.
+func ParseBytes(data []byte, format string, dest any) error {
+	switch format {
+	case FORMAT_YAML:
+		if len(bytes.TrimSpace(data)) == 0 {
+			return nil
+		}
+		if tagKey(reflect.TypeOf(dest)) == "json" {
+			var m map[string]any
+			if err := yaml.Unmarshal(data, &m); err != nil {
+				return err
+			}
+			return decodeMap(m, dest)
+		}
+		return yaml.Unmarshal(data, dest)
+	case FORMAT_JSON:
+		// json is yaml, decoded by yaml tags
+		if tagKey(reflect.TypeOf(dest)) == "yaml" {
+			return yaml.Unmarshal(data, dest)
+		}
+		return json.Unmarshal(data, dest)
+	case FORMAT_TOML:
+		var m map[string]any
+		if err := toml.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		return decodeMap(m, dest)
+	case FORMAT_DOTENV:
+		vars, err := ParseDotenv(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		return decodeDotenv(vars, dest)
+	default:
+		return fmt.Errorf("%w: %s", ErrFormat, format)
+	}
+}
+
+// decodeMap decode m into dest by its yaml tags, or json tags if it only has json ones.
+func decodeMap(m map[string]any, dest any) error {
+	if tagKey(reflect.TypeOf(dest)) == "json" {
+		raw, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(raw, dest)
+	}
+	raw, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(raw, dest)
+}
+
+// tagKey return yaml if the struct t has yaml tags, json if it only has json ones, or empty.
+func tagKey(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return ""
+	}
+	key := ""
+	for i := range t.NumField() {
+		tag := t.Field(i).Tag
+		if _, ok := tag.Lookup("yaml"); ok {
+			return "yaml"
+		}
+		if _, ok := tag.Lookup("json"); ok {
+			key = "json"
+		}
+	}
+	return key
+}
+
+// ParseDotenv read the KEY=VALUE lines, `export` prefixes, comments and quotes are supported.
+func ParseDotenv(r io.Reader) (map[string]string, error) {
+	vars := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		k, v, found := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if !found || k == "" {
+			return nil, fmt.Errorf("%w: line %d is not KEY=VALUE", ErrValue, n)
+		}
+		v = strings.TrimSpace(v)
+		switch {
+		case len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"':
+			unquoted, err := strconv.Unquote(v)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d has bad quotes", ErrValue, n)
+			}
+			v = unquoted
+		case len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'':
+			v = v[1 : len(v)-1]
+		default:
+			// inline comment of unquoted value
+			if i := strings.Index(v, " #"); i >= 0 {
+				v = strings.TrimSpace(v[:i])
+			}
+		}
+		vars[k] = v
+	}
+	return vars, sc.Err()
+}
+
+func decodeDotenv(vars map[string]string, dest any) error {
+	if m, ok := dest.(*map[string]string); ok {
+		if *m == nil {
+			*m = make(map[string]string, len(vars))
+		}
+		for k, v := range vars {
+			(*m)[k] = v
+		}
+		return nil
+	}
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w: dotenv into %T", ErrFormat, dest)
+	}
+	return decodeDotenvStruct(vars, v.Elem(), "")
+}
+
+func decodeDotenvStruct(vars map[string]string, v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := tagName(sf)
+		if name == "-" {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Pointer && fv.Type().Elem().Kind() == reflect.Struct {
+			if fv.IsNil() {
+				// allocate only if any key is under it
+				if !hasKeyPrefix(vars, UpperSnake(path)+"_") {
+					continue
+				}
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct && fv.Type() != reflect.TypeFor[time.Time]() {
+			if err := decodeDotenvStruct(vars, fv, path); err != nil {
+				return err
+			}
+			continue
+		}
+		key := UpperSnake(path)
+		str, found := vars[key]
+		if !found {
+			continue
+		}
+		if err := SetString(fv, str); err != nil {
+			return fmt.Errorf("%w for %s", err, key)
+		}
+	}
+	return nil
+}
+
+func hasKeyPrefix(vars map[string]string, prefix string) bool {
+	for k := range vars {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// tagName return the name of field by the yaml tag, json tag or its lower name.
+func tagName(sf reflect.StructField) string {
+	for _, key := range []string{"yaml", "json"} {
+		if name, _, _ := strings.Cut(sf.Tag.Get(key), ","); name != "" {
+			return name
+		}
+	}
+	return strings.ToLower(sf.Name)
+}
+
+// UpperSnake convert the field path to upper snake case, like server.readTimeout to SERVER_READ_TIMEOUT.
+func UpperSnake(path string) string {
+	var sb strings.Builder
+	runes := []rune(path)
+	for i, r := range runes {
+		if r == '.' || r == '-' {
+			sb.WriteByte('_')
+			continue
+		}
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				sb.WriteByte('_')
+			}
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+	return sb.String()
+}
+
+// SetString parse str into v of scalar, time.Duration or slice kind, slices are separated by comma.
+// A nil pointer, like *int of an optional value, is allocated and str is parsed into its element.
+func SetString(v reflect.Value, str string) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return SetString(v.Elem(), str)
+	}
+	if v.Type() == reflect.TypeFor[time.Duration]() {
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrValue, err.Error())
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	var err error
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(str)
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(str); err == nil {
+			v.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(str, 10, v.Type().Bits()); err == nil {
+			v.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(str, 10, v.Type().Bits()); err == nil {
+			v.SetUint(n)
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(str, v.Type().Bits()); err == nil {
+			v.SetFloat(f)
+		}
+	case reflect.Slice:
+		parts := []string{}
+		if str = strings.TrimSpace(str); str != "" {
+			parts = strings.Split(str, ",")
+		}
+		s := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, p := range parts {
+			if err = SetString(s.Index(i), strings.TrimSpace(p)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	default:
+		return fmt.Errorf("%w: unsupported type %s", ErrValue, v.Type())
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrValue, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+type (
+	formatServer struct {
+		Port    int           `yaml:"port"`
+		Timeout time.Duration `yaml:"readTimeout"`
+		Hosts   []string      `yaml:"hosts"`
+	}
+	formatOAuth struct {
+		Realm string `yaml:"realm"`
+	}
+	formatConfig struct {
+		Name   string       `yaml:"name"`
+		Server formatServer `yaml:"server"`
+		OAuth  *formatOAuth `yaml:"oauth"`
+	}
+	formatCmd struct {
+		Verb      string `json:"verb"`
+		ShortDesc string `json:"shortDesc"`
+	}
+)
+
+// test every format decodes into the same config [passed]
+func Test_parse_formats(t *testing.T) {
+	sources := map[string]string{
+		"config.yaml": "name: app\nserver:\n  port: 8080\n  readTimeout: 5s\n  hosts: [a, b]\n",
+		"config.json": `{"name": "app", "server": {"port": 8080, "readTimeout": "5s", "hosts": ["a", "b"]}}`,
+		"config.toml": "name = \"app\"\n[server]\nport = 8080\nreadTimeout = \"5s\"\nhosts = [\"a\", \"b\"]\n",
+		"config.env":  "# comment\nNAME=app\nexport SERVER_PORT=8080\nSERVER_READ_TIMEOUT=\"5s\"\nSERVER_HOSTS=a,b # inline\n",
+	}
+	fsys := fstest.MapFS{}
+	for name, src := range sources {
+		fsys[name] = &fstest.MapFile{Data: []byte(src)}
+	}
+	for name := range sources {
+		var c formatConfig
+		if err := ParseFS(fsys, name, &c); err != nil {
+			t.Fatalf("%s: %s", name, err.Error())
+		}
+		if c.Name != "app" || c.Server.Port != 8080 || c.Server.Timeout != 5*time.Second || strings.Join(c.Server.Hosts, ",") != "a,b" || c.OAuth != nil {
+			t.Fatalf("%s: unexpected config %+v", name, c)
+		}
+	}
+	// json tags only
+	var cmd formatCmd
+	if err := ParseBytes([]byte("verb: run\nshortDesc: run it\n"), FORMAT_YAML, &cmd); err != nil || cmd.ShortDesc != "run it" {
+		t.Fatalf("expected yaml by json tags but got %+v %v", cmd, err)
+	}
+	var vars map[string]string
+	if err := ParseBytes([]byte("A='x # y'\nB=\"1\\n2\"\n"), FORMAT_DOTENV, &vars); err != nil || vars["A"] != "x # y" || vars["B"] != "1\n2" {
+		t.Fatalf("unexpected vars %v %v", vars, err)
+	}
+}
+
+// test format detection by extension and content [passed]
+func Test_detect_format(t *testing.T) {
+	cases := []struct {
+		name, data, want string
+	}{
+		{"a.yml", "", FORMAT_YAML},
+		{".env.local", "", FORMAT_DOTENV},
+		{"config", `{"a": 1}`, FORMAT_JSON},
+		{"config", "[server]\nport = 1\n", FORMAT_TOML},
+		{"config", "PORT=1\nexport HOST=x\n", FORMAT_DOTENV},
+		{"config", "server:\n  port: 1\n", FORMAT_YAML},
+		{"config", "- a\n- b\n", FORMAT_YAML},
+	}
+	for _, c := range cases {
+		if got := DetectFormat(c.name, []byte(c.data)); got != c.want {
+			t.Fatalf("expected %s for %s %q but got %s", c.want, c.name, c.data, got)
+		}
+	}
+}
+
+// test field path to env name [passed]
+func Test_upper_snake(t *testing.T) {
+	for path, want := range map[string]string{
+		"server.readTimeout":       "SERVER_READ_TIMEOUT",
+		"swagger.domID":            "SWAGGER_DOM_ID",
+		"github.apiHost":           "GITHUB_API_HOST",
+		"database.sql.maxIdleConn": "DATABASE_SQL_MAX_IDLE_CONN",
+	} {
+		if got := UpperSnake(path); got != want {
+			t.Fatalf("expected %s but got %s", want, got)
+		}
+	}
+}
@@ -1,13 +1,23 @@
 package util
 
 import (
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/wendisx/puzzle/pkg/clog"
+)
+
+const (
+	// known to everyone, only for development and tests
+	_builtin_jwt_secret = "8b9a31ad-a4f5-4e64-b3d9-8e36ba4d3e1e"
 )
 
 var (
-	jwtSecret = []byte("8b9a31ad-a4f5-4e64-b3d9-8e36ba4d3e1e")
+	jwtSecret     = []byte(_builtin_jwt_secret)
+	jwtSecretSet  bool
+	jwtSecretMu   sync.RWMutex
+	jwtSecretWarn sync.Once
 )
 
 type (
@@ -28,6 +38,36 @@ func (jc *JwtClaim) Check() bool {
 	return true
 }
 
+// SetJwtSecret replace the built-in secret to sign and verify tokens, like the one from config,
+// an empty secret is ignored.
+func SetJwtSecret(secret []byte) {
+	if len(secret) == 0 {
+		return
+	}
+	jwtSecretMu.Lock()
+	defer jwtSecretMu.Unlock()
+	jwtSecret, jwtSecretSet = secret, true
+}
+
+// JwtSecretSet tell whether the built-in secret has been replaced by SetJwtSecret.
+func JwtSecretSet() bool {
+	jwtSecretMu.RLock()
+	defer jwtSecretMu.RUnlock()
+	return jwtSecretSet
+}
+
+func getJwtSecret() []byte {
+	jwtSecretMu.RLock()
+	secret, set := jwtSecret, jwtSecretSet
+	jwtSecretMu.RUnlock()
+	if !set {
+		jwtSecretWarn.Do(func() {
+			clog.Warn("jwt secret is not configured, tokens are signed by the built-in secret known to everyone, set server.jwtSecret")
+		})
+	}
+	return secret
+}
+
 func GenToken(jcc JwtCustomClaims) (string, error) {
 	claim := JwtClaim{
 		jwt.RegisteredClaims{
@@ -44,7 +84,7 @@ func GenToken(jcc JwtCustomClaims) (string, error) {
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
-	return token.SignedString(jwtSecret)
+	return token.SignedString(getJwtSecret())
 }
 
 func ParseToken(tokenStr string) (*JwtClaim, error) {
@@ -52,7 +92,7 @@ func ParseToken(tokenStr string) (*JwtClaim, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, jwt.ErrSignatureInvalid
 		}
-		return jwtSecret, nil
+		return getJwtSecret(), nil
 	})
 	if err != nil {
 		return nil, err
@@ -45,6 +45,9 @@ type (
 		SetupDefaultRules()
 		Check(s interface{}) vErrs
 	}
+	RuleChecker interface {
+		CheckRules(ruleStr string, v interface{}) []string
+	}
 
 	Rule struct {
 		key  string
@@ -288,3 +291,19 @@ func (va *validator) Check(s interface{}) vErrs {
 	}
 	return errs
 }
+
+// CheckRules check v with the rules like the check tag and return the failed rules, like min=1.
+func (va *validator) CheckRules(ruleStr string, v interface{}) []string {
+	var failed []string
+	for _, rule := range va.parseRules(ruleStr) {
+		if va.apply(rule.key)(v, rule.want) {
+			continue
+		}
+		if rule.want != "" {
+			failed = append(failed, rule.key+valueSep+rule.want)
+		} else {
+			failed = append(failed, rule.key)
+		}
+	}
+	return failed
+}
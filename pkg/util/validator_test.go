@@ -37,3 +37,15 @@ func Test_DefaultRules(t *testing.T) {
 		})
 	}
 }
+
+// test rules checked on a single value [passed]
+func Test_check_rules(t *testing.T) {
+	checker := NewValidator(nil)
+	checker.SetupDefaultRules()
+	if failed := checker.CheckRules("min=0,max=65535", 70000); len(failed) != 1 || failed[0] != "max=65535" {
+		t.Fatalf("expected max=65535 failed but got %v", failed)
+	}
+	if failed := checker.CheckRules("request,min=1", "dsn"); len(failed) != 0 {
+		t.Fatalf("expected passed but got %v", failed)
+	}
+}